@@ -0,0 +1,136 @@
+// Copyright 2019 Istio Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package controller
+
+import (
+	"context"
+	"reflect"
+
+	kubeApiAdmission "k8s.io/api/admissionregistration/v1beta1"
+	kubeApiCore "k8s.io/api/core/v1"
+	kubeErrors "k8s.io/apimachinery/pkg/api/errors"
+	kubeApiMeta "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/client-go/kubernetes"
+)
+
+// fieldManager identifies the controller's server-side apply field ownership.
+const fieldManager = "istio-validation-controller"
+
+// applyFunc server-side applies the encoded config of the resource with the
+// given field manager, optionally forcing ownership of conflicting fields.
+type applyFunc func(ctx context.Context, resource schema.GroupVersionResource, name string, data []byte, fieldManager string, force bool) error // nolint: lll
+
+// restApply returns the default applyFunc. The typed client doesn't support
+// the fieldManager and force options so the request is built directly.
+func restApply(client kubernetes.Interface) applyFunc {
+	return func(ctx context.Context, resource schema.GroupVersionResource, name string, data []byte, fieldManager string, force bool) error { // nolint: lll
+		req := client.AdmissionregistrationV1beta1().RESTClient().Patch(types.ApplyPatchType).
+			Resource(resource.Resource).
+			Name(name).
+			Param("fieldManager", fieldManager)
+		if force {
+			req = req.Param("force", "true")
+		}
+		return req.Context(ctx).Body(data).Do().Error()
+	}
+}
+
+// applyWebhookConfiguration writes the desired config of the kind with
+// server-side apply. The first apply to a config created by another field
+// manager, e.g. kubectl or an older controller using update, forces
+// ownership of the fields in the desired config. Later applies aren't
+// forced so conflicting changes by other managers are reported instead of
+// overwritten. current is nil if the config doesn't exist. It returns false
+// if the apply was refused or deferred.
+func (c *Controller) applyWebhookConfiguration(ctx context.Context, o Options, kind configKind, current, desired runtime.Object) (bool, error) {
+	name := objectName(desired)
+	resource := kind.resource()
+	var merged runtime.Object
+	if current != nil {
+		merged = kind.merge(current, desired, o.PreserveSelectors)
+	}
+	changed := current == nil || !reflect.DeepEqual(merged, current)
+	c.traceDecision("diff", "%v: changed=%v", name, changed)
+	if !changed {
+		scope.Info("Successfully updated "+resource, writeLogFields(name, "unchanged")...)
+		kind.reportUpdate(c, name)
+		return true, nil
+	}
+	var diff string
+	if current != nil {
+		diff = c.recordDiff(resource, name, current, merged)
+	}
+	if o.DryRun {
+		c.traceDecision("write", "%v: dry-run apply", name)
+		scope.Infof("Dry-run: would apply %v %v: %v", resource, name, diff)
+		kind.reportUpdate(c, name)
+		return true, nil
+	}
+	if c.throttleWrite(o, name) {
+		return false, nil
+	}
+	if c.preApplyRejected(o, kind, desired) {
+		return false, nil
+	}
+
+	force := false
+	if current != nil && !hasApplyManager(kind.view(current), fieldManager) {
+		force = true
+		scope.Infof("Taking server-side apply ownership of %v %v from field managers %v",
+			resource, name, fieldManagers(kind.view(current)))
+	}
+
+	applied := desired.DeepCopyObject()
+	applied.GetObjectKind().SetGroupVersionKind(kind.gvk)
+	applied.(kubeApiMeta.Object).SetResourceVersion("")
+	data, err := runtime.Encode(codec, applied)
+	if err != nil {
+		return false, err
+	}
+	if err := c.applyConfig(ctx, kind.gvr, name, data, fieldManager, force); err != nil {
+		kind.reportUpdateError(c.metrics, name, kubeErrors.ReasonForError(err))
+		c.recordEvent(kind.gvk, name, kubeApiCore.EventTypeWarning, eventReasonUpdateFailed, "Apply failed: %v", err)
+		return false, c.handleWriteError("apply", resource, name, err)
+	}
+	c.recordWrite(ctx)
+	c.traceDecision("write", "%v: applied force=%v", name, force)
+	scope.Info("Successfully applied "+resource, writeLogFields(name, "applied")...)
+	kind.reportUpdate(c, name)
+	c.recordEvent(kind.gvk, name, kubeApiCore.EventTypeNormal, eventReasonApplied, "Applied by %v", fieldManager)
+	return true, nil
+}
+
+// hasApplyManager returns true if the manager owns fields of the config through server-side apply.
+func hasApplyManager(config *kubeApiAdmission.ValidatingWebhookConfiguration, manager string) bool {
+	for _, entry := range config.ManagedFields {
+		if entry.Manager == manager && entry.Operation == kubeApiMeta.ManagedFieldsOperationApply {
+			return true
+		}
+	}
+	return false
+}
+
+func fieldManagers(config *kubeApiAdmission.ValidatingWebhookConfiguration) []string {
+	var managers []string
+	for _, entry := range config.ManagedFields {
+		if !containsName(managers, entry.Manager) {
+			managers = append(managers, entry.Manager)
+		}
+	}
+	return managers
+}
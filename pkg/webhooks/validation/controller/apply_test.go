@@ -0,0 +1,183 @@
+// Copyright 2019 Istio Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package controller
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"testing"
+
+	. "github.com/onsi/gomega"
+	kubeApiAdmission "k8s.io/api/admissionregistration/v1beta1"
+	kubeApiMeta "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/client-go/rest"
+	k8stesting "k8s.io/client-go/testing"
+)
+
+type fakeApplyCall struct {
+	resource     schema.GroupVersionResource
+	name         string
+	fieldManager string
+	force        bool
+	applied      runtime.Object
+}
+
+// fakeApply replaces the controller's apply request with one recorded as a
+// patch action on the fake clientset. The fake object tracker doesn't
+// support apply patches so the decoded config is recorded instead.
+func fakeApply(c *fakeController) *[]fakeApplyCall {
+	var calls []fakeApplyCall
+	c.applyConfig = func(_ context.Context, resource schema.GroupVersionResource, name string, data []byte, fieldManager string, force bool) error { // nolint: lll
+		var applied runtime.Object = &kubeApiAdmission.ValidatingWebhookConfiguration{}
+		if resource == mutatingConfigResource {
+			applied = &kubeApiAdmission.MutatingWebhookConfiguration{}
+		}
+		if _, _, err := codec.Decode(data, nil, applied); err != nil {
+			return err
+		}
+		calls = append(calls, fakeApplyCall{resource, name, fieldManager, force, applied})
+		action := k8stesting.NewRootPatchAction(resource, name, types.ApplyPatchType, data)
+		_, err := c.Invokes(action, nil)
+		return err
+	}
+	c.PrependReactor("patch", "*",
+		func(action k8stesting.Action) (bool, runtime.Object, error) {
+			return true, nil, nil
+		})
+	return &calls
+}
+
+func TestServerSideApplyAdoption(t *testing.T) {
+	g := NewGomegaWithT(t)
+	c := createTestController(t, func(o *Options) {
+		o.UseServerSideApply = true
+	})
+	calls := fakeApply(c)
+	c.endpointStore.Add(istiodEndpoint)
+
+	// a config owned by a legacy field manager.
+	legacy := webhookConfigWithCABundle0.DeepCopyObject().(*kubeApiAdmission.ValidatingWebhookConfiguration)
+	legacy.Webhooks[0].ClientConfig.CABundle = caBundle1
+	legacy.ManagedFields = []kubeApiMeta.ManagedFieldsEntry{{
+		Manager:   "kubectl",
+		Operation: kubeApiMeta.ManagedFieldsOperationUpdate,
+	}}
+	c.configStore.Add(legacy)
+
+	reconcileHelper(t, c)
+	g.Expect(c.Actions()).Should(HaveLen(1))
+	g.Expect(c.Actions()[0].Matches("patch", "validatingwebhookconfigurations")).Should(BeTrue())
+	g.Expect(*calls).Should(HaveLen(1))
+	g.Expect((*calls)[0].force).Should(BeTrue(), "ownership should be taken from the legacy manager")
+	g.Expect((*calls)[0].fieldManager).Should(Equal(fieldManager))
+	g.Expect((*calls)[0].applied.(*kubeApiAdmission.ValidatingWebhookConfiguration).Webhooks).
+		Should(Equal(webhookConfigWithCABundle0.Webhooks))
+
+	// once the controller owns fields through apply, later applies aren't forced.
+	owned := legacy.DeepCopy()
+	owned.ManagedFields = append(owned.ManagedFields, kubeApiMeta.ManagedFieldsEntry{
+		Manager:   fieldManager,
+		Operation: kubeApiMeta.ManagedFieldsOperationApply,
+	})
+	c.configStore.Update(owned)
+	reconcileHelper(t, c)
+	g.Expect(*calls).Should(HaveLen(2))
+	g.Expect((*calls)[1].force).Should(BeFalse())
+
+	// no apply once the config is up to date.
+	upToDate := webhookConfigWithCABundle0.DeepCopyObject().(*kubeApiAdmission.ValidatingWebhookConfiguration)
+	upToDate.ManagedFields = owned.ManagedFields
+	c.configStore.Update(upToDate)
+	reconcileHelper(t, c)
+	g.Expect(c.Actions()).Should(BeEmpty())
+}
+
+func TestRestApply(t *testing.T) {
+	g := NewGomegaWithT(t)
+
+	type request struct {
+		method, path, contentType string
+		query                     url.Values
+	}
+	response := webhookConfigWithCABundle0.DeepCopy()
+	response.TypeMeta = kubeApiMeta.TypeMeta{APIVersion: kubeApiAdmission.SchemeGroupVersion.String(), Kind: configGVK.Kind}
+	encoded, err := json.Marshal(response)
+	g.Expect(err).Should(Succeed())
+
+	requests := make(chan request, 1)
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requests <- request{r.Method, r.URL.Path, r.Header.Get("Content-Type"), r.URL.Query()}
+		w.Header().Set("Content-Type", "application/json")
+		_, _ = w.Write(encoded)
+	}))
+	defer server.Close()
+
+	client, err := NewClient(&rest.Config{Host: server.URL}, "")
+	g.Expect(err).Should(Succeed())
+	apply := restApply(client)
+
+	data := []byte(runtime.EncodeOrDie(codec, webhookConfigWithCABundle0))
+	cases := []struct {
+		resource schema.GroupVersionResource
+		force    bool
+	}{
+		{validatingConfigResource, false},
+		{validatingConfigResource, true},
+		{mutatingConfigResource, false},
+	}
+	for _, tc := range cases {
+		force := tc.force
+		g.Expect(apply(context.Background(), tc.resource, galleyWebhookName, data, fieldManager, force)).Should(Succeed())
+
+		req := <-requests
+		g.Expect(req.method).Should(Equal(http.MethodPatch))
+		g.Expect(req.path).Should(Equal("/apis/admissionregistration.k8s.io/v1beta1/" + tc.resource.Resource + "/" +
+			galleyWebhookName))
+		g.Expect(req.contentType).Should(Equal(string(types.ApplyPatchType)))
+		g.Expect(req.query.Get("fieldManager")).Should(Equal("istio-validation-controller"))
+		if force {
+			g.Expect(req.query.Get("force")).Should(Equal("true"))
+		} else {
+			g.Expect(req.query).ShouldNot(HaveKey("force"))
+		}
+	}
+}
+
+func TestApplyOwnershipMigration(t *testing.T) {
+	g := NewGomegaWithT(t)
+
+	// a config owned by a legacy field manager and the update path of the controller.
+	legacy := webhookConfigWithCABundle0.DeepCopyObject().(*kubeApiAdmission.ValidatingWebhookConfiguration)
+	legacy.ManagedFields = []kubeApiMeta.ManagedFieldsEntry{
+		{Manager: "kubectl", Operation: kubeApiMeta.ManagedFieldsOperationUpdate},
+		{Manager: fieldManager, Operation: kubeApiMeta.ManagedFieldsOperationUpdate},
+	}
+	g.Expect(hasApplyManager(legacy, fieldManager)).Should(BeFalse(), "ownership should be taken on the first apply")
+	g.Expect(fieldManagers(legacy)).Should(Equal([]string{"kubectl", fieldManager}))
+
+	// once the controller owns fields through apply, ownership isn't taken again.
+	legacy.ManagedFields = append(legacy.ManagedFields, kubeApiMeta.ManagedFieldsEntry{
+		Manager:   fieldManager,
+		Operation: kubeApiMeta.ManagedFieldsOperationApply,
+	})
+	g.Expect(hasApplyManager(legacy, fieldManager)).Should(BeTrue())
+	g.Expect(fieldManagers(legacy)).Should(Equal([]string{"kubectl", fieldManager}))
+}
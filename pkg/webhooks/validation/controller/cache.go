@@ -0,0 +1,110 @@
+// Copyright 2019 Istio Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package controller
+
+import (
+	"crypto/sha256"
+	"fmt"
+	"sort"
+	"sync"
+
+	kubeApiAdmission "k8s.io/api/admissionregistration/v1beta1"
+)
+
+// desiredConfigCache caches the contents of the local template and CA
+// files along with the configs built from them. File contents are kept
+// until the file watcher reports a change. Built configs are keyed by the
+// content hash of their inputs so unchanged content is not decoded again.
+type desiredConfigCache struct {
+	mu      sync.Mutex
+	files   map[string][]byte
+	desired map[string]cachedDesiredConfig
+}
+
+type cachedDesiredConfig struct {
+	key    [sha256.Size]byte
+	config *kubeApiAdmission.ValidatingWebhookConfiguration
+}
+
+func newDesiredConfigCache() *desiredConfigCache {
+	return &desiredConfigCache{
+		files:   make(map[string][]byte),
+		desired: make(map[string]cachedDesiredConfig),
+	}
+}
+
+func desiredConfigKey(generation uint64, webhook, caBundle []byte, webhookCABundles map[string][]byte) [sha256.Size]byte {
+	h := sha256.New()
+	_, _ = fmt.Fprintf(h, "%d\n", generation)
+	_, _ = h.Write(webhook)
+	_, _ = h.Write(caBundle)
+	names := make([]string, 0, len(webhookCABundles))
+	for name := range webhookCABundles {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		_, _ = h.Write([]byte(name))
+		_, _ = h.Write(webhookCABundles[name])
+	}
+	var key [sha256.Size]byte
+	copy(key[:], h.Sum(nil))
+	return key
+}
+
+func (dc *desiredConfigCache) getFile(path string) ([]byte, bool) {
+	dc.mu.Lock()
+	defer dc.mu.Unlock()
+	contents, ok := dc.files[path]
+	return contents, ok
+}
+
+func (dc *desiredConfigCache) putFile(path string, contents []byte) {
+	dc.mu.Lock()
+	defer dc.mu.Unlock()
+	dc.files[path] = contents
+}
+
+func (dc *desiredConfigCache) invalidateFile(path string) {
+	dc.mu.Lock()
+	defer dc.mu.Unlock()
+	delete(dc.files, path)
+}
+
+// getDesired returns a copy of the cached config if it was built from the
+// same inputs, or nil otherwise.
+func (dc *desiredConfigCache) getDesired(name string, key [sha256.Size]byte) *kubeApiAdmission.ValidatingWebhookConfiguration {
+	dc.mu.Lock()
+	defer dc.mu.Unlock()
+	cached, ok := dc.desired[name]
+	if !ok || cached.key != key {
+		return nil
+	}
+	return cached.config.DeepCopy()
+}
+
+func (dc *desiredConfigCache) putDesired(name string, key [sha256.Size]byte, config *kubeApiAdmission.ValidatingWebhookConfiguration) {
+	dc.mu.Lock()
+	defer dc.mu.Unlock()
+	dc.desired[name] = cachedDesiredConfig{key: key, config: config.DeepCopy()}
+}
+
+// reset drops all cached file contents and configs.
+func (dc *desiredConfigCache) reset() {
+	dc.mu.Lock()
+	defer dc.mu.Unlock()
+	dc.files = make(map[string][]byte)
+	dc.desired = make(map[string]cachedDesiredConfig)
+}
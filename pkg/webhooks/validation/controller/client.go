@@ -0,0 +1,100 @@
+// Copyright 2019 Istio Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package controller
+
+import (
+	"context"
+
+	kubeApiRbac "k8s.io/api/rbac/v1"
+	kubeApiMeta "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/rest"
+)
+
+// kubeClient sends the requests of a reconcile for cluster-scoped
+// resources. The typed clients of this client-go version take no context,
+// so a request couldn't be canceled when the reconcile times out.
+type kubeClient interface {
+	get(ctx context.Context, resource schema.GroupVersionResource, name string, into runtime.Object) error
+	create(ctx context.Context, resource schema.GroupVersionResource, obj, into runtime.Object) error
+	update(ctx context.Context, resource schema.GroupVersionResource, name string, obj, into runtime.Object) error
+	delete(ctx context.Context, resource schema.GroupVersionResource, name string) error
+}
+
+// newKubeClientFunc returns the kubeClient sending requests with client.
+type newKubeClientFunc func(client kubernetes.Interface) kubeClient
+
+// restKubeClient is the default kubeClient. It builds the requests of the
+// typed clients on their REST clients with the context, which cancels a
+// request still in flight at the transport once the context is done.
+type restKubeClient struct {
+	client kubernetes.Interface
+}
+
+func newRESTKubeClient(client kubernetes.Interface) kubeClient {
+	return restKubeClient{client}
+}
+
+func (r restKubeClient) restClient(resource schema.GroupVersionResource) rest.Interface {
+	if resource.Group == kubeApiRbac.GroupName {
+		return r.client.RbacV1().RESTClient()
+	}
+	return r.client.AdmissionregistrationV1beta1().RESTClient()
+}
+
+func (r restKubeClient) get(ctx context.Context, resource schema.GroupVersionResource, name string, into runtime.Object) error {
+	return r.restClient(resource).Get().
+		Resource(resource.Resource).
+		Name(name).
+		Context(ctx).
+		Do().
+		Into(into)
+}
+
+func (r restKubeClient) create(ctx context.Context, resource schema.GroupVersionResource, obj, into runtime.Object) error {
+	return r.restClient(resource).Post().
+		Resource(resource.Resource).
+		Body(obj).
+		Context(ctx).
+		Do().
+		Into(into)
+}
+
+func (r restKubeClient) update(
+	ctx context.Context,
+	resource schema.GroupVersionResource,
+	name string,
+	obj, into runtime.Object,
+) error {
+	return r.restClient(resource).Put().
+		Resource(resource.Resource).
+		Name(name).
+		Body(obj).
+		Context(ctx).
+		Do().
+		Into(into)
+}
+
+func (r restKubeClient) delete(ctx context.Context, resource schema.GroupVersionResource, name string) error {
+	return r.restClient(resource).Delete().
+		Resource(resource.Resource).
+		Name(name).
+		Body(&kubeApiMeta.DeleteOptions{}).
+		Context(ctx).
+		Do().
+		Error()
+}
@@ -0,0 +1,72 @@
+// Copyright 2019 Istio Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package controller
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	. "github.com/onsi/gomega"
+	kubeApiAdmission "k8s.io/api/admissionregistration/v1beta1"
+	kubeApiRbac "k8s.io/api/rbac/v1"
+	kubeApiMeta "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/rest"
+)
+
+func TestRESTKubeClient(t *testing.T) {
+	g := NewGomegaWithT(t)
+
+	response := webhookConfigWithCABundle0.DeepCopy()
+	response.TypeMeta = kubeApiMeta.TypeMeta{APIVersion: kubeApiAdmission.SchemeGroupVersion.String(), Kind: configGVK.Kind}
+	encoded, err := json.Marshal(response)
+	g.Expect(err).Should(Succeed())
+
+	requests := make(chan string, 1)
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requests <- r.Method + " " + r.URL.Path
+		w.Header().Set("Content-Type", "application/json")
+		_, _ = w.Write(encoded)
+	}))
+	defer server.Close()
+
+	client, err := NewClient(&rest.Config{Host: server.URL}, "")
+	g.Expect(err).Should(Succeed())
+	kube := newRESTKubeClient(client)
+	ctx := context.Background()
+	path := "/apis/admissionregistration.k8s.io/v1beta1/validatingwebhookconfigurations"
+
+	got := &kubeApiAdmission.ValidatingWebhookConfiguration{}
+	g.Expect(kube.get(ctx, validatingConfigResource, galleyWebhookName, got)).Should(Succeed())
+	g.Expect(<-requests).Should(Equal("GET " + path + "/" + galleyWebhookName))
+	g.Expect(got.Webhooks).Should(Equal(webhookConfigWithCABundle0.Webhooks))
+
+	g.Expect(kube.create(ctx, validatingConfigResource, webhookConfigWithCABundle0,
+		&kubeApiAdmission.ValidatingWebhookConfiguration{})).Should(Succeed())
+	g.Expect(<-requests).Should(Equal("POST " + path))
+
+	g.Expect(kube.update(ctx, validatingConfigResource, galleyWebhookName, webhookConfigWithCABundle0,
+		&kubeApiAdmission.ValidatingWebhookConfiguration{})).Should(Succeed())
+	g.Expect(<-requests).Should(Equal("PUT " + path + "/" + galleyWebhookName))
+
+	g.Expect(kube.delete(ctx, validatingConfigResource, galleyWebhookName)).Should(Succeed())
+	g.Expect(<-requests).Should(Equal("DELETE " + path + "/" + galleyWebhookName))
+
+	// clusterroles are requested on the rbac client.
+	_ = kube.get(ctx, clusterRoleResource, istiodClusterRole, &kubeApiRbac.ClusterRole{})
+	g.Expect(<-requests).Should(Equal("GET /apis/rbac.authorization.k8s.io/v1/clusterroles/" + istiodClusterRole))
+}
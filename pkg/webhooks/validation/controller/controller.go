@@ -16,30 +16,54 @@
 package controller
 
 import (
+	"bytes"
+	"context"
+	"crypto/sha256"
 	"crypto/x509"
+	"encoding/base64"
 	"encoding/pem"
 	"errors"
 	"fmt"
 	"io/ioutil"
+	"math/rand"
+	"os"
 	"reflect"
+	"sort"
+	"strings"
+	"sync"
+	"text/template"
 	"time"
 
+	"github.com/fsnotify/fsnotify"
 	"github.com/hashicorp/go-multierror"
+	"go.uber.org/zap"
 	kubeApiAdmission "k8s.io/api/admissionregistration/v1beta1"
 	kubeApiApp "k8s.io/api/apps/v1"
 	kubeApiCore "k8s.io/api/core/v1"
 	kubeApiRbac "k8s.io/api/rbac/v1"
+	kubeApiExtensions "k8s.io/apiextensions-apiserver/pkg/apis/apiextensions/v1beta1"
+	apiextensionsclient "k8s.io/apiextensions-apiserver/pkg/client/clientset/clientset"
+	apiextensionsinformers "k8s.io/apiextensions-apiserver/pkg/client/informers/externalversions"
 	kubeErrors "k8s.io/apimachinery/pkg/api/errors"
 	"k8s.io/apimachinery/pkg/api/meta"
 	kubeApiMeta "k8s.io/apimachinery/pkg/apis/meta/v1"
+	kubeLabels "k8s.io/apimachinery/pkg/labels"
 	"k8s.io/apimachinery/pkg/runtime"
 	"k8s.io/apimachinery/pkg/runtime/schema"
 	"k8s.io/apimachinery/pkg/runtime/serializer/json"
 	"k8s.io/apimachinery/pkg/runtime/serializer/versioning"
+	"k8s.io/apimachinery/pkg/util/clock"
 	utilruntime "k8s.io/apimachinery/pkg/util/runtime"
+	"k8s.io/apimachinery/pkg/util/validation"
 	"k8s.io/client-go/informers"
+	"k8s.io/client-go/informers/admissionregistration"
+	"k8s.io/client-go/informers/apps"
+	"k8s.io/client-go/informers/core"
+	"k8s.io/client-go/informers/rbac"
 	"k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/rest"
 	"k8s.io/client-go/tools/cache"
+	"k8s.io/client-go/tools/record"
 	"k8s.io/client-go/util/workqueue"
 
 	"istio.io/pkg/filewatcher"
@@ -50,19 +74,116 @@ import (
 
 var scope = log.RegisterScope("validationController", "validation webhook controller", 0)
 
+const (
+	revisionLabel  = "istio.io/rev"
+	managedByLabel = "app.kubernetes.io/managed-by"
+	managedByValue = "istio-validation-controller"
+
+	// pauseAnnotation set to "true" on a live webhook config pauses
+	// reconciliation until it is removed, e.g. during incident response.
+	pauseAnnotation = "validation.istio.io/pause"
+
+	userAgentName = "istiod-validation-controller"
+)
+
 type Options struct {
+	// Client used for all requests to the kube-apiserver, including those
+	// of the informers. Use NewClient to create a client whose requests
+	// carry the controller's user-agent.
 	Client kubernetes.Interface
 
+	// Client used to watch the RequiredCRDs. Only required when
+	// RequiredCRDs is non-empty.
+	APIExtensionsClient apiextensionsclient.Interface
+
+	// Names of CustomResourceDefinitions which must report the Established
+	// condition before the webhook config is installed. This avoids
+	// blocking the creation of the CRDs validated by the webhook during a
+	// fresh install.
+	RequiredCRDs []string
+
 	// Istio system namespace in which galley and istiod reside.
 	WatchedNamespace string
 
-	// Periodically resync with the kube-apiserver. Set to zero to disable.
+	// Periodically resync with the kube-apiserver and reconcile, even if
+	// nothing changed. Set to zero to disable.
 	ResyncPeriod time.Duration
 
+	// Time after the controller starts during which the webhook config is
+	// not written, giving the webhook server time to stabilize before
+	// enforcement begins. The first write also requires the endpoint to be
+	// ready once the period elapses. Set to zero to disable.
+	StartupGracePeriod time.Duration
+
+	// Time after the controller starts within which the endpoint is
+	// expected to become ready. If it isn't, a warning event is recorded
+	// on the webhook configs and an error is logged once so a stuck rollout
+	// is noticed. The controller keeps waiting for the endpoint either way.
+	// Set to zero to disable.
+	EndpointReadyTimeout time.Duration
+
+	// Upper bound of the random delay of the initial reconcile after
+	// Start, to spread the writes of controllers started together, e.g.
+	// after a rollout. No delay when zero.
+	StartupJitter time.Duration
+
+	// Number of workers reconciling concurrently. Defaults to 1. Requests
+	// with the same key are never reconciled concurrently, and writes to
+	// the same config are serialized between workers.
+	Workers int
+
+	// Minimum time between writes of the webhook config to the
+	// kube-apiserver. Changes observed in the meantime are coalesced into
+	// the next permitted write. Set to zero to disable.
+	MinUpdateInterval time.Duration
+
 	// File path to the x509 certificate bundle used by the webhook server
 	// and patched into the webhook config.
 	CAPath string
 
+	// If true, Validate checks that the CA and webhook config files exist
+	// and are readable, so a mistyped path fails at startup rather than on
+	// the first reconcile.
+	CheckFilesExist bool
+
+	// If non-nil, the output level of the controller's log scope, set when
+	// the controller is created, e.g. DebugLevel while debugging. The scope
+	// is shared by every controller in the process. Left unchanged when nil.
+	LogLevel *log.Level
+
+	// Clock skew tolerated at either end of the validity window of the CA
+	// bundle certificates. Certificates outside of their validity window
+	// are not patched into the webhook config.
+	CertValiditySkew time.Duration
+
+	// Verifies the CA bundle before it is patched into the webhook configs,
+	// e.g. to accept trust formats other than PEM. It must reject bundles
+	// it can't parse and must not transform them since the verified bytes
+	// are used as is. Defaults to verifying every block of a PEM bundle is
+	// an x509 certificate.
+	CABundleVerifier func(caBundle []byte) error
+
+	// Name of the Secret in WatchedNamespace holding the x509 certificate
+	// bundle under the ca.crt or cert-chain.pem key. When set, the bundle
+	// is read from the Secret instead of CAPath and changes to the Secret
+	// trigger reconciliation.
+	CASecretName string
+
+	// Name of the ConfigMap in WatchedNamespace holding the x509
+	// certificate bundle under CAConfigMapKey, e.g. istio-ca-root-cert.
+	// When set, the bundle is read from the ConfigMap instead of CAPath and
+	// changes to the ConfigMap trigger reconciliation. CASecretName takes
+	// precedence over CAConfigMapName, which takes precedence over CAPath.
+	CAConfigMapName string
+
+	// Key of the CA bundle in CAConfigMapName. Defaults to root-cert.pem.
+	CAConfigMapKey string
+
+	// Optional file path to the serving certificate of the webhook server.
+	// When set, the certificate is watched and verified to chain to the CA
+	// bundle whenever either file changes.
+	ServingCertPath string
+
 	// Name of the k8s validatingwebhookconfiguration resource. This should
 	// match the name in the config template.
 	WebhookConfigName string
@@ -70,127 +191,932 @@ type Options struct {
 	// File path to the validatingwebhookconfiguration template.
 	WebhookConfigPath string
 
+	// Names of the k8s validatingwebhookconfiguration resources to manage,
+	// in the order they should be applied. Configs are removed in reverse
+	// order when UnregisterValidationWebhook is set. When empty, only the
+	// WebhookConfigName config is managed.
+	WebhookConfigNames []string
+
+	// File paths to the validatingwebhookconfiguration templates, keyed by
+	// the names in WebhookConfigNames. The name in each template should
+	// match its key.
+	WebhookConfigPaths map[string]string
+
+	// If set, the validatingwebhookconfigurations matching the selector are
+	// managed instead of WebhookConfigName, e.g. configs with generated names
+	// which share an ownership label. Each is patched from the template at
+	// WebhookConfigPath. Matching configs are updated but never created.
+	WebhookConfigSelector kubeLabels.Selector
+
+	// If true, the controller also manages the mutatingwebhookconfiguration
+	// named MutatingWebhookConfigName, patching the CA bundle into the
+	// template at MutatingWebhookConfigPath. It is applied after the
+	// validating configs and removed before them. It is built, checked,
+	// guarded and written like the validating configs, except that
+	// FailurePolicyOverride, ObjectSelector and WebhookTimeoutSeconds don't
+	// apply to it and PreApplyMutating is called instead of PreApply.
+	ManageMutatingWebhook bool
+
+	// Name of the k8s mutatingwebhookconfiguration resource. This should
+	// match the name in the config template.
+	MutatingWebhookConfigName string
+
+	// File path to the mutatingwebhookconfiguration template.
+	MutatingWebhookConfigPath string
+
 	// Name of the service running the webhook server.
 	ServiceName string
 
+	// Namespace of the service running the webhook server. Defaults to
+	// WatchedNamespace when empty.
+	ServiceNamespace string
+
+	// Name of the port of the webhook service which serves the webhook. If
+	// set, the port is looked up on the service and its number is set on
+	// every webhook which calls the service, in place of the port of the
+	// template, so the port number may change without a template update.
+	ServicePortName string
+
+	// Namespace of the galley deployment. Defaults to WatchedNamespace
+	// when empty.
+	GalleyNamespace string
+
+	// Minimum number of ready webhook server addresses required before the
+	// webhook config is installed. Addresses the Endpoints report as not
+	// ready, e.g. pods terminating during a voluntary disruption, are not
+	// counted. Values less than or equal to one preserve the default of
+	// requiring a single ready address.
+	MinReadyEndpoints int
+
 	// name of the galley deployment in the watched namespace.
 	// When non-empty the controller will defer reconciling config
 	// until the named deployment no longer exists.
 	GalleyDeploymentName string
 
+	// Whether to defer reconciling config while the GalleyDeploymentName
+	// deployment is running. Defaults to true when nil. Setting it to false
+	// stops deferring to galley, e.g. during a migration, while keeping the
+	// name configured.
+	DeferToGalley *bool
+
+	// Time the controller keeps deferring to a GalleyDeploymentName
+	// deployment which has replicas but none of them available, e.g.
+	// during a stuck rollout, before it takes over reconciling config.
+	// Defaults to 5 minutes when zero.
+	GalleyUnavailableGracePeriod time.Duration
+
 	// Name of the ClusterRole that the controller should assign
 	// cluster-scoped ownership to. The webhook config will be GC'd
 	// when this ClusterRole is deleted.
 	ClusterRoleName string
 
+	// Number of times the lookup of the ClusterRoleName at startup is
+	// retried with exponential backoff, e.g. while the kube-apiserver is
+	// briefly unavailable. Defaults to 5 when zero. A ClusterRole which
+	// is not found is not retried.
+	ClusterRoleLookupRetries int
+
 	// If true, the controller will run but actively try to remove the
 	// validatingwebhookconfiguration instead of creating it. This is
 	// useful in cases where validation was previously enabled and
 	// subsequently disabled. The controller can clean up after itself
 	// without relying on the user to manually delete configs.
 	UnregisterValidationWebhook bool
+
+	// Names of webhooks whose caBundle is managed elsewhere, e.g. by an
+	// external CA. The caBundle from the template is left untouched for
+	// these webhooks instead of being overwritten with the CAPath bundle.
+	SkipCAInjectionWebhooks []string
+
+	// If set, every webhook's namespaceSelector must be limited to these
+	// namespaces by a kubernetes.io/metadata.name In requirement or label,
+	// as a guardrail against a template matching other teams' namespaces.
+	// A config with a webhook which may match other namespaces is refused.
+	ManagedNamespaces []string
+
+	// File paths of x509 certificate bundles appended to the CA bundle,
+	// e.g. the new root while a CA is rotated, so the webhook config trusts
+	// both roots.
+	AdditionalCAPaths []string
+
+	// File paths of the x509 certificate bundles of webhooks which
+	// terminate TLS with a different CA, keyed by webhook name. Webhooks
+	// which aren't listed get the CAPath bundle.
+	PerWebhookCAPaths map[string]string
+
+	// If true, namespaceSelector and objectSelector labels and expressions
+	// added out-of-band to the installed webhooks are preserved when the
+	// webhook config is updated from the template. Otherwise the selectors
+	// of the template replace them.
+	PreserveSelectors bool
+
+	// If true, every webhook which calls a Service must have a non-empty
+	// caBundle once the config is built. This catches webhooks excluded
+	// from CA injection whose template doesn't provide a caBundle.
+	RequireCABundle bool
+
+	// If true, the caBundle of an installed webhook may be replaced by an
+	// empty bundle or one with fewer PEM certificates. Otherwise such an
+	// update is refused since it's likely a partially written CA file, e.g.
+	// mid-rotation.
+	AllowCABundleShrink bool
+
+	// If true, webhooks which fail closed are written with failurePolicy
+	// Ignore while the endpoint is no longer ready after it was first ready,
+	// and restored once it is ready again, so requests aren't rejected while
+	// the webhook server is down. Webhooks which already fail open are
+	// unchanged, and the configs are otherwise kept up to date, e.g. with a
+	// rotated CA. If false, the webhook configs aren't written at all while
+	// the endpoint isn't ready.
+	FailOpenWhenUnready bool
+
+	// If true, an existing webhook config without an owner reference to the
+	// ClusterRoleName or the managed-by label of this controller, e.g. left
+	// over from an older install, is not updated and the update error is
+	// reported. Otherwise it is adopted with a warning, so configs installed
+	// before the owner references were set keep getting the CA bundle. Only
+	// applies when the ClusterRole is found.
+	RefuseUnowned bool
+
+	// If true, webhooks which fail closed but have sideEffects Unknown or
+	// Some are reported as a config error instead of a warning. The warning
+	// is logged once per template and options rather than every reconcile.
+	StrictSideEffects bool
+
+	// If true, webhooks which call a Service other than ServiceName in the
+	// service namespace are reported as a config error instead of a
+	// warning.
+	StrictServiceCheck bool
+
+	// If true, a config without any webhooks is installed as is. Otherwise
+	// it is reported as a config error since an empty, e.g. truncated,
+	// template silently disables validation.
+	AllowEmptyWebhooks bool
+
+	// If true, webhooks which reuse the name of an earlier webhook in the
+	// template are dropped with a warning. Otherwise duplicate names are
+	// reported as a config error.
+	DedupWebhooks bool
+
+	// If true, the webhook configs are written with server-side apply
+	// instead of create and update.
+	UseServerSideApply bool
+
+	// If true, the webhook config file is rendered as a text/template with
+	// the Options as data before it is decoded, e.g. {{ .ServiceName }},
+	// {{ .WatchedNamespace }} or {{ .WebhookConfigName }}.
+	RenderTemplate bool
+
+	// Codec decodes the webhook config templates. If nil, the codec of
+	// this package is used, which only registers admissionregistration
+	// v1beta1. Use NewCodec to register further types, conversions or
+	// defaults.
+	Codec runtime.Codec
+
+	// If true, the contents of the template and CA bundle files are cached
+	// until the file watcher reports a change, and the desired config is
+	// only rebuilt when the content of either file changes.
+	CacheDesiredConfig bool
+
+	// If true, the last successfully read contents of each webhook config
+	// template are kept and used when the template can't be read, e.g.
+	// after the file is deleted. Otherwise the config is left unchanged
+	// until the template can be read again.
+	UseCachedTemplateOnError bool
+
+	// If true, the controller fails to start when the webhook config can't
+	// be built from the local template and CA bundle files. Otherwise the
+	// failure is only logged and reported during reconciliation.
+	FailOnInvalidConfigAtStartup bool
+
+	// Revision of the control plane managing the webhook config. When set,
+	// the config is labeled with the revision and as managed by this
+	// controller.
+	Revision string
+
+	// Value of the app.kubernetes.io/managed-by label identifying the
+	// configs managed by this controller. When set, existing configs
+	// labeled as managed by a different value are left untouched. When
+	// empty, the label is only set along with a Revision.
+	ManagedByLabelValue string
+
+	// Labels set on the webhook configs, e.g. to audit their ownership.
+	// Labels removed or changed out-of-band are restored. The managed-by
+	// and revision labels must match ManagedByLabelValue and Revision.
+	ManagedLabels map[string]string
+
+	// If true, webhook configs managed by this controller for a different
+	// Revision are deleted once the configs for the current Revision are
+	// installed, e.g. after a canary revision is promoted.
+	PruneStaleRevisionConfigs bool
+
+	// If true, every reconcile records a ReconcileTrace of its decisions.
+	// Otherwise only reconciles requested by TraceNextReconcile are traced.
+	TraceReconciles bool
+
+	// Maximum number of times a failed reconcile is retried before it is
+	// dropped. Retried indefinitely when zero. A dropped reconcile is
+	// retried on the next change or resync.
+	MaxReconcileRetries int
+
+	// If true, the changes the controller would make to the webhook configs
+	// are logged along with their diff instead of being written. The
+	// default metrics are labeled as dry-run.
+	DryRun bool
+
+	// If non-nil, the objectSelector of every webhook in the config,
+	// replacing the template's, e.g. to only validate labeled resources.
+	ObjectSelector *kubeApiMeta.LabelSelector
+
+	// If non-nil, the failurePolicy of every webhook in the config,
+	// regardless of the template, e.g. Ignore during an initial rollout.
+	FailurePolicyOverride *kubeApiAdmission.FailurePolicyType
+
+	// If non-nil, the timeoutSeconds of every webhook in the config,
+	// regardless of the template. Must be between 1 and 30.
+	WebhookTimeoutSeconds *int32
+
+	// Timeout of the kube-apiserver calls made by a reconcile. A reconcile
+	// which times out is retried with rate limiting. No timeout when zero.
+	ReconcileTimeout time.Duration
+
+	// If true, replicas of the controller elect a leader and only the
+	// leader reconciles and writes the webhook configs. The other replicas
+	// keep their informers synced so they can take over quickly.
+	EnableLeaderElection bool
+
+	// Namespace of the leader election lock. Defaults to WatchedNamespace.
+	LeaderElectionNamespace string
+
+	// Called with each validatingwebhookconfiguration just before it is
+	// created, updated or applied, e.g. to enforce a local policy. An error
+	// aborts the write until the desired config changes. The config must
+	// not be modified. Optional.
+	PreApply func(config *kubeApiAdmission.ValidatingWebhookConfiguration) error
+
+	// Like PreApply, for the mutatingwebhookconfiguration. Optional.
+	PreApplyMutating func(config *kubeApiAdmission.MutatingWebhookConfiguration) error
+
+	// Starts the span of each reconcile, e.g. trace.StartSpan of
+	// go.opencensus.io/trace, or a function starting spans with the
+	// embedder's root span as the parent. No spans are started when nil.
+	StartSpan SpanStarter
+
+	// Called with the description of the request and the error each time a
+	// reconcile fails, in addition to the metrics and logs. Optional.
+	OnReconcileError func(req string, err error)
+
+	// Called with the description of the request each time a reconcile
+	// succeeds. Optional.
+	OnReconcileSuccess func(req string)
+
+	// Reporter for the controller's metrics. Defaults to the opencensus
+	// metrics registered by this package when nil.
+	MetricsReporter MetricsReporter
+
+	// generation is bumped by UpdateOptions so the desired configs built
+	// by a reconcile still running with the previous options aren't cached
+	// for later reconciles.
+	generation uint64
+
+	// sideEffectsWarned is set while building a template whose risky
+	// sideEffects were already warned about with the same options, so the
+	// warning isn't repeated every reconcile.
+	sideEffectsWarned bool
 }
 
 // Validate the options that exposed to end users
 func (o Options) Validate() error {
 	var errs *multierror.Error
-	if o.WebhookConfigName == "" || !labels.IsDNS1123Label(o.WebhookConfigName) {
-		errs = multierror.Append(errs, fmt.Errorf("invalid webhook name: %q", o.WebhookConfigName)) // nolint: lll
+	if o.WebhookConfigSelector != nil {
+		if len(o.WebhookConfigNames) > 0 {
+			errs = multierror.Append(errs, errors.New("webhook config names and selector are mutually exclusive"))
+		}
+		if o.WebhookConfigSelector.Empty() {
+			errs = multierror.Append(errs, errors.New("webhook config selector matches every config"))
+		}
+		if o.WebhookConfigName != "" && !labels.IsDNS1123Label(o.WebhookConfigName) {
+			errs = multierror.Append(errs, fmt.Errorf("invalid webhook name: %q", o.WebhookConfigName))
+		}
+		if o.WebhookConfigPath == "" {
+			errs = multierror.Append(errs, errors.New("webhook config file not specified"))
+		}
+	} else if len(o.WebhookConfigNames) == 0 {
+		if o.WebhookConfigName == "" || !labels.IsDNS1123Label(o.WebhookConfigName) {
+			errs = multierror.Append(errs, fmt.Errorf("invalid webhook name: %q", o.WebhookConfigName)) // nolint: lll
+		}
+		if o.WebhookConfigPath == "" {
+			errs = multierror.Append(errs, errors.New("webhook config file not specified"))
+		}
+	}
+	seen := make(map[string]bool, len(o.WebhookConfigNames))
+	for _, name := range o.WebhookConfigNames {
+		if name == "" || !labels.IsDNS1123Label(name) {
+			errs = multierror.Append(errs, fmt.Errorf("invalid webhook name: %q", name))
+		}
+		if seen[name] {
+			errs = multierror.Append(errs, fmt.Errorf("duplicate webhook name: %q", name))
+		}
+		seen[name] = true
+		if o.WebhookConfigPaths[name] == "" {
+			errs = multierror.Append(errs, fmt.Errorf("webhook config file not specified for %q", name))
+		}
+	}
+	if o.ManageMutatingWebhook {
+		if !labels.IsDNS1123Label(o.MutatingWebhookConfigName) {
+			errs = multierror.Append(errs, fmt.Errorf("invalid mutating webhook name: %q", o.MutatingWebhookConfigName))
+		}
+		if o.MutatingWebhookConfigPath == "" {
+			errs = multierror.Append(errs, errors.New("mutating webhook config file not specified"))
+		}
 	}
 	if o.WatchedNamespace == "" || !labels.IsDNS1123Label(o.WatchedNamespace) {
 		errs = multierror.Append(errs, fmt.Errorf("invalid namespace: %q", o.WatchedNamespace)) // nolint: lll
 	}
+	if o.ServiceNamespace != "" && !labels.IsDNS1123Label(o.ServiceNamespace) {
+		errs = multierror.Append(errs, fmt.Errorf("invalid service namespace: %q", o.ServiceNamespace))
+	}
+	if o.GalleyNamespace != "" && !labels.IsDNS1123Label(o.GalleyNamespace) {
+		errs = multierror.Append(errs, fmt.Errorf("invalid galley namespace: %q", o.GalleyNamespace))
+	}
 	if o.GalleyDeploymentName != "" && !labels.IsDNS1123Label(o.GalleyDeploymentName) {
 		errs = multierror.Append(errs, fmt.Errorf("invalid deployment name: %q", o.GalleyDeploymentName))
 	}
 	if o.ServiceName == "" || !labels.IsDNS1123Label(o.ServiceName) {
 		errs = multierror.Append(errs, fmt.Errorf("invalid service name: %q", o.ServiceName))
 	}
-	if o.CAPath == "" {
+	if o.ServicePortName != "" {
+		for _, msg := range validation.IsValidPortName(o.ServicePortName) {
+			errs = multierror.Append(errs, fmt.Errorf("invalid service port name %q: %v", o.ServicePortName, msg))
+		}
+	}
+	if o.Revision != "" && !labels.IsDNS1123Label(o.Revision) {
+		errs = multierror.Append(errs, fmt.Errorf("invalid revision: %q", o.Revision))
+	}
+	if o.ManagedByLabelValue != "" {
+		for _, msg := range validation.IsValidLabelValue(o.ManagedByLabelValue) {
+			errs = multierror.Append(errs, fmt.Errorf("invalid managed-by label value %q: %v", o.ManagedByLabelValue, msg))
+		}
+	}
+	for _, key := range sortedKeys(o.ManagedLabels) {
+		value := o.ManagedLabels[key]
+		for _, msg := range validation.IsQualifiedName(key) {
+			errs = multierror.Append(errs, fmt.Errorf("invalid managed label key %q: %v", key, msg))
+		}
+		for _, msg := range validation.IsValidLabelValue(value) {
+			errs = multierror.Append(errs, fmt.Errorf("invalid managed label value %q: %v", value, msg))
+		}
+		if key == managedByLabel && value != o.managedBy() {
+			errs = multierror.Append(errs, fmt.Errorf("managed label %v=%v conflicts with the managed-by label value %q",
+				key, value, o.managedBy()))
+		}
+		if key == revisionLabel && value != o.Revision {
+			errs = multierror.Append(errs, fmt.Errorf("managed label %v=%v conflicts with the revision %q", key, value, o.Revision))
+		}
+	}
+	if o.PruneStaleRevisionConfigs && o.Revision == "" {
+		errs = multierror.Append(errs, errors.New("pruning stale revision configs requires a revision"))
+	}
+	if len(o.RequiredCRDs) > 0 && o.APIExtensionsClient == nil {
+		errs = multierror.Append(errs, errors.New("required CRDs specified without an apiextensions client"))
+	}
+	if o.CertValiditySkew < 0 {
+		errs = multierror.Append(errs, fmt.Errorf("invalid cert validity skew: %v", o.CertValiditySkew))
+	}
+	if o.StartupJitter < 0 {
+		errs = multierror.Append(errs, fmt.Errorf("invalid startup jitter: %v", o.StartupJitter))
+	}
+	if o.EndpointReadyTimeout < 0 {
+		errs = multierror.Append(errs, fmt.Errorf("invalid endpoint ready timeout: %v", o.EndpointReadyTimeout))
+	}
+	if o.StartupGracePeriod < 0 {
+		errs = multierror.Append(errs, fmt.Errorf("invalid startup grace period: %v", o.StartupGracePeriod))
+	}
+	if o.GalleyUnavailableGracePeriod < 0 {
+		errs = multierror.Append(errs, fmt.Errorf("invalid galley unavailable grace period: %v", o.GalleyUnavailableGracePeriod))
+	}
+	if o.MinUpdateInterval < 0 {
+		errs = multierror.Append(errs, fmt.Errorf("invalid minimum update interval: %v", o.MinUpdateInterval))
+	}
+	if o.Workers < 0 {
+		errs = multierror.Append(errs, fmt.Errorf("invalid number of workers: %v", o.Workers))
+	}
+	if o.ClusterRoleLookupRetries < 0 {
+		errs = multierror.Append(errs, fmt.Errorf("invalid clusterrole lookup retries: %v", o.ClusterRoleLookupRetries))
+	}
+	if o.MaxReconcileRetries < 0 {
+		errs = multierror.Append(errs, fmt.Errorf("invalid maximum reconcile retries: %v", o.MaxReconcileRetries))
+	}
+	if o.ReconcileTimeout < 0 {
+		errs = multierror.Append(errs, fmt.Errorf("invalid reconcile timeout: %v", o.ReconcileTimeout))
+	}
+	if o.MinReadyEndpoints < 0 {
+		errs = multierror.Append(errs, fmt.Errorf("invalid minimum ready endpoints: %v", o.MinReadyEndpoints))
+	}
+	if o.CAPath == "" && o.CASecretName == "" && o.CAConfigMapName == "" {
 		errs = multierror.Append(errs, errors.New("CA cert file not specified"))
 	}
-	if o.WebhookConfigPath == "" {
-		errs = multierror.Append(errs, errors.New("webhook config file not specified"))
+	if o.CheckFilesExist {
+		for _, path := range o.localFiles() {
+			if err := checkFileReadable(path); err != nil {
+				errs = multierror.Append(errs, err)
+			}
+		}
+	}
+	if o.FailurePolicyOverride != nil {
+		switch *o.FailurePolicyOverride {
+		case kubeApiAdmission.Ignore, kubeApiAdmission.Fail:
+		default:
+			errs = multierror.Append(errs, fmt.Errorf("invalid failure policy override: %q", *o.FailurePolicyOverride))
+		}
+	}
+	if timeout := o.WebhookTimeoutSeconds; timeout != nil && (*timeout < minTimeoutSeconds || *timeout > maxTimeoutSeconds) {
+		errs = multierror.Append(errs, fmt.Errorf("invalid webhook timeoutSeconds %v: must be between %v and %v",
+			*timeout, minTimeoutSeconds, maxTimeoutSeconds))
+	}
+	return errs.ErrorOrNil()
+}
+
+func (o Options) caBundleVerifier() func([]byte) error {
+	if o.CABundleVerifier != nil {
+		return o.CABundleVerifier
+	}
+	return verifyCABundle
+}
+
+// caFromFile returns true if the CA bundle is read from CAPath rather than
+// a Secret or ConfigMap.
+func (o Options) caFromFile() bool {
+	return o.CASecretName == "" && o.CAConfigMapName == ""
+}
+
+func (o Options) caConfigMapKey() string {
+	if o.CAConfigMapKey != "" {
+		return o.CAConfigMapKey
+	}
+	return "root-cert.pem"
+}
+
+// localFiles returns the paths of the local files read by the controller.
+func (o Options) localFiles() []string {
+	var paths []string
+	if o.CAPath != "" && o.caFromFile() {
+		paths = append(paths, o.CAPath)
+	}
+	paths = append(paths, o.AdditionalCAPaths...)
+	for _, name := range sortedKeys(o.PerWebhookCAPaths) {
+		paths = append(paths, o.PerWebhookCAPaths[name])
+	}
+	for _, path := range o.webhookConfigPaths() {
+		if path != "" {
+			paths = append(paths, path)
+		}
+	}
+	if o.ServingCertPath != "" {
+		paths = append(paths, o.ServingCertPath)
+	}
+	return paths
+}
+
+func checkFileReadable(path string) error {
+	info, err := os.Stat(path)
+	if err != nil {
+		return fmt.Errorf("file %q does not exist: %v", path, err)
+	}
+	if info.IsDir() {
+		return fmt.Errorf("file %q is a directory", path)
+	}
+	f, err := os.Open(path)
+	if err != nil {
+		return fmt.Errorf("file %q is not readable: %v", path, err)
+	}
+	return f.Close()
+}
+
+func (o Options) workers() int {
+	if o.Workers > 0 {
+		return o.Workers
+	}
+	return 1
+}
+
+func (o Options) clusterRoleLookupRetries() int {
+	if o.ClusterRoleLookupRetries > 0 {
+		return o.ClusterRoleLookupRetries
+	}
+	return 5
+}
+
+func (o Options) deferToGalley() bool {
+	return o.DeferToGalley == nil || *o.DeferToGalley
+}
+
+func (o Options) serviceNamespace() string {
+	if o.ServiceNamespace != "" {
+		return o.ServiceNamespace
+	}
+	return o.WatchedNamespace
+}
+
+// reconcileContext returns the context bounding the client calls of a reconcile.
+func (o Options) reconcileContext() (context.Context, context.CancelFunc) {
+	if o.ReconcileTimeout > 0 {
+		return context.WithTimeout(context.Background(), o.ReconcileTimeout)
+	}
+	return context.WithCancel(context.Background())
+}
+
+func (o Options) leaderElectionNamespace() string {
+	if o.LeaderElectionNamespace != "" {
+		return o.LeaderElectionNamespace
+	}
+	return o.WatchedNamespace
+}
+
+func (o Options) managedBy() string {
+	if o.ManagedByLabelValue != "" {
+		return o.ManagedByLabelValue
+	}
+	return managedByValue
+}
+
+// managedByOther returns the manager the config is labeled as managed by
+// if it isn't this controller. Only enforced when ManagedByLabelValue is
+// set, since other tools, e.g. Helm, set the label on the configs they
+// install.
+func (o Options) managedByOther(labels map[string]string) (string, bool) {
+	if o.ManagedByLabelValue == "" {
+		return "", false
+	}
+	manager := labels[managedByLabel]
+	return manager, manager != "" && manager != o.ManagedByLabelValue
+}
+
+func (o Options) metricsReporter() MetricsReporter {
+	if o.MetricsReporter != nil {
+		return o.MetricsReporter
+	}
+	return opencensusReporter{dryRun: o.DryRun}
+}
+
+func (o Options) galleyNamespace() string {
+	if o.GalleyNamespace != "" {
+		return o.GalleyNamespace
+	}
+	return o.WatchedNamespace
+}
+
+const defaultGalleyUnavailableGracePeriod = 5 * time.Minute
+
+func (o Options) galleyUnavailableGracePeriod() time.Duration {
+	if o.GalleyUnavailableGracePeriod == 0 {
+		return defaultGalleyUnavailableGracePeriod
+	}
+	return o.GalleyUnavailableGracePeriod
+}
+
+// webhookConfig identifies a managed validatingwebhookconfiguration and
+// the file path of its template.
+type webhookConfig struct {
+	name string
+	path string
+}
+
+// webhookConfigs returns the managed configs in the order they should be applied.
+func (o Options) webhookConfigs() []webhookConfig {
+	if len(o.WebhookConfigNames) == 0 {
+		return []webhookConfig{{name: o.WebhookConfigName, path: o.WebhookConfigPath}}
+	}
+	configs := make([]webhookConfig, 0, len(o.WebhookConfigNames))
+	for _, name := range o.WebhookConfigNames {
+		configs = append(configs, webhookConfig{name: name, path: o.WebhookConfigPaths[name]})
+	}
+	return configs
+}
+
+// managedConfigs returns the managed configs in the order they should be
+// applied. With a WebhookConfigSelector these are the matching configs in
+// the informer cache, ordered by name.
+func (c *Controller) managedConfigs(o Options) ([]webhookConfig, error) {
+	if o.WebhookConfigSelector == nil {
+		return o.webhookConfigs(), nil
+	}
+	matching, err := c.sharedInformers.Admissionregistration().V1beta1().
+		ValidatingWebhookConfigurations().Lister().List(o.WebhookConfigSelector)
+	if err != nil {
+		return nil, err
+	}
+	configs := make([]webhookConfig, 0, len(matching))
+	for _, config := range matching {
+		configs = append(configs, webhookConfig{name: config.Name, path: o.WebhookConfigPath})
+	}
+	sort.Slice(configs, func(i, j int) bool { return configs[i].name < configs[j].name })
+	return configs, nil
+}
+
+// webhookConfigPaths returns the unique template file paths of the managed
+// configs, including the mutating config if managed.
+func (o Options) webhookConfigPaths() []string {
+	var paths []string
+	seen := make(map[string]bool)
+	for _, config := range o.webhookConfigs() {
+		if !seen[config.path] {
+			seen[config.path] = true
+			paths = append(paths, config.path)
+		}
+	}
+	if o.ManageMutatingWebhook && !seen[o.MutatingWebhookConfigPath] {
+		paths = append(paths, o.MutatingWebhookConfigPath)
+	}
+	return paths
+}
+
+// UserAgent returns the user-agent identifying requests from the controller
+// in kube-apiserver audit logs and priority and fairness policies.
+func UserAgent(version string) string {
+	if version == "" {
+		version = "unknown"
 	}
-	return errs
+	return fmt.Sprintf("%v/%v", userAgentName, version)
+}
+
+// NewClient creates a client from the rest config whose requests carry the
+// controller's user-agent for the given version.
+func NewClient(config *rest.Config, version string) (kubernetes.Interface, error) {
+	config = rest.CopyConfig(config)
+	config.UserAgent = UserAgent(version)
+	return kubernetes.NewForConfig(config)
 }
 
 type readFileFunc func(filename string) ([]byte, error)
 
+// informerFactory is the subset of informers.SharedInformerFactory used by
+// the controller.
+type informerFactory interface {
+	Admissionregistration() admissionregistration.Interface
+	Apps() apps.Interface
+	Core() core.Interface
+	Rbac() rbac.Interface
+	Start(stopCh <-chan struct{})
+	WaitForCacheSync(stopCh <-chan struct{}) map[reflect.Type]bool
+}
+
+// newInformerFactoryFunc returns the informer factory scoped to the namespace.
+type newInformerFactoryFunc func(o Options, namespace string) informerFactory
+
+func newSharedInformerFactory(o Options, namespace string) informerFactory {
+	return informers.NewSharedInformerFactoryWithOptions(o.Client, o.ResyncPeriod, informers.WithNamespace(namespace))
+}
+
 type Controller struct {
-	o                 Options
-	ownerRefs         []kubeApiMeta.OwnerReference
-	queue             workqueue.RateLimitingInterface
-	sharedInformers   informers.SharedInformerFactory
+	o               Options
+	ownerRefs       []kubeApiMeta.OwnerReference
+	queue           *metricsQueue
+	sharedInformers informerFactory
+	// informer factories for namespaces other than WatchedNamespace.
+	namespacedInformers map[string]informerFactory
+	// informer factory for the RequiredCRDs. nil when there are none.
+	crdInformers apiextensionsinformers.SharedInformerFactory
+	// stateMu guards the reconcile state shared by the workers:
+	// endpointReadyOnce, endpointReadyTimedOut, startTime, gracePassed,
+	// galleyUnavailableSince, and ownerRefs.
+	stateMu           sync.Mutex
 	endpointReadyOnce bool
-	fw                filewatcher.FileWatcher
+	// whether the EndpointReadyTimeout elapsed before the endpoint was
+	// first ready.
+	endpointReadyTimedOut bool
+	// time of the first reconcile and whether the startup grace period has
+	// been satisfied.
+	startTime   time.Time
+	gracePassed bool
+	// time the galley deployment was first seen without available
+	// replicas. Zero while it is available or not deployed.
+	galleyUnavailableSince time.Time
+	fw                     filewatcher.FileWatcher
+	metrics                MetricsReporter
+	cache                  *desiredConfigCache
+	summary                *reconcileSummary
+
+	// records events on the webhook config. eventBroadcaster is nil when
+	// the recorder is injected by tests.
+	eventBroadcaster record.EventBroadcaster
+	recorder         record.EventRecorder
+
+	stopCh   chan struct{}
+	stopOnce sync.Once
+	workers  sync.WaitGroup
+
+	// optionsMu guards o, which may be swapped by UpdateOptions while a
+	// reconcile is in progress. It is read through options().
+	optionsMu sync.RWMutex
+
+	writeMu   sync.Mutex
+	lastWrite time.Time
+
+	// time each config was last successfully reconciled.
+	lastSuccessMu sync.Mutex
+	lastSuccess   map[string]time.Time
+
+	// last successfully read contents of each template, by path. Only
+	// kept with UseCachedTemplateOnError.
+	lastTemplateMu sync.Mutex
+	lastTemplate   map[string][]byte
+
+	configLocksMu sync.Mutex
+	configLocks   map[string]*sync.Mutex
+
+	diffMu   sync.Mutex
+	lastDiff string
+
+	// latest description of the requests queued by enqueueKeyed.
+	keyedMu           sync.Mutex
+	keyedDescriptions map[reconcileKey]string
+
+	// content hash of the template and options each config was last built
+	// from without error, to warn about risky sideEffects once per template.
+	sideEffectsMu      sync.Mutex
+	sideEffectsChecked map[string][sha256.Size]byte
+
+	traceMu     sync.Mutex
+	traceNext   bool
+	reconcileID uint64
+	lastTrace   *ReconcileTrace
+	// trace of the in-progress reconcile, if traced.
+	trace *ReconcileTrace
+
+	// identity of this replica for leader election.
+	leaderIdentity string
+	leaderTimings  leaderTimings
+	leader         leaderState
 
 	// unittest hooks
+	rand          *rand.Rand
 	readFile      readFileFunc
+	newInformers  newInformerFactoryFunc
 	reconcileDone func()
+	clock         clock.Clock
+	kube          kubeClient
+	applyConfig   applyFunc
 }
 
 type reconcileRequest struct {
 	description string
+	// of the object which triggered the request, if any.
+	namespace string
+	// receives the result of the first attempt to reconcile the request, if non-nil.
+	done chan error
 }
 
 func (rr reconcileRequest) String() string {
 	return rr.description
 }
 
-func filterWatchedObject(in interface{}, name string) (skip bool, key string) {
+// reconcileKey is queued in place of a reconcileRequest for requests from a
+// single source, e.g. a watched file. The queue coalesces equal keys so a
+// burst of events from one source results in a single reconcile.
+type reconcileKey string
+
+const resyncReconcileKey reconcileKey = "resync"
+
+const endpointReadyTimeoutReconcileKey reconcileKey = "endpoint-ready-timeout"
+
+func fileReconcileKey(path string) reconcileKey {
+	return reconcileKey("file:" + path)
+}
+
+// enqueueKeyed queues a reconcile under key. The description of the most
+// recent request for the key is used when it is reconciled.
+func (c *Controller) enqueueKeyed(key reconcileKey, description string) {
+	c.keyedMu.Lock()
+	c.keyedDescriptions[key] = description
+	c.keyedMu.Unlock()
+	c.queue.Add(key)
+}
+
+func (c *Controller) keyedRequest(key reconcileKey) *reconcileRequest {
+	c.keyedMu.Lock()
+	defer c.keyedMu.Unlock()
+	return &reconcileRequest{description: c.keyedDescriptions[key]}
+}
+
+// objectMatcher returns true for the watched objects which trigger a reconcile.
+type objectMatcher func(obj kubeApiMeta.Object) bool
+
+func matchNames(names ...string) objectMatcher {
+	return func(obj kubeApiMeta.Object) bool {
+		return containsName(names, obj.GetName())
+	}
+}
+
+func matchSelector(selector kubeLabels.Selector) objectMatcher {
+	return func(obj kubeApiMeta.Object) bool {
+		return selector.Matches(kubeLabels.Set(obj.GetLabels()))
+	}
+}
+
+// informerObject returns the object of an informer event, unwrapping the
+// tombstone of an object deleted while the watch was disconnected. An object
+// which can't be decoded is logged and reported instead of silently dropped.
+func informerObject(metrics MetricsReporter, gvk schema.GroupVersionKind, in interface{}) (kubeApiMeta.Object, bool) {
+	if tombstone, ok := in.(cache.DeletedFinalStateUnknown); ok {
+		in = tombstone.Obj
+	}
 	obj, err := meta.Accessor(in)
 	if err != nil {
-		return true, ""
+		scope.Errorf("Could not decode the %v informer event object of type %T: %v", gvk.Kind, in, err)
+		metrics.ReportInformerDecodeError(gvk)
+		return nil, false
 	}
-	if obj.GetName() != name {
+	return obj, true
+}
+
+func filterWatchedObject(obj kubeApiMeta.Object, match objectMatcher) (skip bool, key string) {
+	if !match(obj) {
 		return true, ""
 	}
-	key, err = cache.DeletionHandlingMetaNamespaceKeyFunc(in)
+	key, err := cache.MetaNamespaceKeyFunc(obj)
 	if err != nil {
 		return true, ""
 	}
 	return false, key
 }
 
-func makeHandler(queue workqueue.Interface, gvk schema.GroupVersionKind, name string) *cache.ResourceEventHandlerFuncs {
+func containsName(names []string, name string) bool {
+	for _, n := range names {
+		if n == name {
+			return true
+		}
+	}
+	return false
+}
+
+func makeHandler(
+	queue workqueue.Interface,
+	metrics MetricsReporter,
+	gvk schema.GroupVersionKind,
+	names ...string,
+) *cache.ResourceEventHandlerFuncs {
+	return makeMatchingHandler(queue, metrics, gvk, matchNames(names...))
+}
+
+func makeMatchingHandler(
+	queue workqueue.Interface,
+	metrics MetricsReporter,
+	gvk schema.GroupVersionKind,
+	match objectMatcher,
+) *cache.ResourceEventHandlerFuncs {
 	return &cache.ResourceEventHandlerFuncs{
-		AddFunc: func(obj interface{}) {
-			skip, key := filterWatchedObject(obj, name)
+		AddFunc: func(in interface{}) {
+			obj, ok := informerObject(metrics, gvk, in)
+			if !ok {
+				return
+			}
+			skip, key := filterWatchedObject(obj, match)
 			scope.Debugf("HandlerAdd: key=%v skip=%v", key, skip)
+			metrics.ReportInformerEvent(gvk, skip)
 			if skip {
 				return
 			}
-			req := &reconcileRequest{fmt.Sprintf("adding (%v, Kind=%v) %v", gvk.GroupVersion(), gvk.Kind, key)}
+			req := &reconcileRequest{
+				description: fmt.Sprintf("adding (%v, Kind=%v) %v", gvk.GroupVersion(), gvk.Kind, key),
+				namespace:   obj.GetNamespace(),
+			}
 			queue.Add(req)
 		},
 		UpdateFunc: func(prev, curr interface{}) {
-			skip, key := filterWatchedObject(curr, name)
+			obj, ok := informerObject(metrics, gvk, curr)
+			if !ok {
+				return
+			}
+			skip, key := filterWatchedObject(obj, match)
 			scope.Debugf("HandlerUpdate: key=%v skip=%v", key, skip)
+			metrics.ReportInformerEvent(gvk, skip)
 			if skip {
 				return
 			}
 			if !reflect.DeepEqual(prev, curr) {
-				req := &reconcileRequest{fmt.Sprintf("update (%v, Kind=%v) %v", gvk.GroupVersion(), gvk.Kind, key)}
+				req := &reconcileRequest{
+					description: fmt.Sprintf("update (%v, Kind=%v) %v", gvk.GroupVersion(), gvk.Kind, key),
+					namespace:   obj.GetNamespace(),
+				}
 				queue.Add(req)
 			}
 		},
-		DeleteFunc: func(obj interface{}) {
-			if _, ok := obj.(kubeApiMeta.Object); !ok {
-				// If the object doesn't have Metadata, assume it is a tombstone object
-				// of type DeletedFinalStateUnknown
-				tombstone, ok := obj.(cache.DeletedFinalStateUnknown)
-				if !ok {
-					return
-				}
-				obj = tombstone.Obj
+		DeleteFunc: func(in interface{}) {
+			obj, ok := informerObject(metrics, gvk, in)
+			if !ok {
+				return
 			}
-			skip, key := filterWatchedObject(obj, name)
+			skip, key := filterWatchedObject(obj, match)
 			scope.Debugf("HandlerDelete: key=%v skip=%v", key, skip)
+			metrics.ReportInformerEvent(gvk, skip)
 			if skip {
 				return
 			}
-			req := &reconcileRequest{fmt.Sprintf("delete (%v, Kind=%v) %v", gvk.GroupVersion(), gvk.Kind, key)}
+			req := &reconcileRequest{
+				description: fmt.Sprintf("delete (%v, Kind=%v) %v", gvk.GroupVersion(), gvk.Kind, key),
+				namespace:   obj.GetNamespace(),
+			}
 			queue.Add(req)
 		},
 	}
@@ -198,13 +1124,128 @@ func makeHandler(queue workqueue.Interface, gvk schema.GroupVersionKind, name st
 
 // precompute GVK for known types.
 var (
-	configGVK     = kubeApiAdmission.SchemeGroupVersion.WithKind(reflect.TypeOf(kubeApiAdmission.ValidatingWebhookConfiguration{}).Name())
-	endpointGVK   = kubeApiCore.SchemeGroupVersion.WithKind(reflect.TypeOf(kubeApiCore.Endpoints{}).Name())
-	deploymentGVK = kubeApiApp.SchemeGroupVersion.WithKind(reflect.TypeOf(kubeApiApp.Deployment{}).Name())
+	configGVK      = kubeApiAdmission.SchemeGroupVersion.WithKind(reflect.TypeOf(kubeApiAdmission.ValidatingWebhookConfiguration{}).Name())
+	endpointGVK    = kubeApiCore.SchemeGroupVersion.WithKind(reflect.TypeOf(kubeApiCore.Endpoints{}).Name())
+	clusterRoleGVK = kubeApiRbac.SchemeGroupVersion.WithKind(reflect.TypeOf(kubeApiRbac.ClusterRole{}).Name())
+	secretGVK      = kubeApiCore.SchemeGroupVersion.WithKind(reflect.TypeOf(kubeApiCore.Secret{}).Name())
+	configMapGVK   = kubeApiCore.SchemeGroupVersion.WithKind(reflect.TypeOf(kubeApiCore.ConfigMap{}).Name())
+	deploymentGVK  = kubeApiApp.SchemeGroupVersion.WithKind(reflect.TypeOf(kubeApiApp.Deployment{}).Name())
+	crdGVK         = kubeApiExtensions.SchemeGroupVersion.WithKind(reflect.TypeOf(kubeApiExtensions.CustomResourceDefinition{}).Name()) // nolint: lll
+)
+
+// configKind describes a kind of webhook config for the write path shared
+// by the validating and mutating configs. The guards and the merge work on
+// a view of the config as a validatingwebhookconfiguration.
+type configKind struct {
+	gvk schema.GroupVersionKind
+	gvr schema.GroupVersionResource
+
+	// newConfig returns an empty config of the kind.
+	newConfig func() runtime.Object
+	// cached returns the named config from the informer cache.
+	cached func(c *Controller, name string) (runtime.Object, error)
+	// view returns the config as a validatingwebhookconfiguration.
+	view func(config runtime.Object) *kubeApiAdmission.ValidatingWebhookConfiguration
+	// fromView returns a config of the kind with the metadata and webhooks
+	// of the view. The fields missing from the view are taken from the
+	// webhook of the same name in config.
+	fromView func(view *kubeApiAdmission.ValidatingWebhookConfiguration, config runtime.Object) runtime.Object
+	// preApply runs the pre-apply hook of the kind, if any.
+	preApply func(o Options, config runtime.Object) error
+	// updateOnly returns true if missing configs of the kind aren't created.
+	updateOnly func(o Options) bool
+
+	reportUpdate      func(c *Controller, configName string)
+	reportUpdateError func(m MetricsReporter, configName string, reason kubeApiMeta.StatusReason)
+	reportDeleteError func(m MetricsReporter, configName string, reason kubeApiMeta.StatusReason)
+}
+
+var validatingConfigKind = configKind{
+	gvk: configGVK,
+	gvr: validatingConfigResource,
+	newConfig: func() runtime.Object {
+		return &kubeApiAdmission.ValidatingWebhookConfiguration{}
+	},
+	cached: func(c *Controller, name string) (runtime.Object, error) {
+		return c.sharedInformers.Admissionregistration().V1beta1().
+			ValidatingWebhookConfigurations().Lister().Get(name)
+	},
+	view: func(config runtime.Object) *kubeApiAdmission.ValidatingWebhookConfiguration {
+		return config.(*kubeApiAdmission.ValidatingWebhookConfiguration)
+	},
+	fromView: func(view *kubeApiAdmission.ValidatingWebhookConfiguration, _ runtime.Object) runtime.Object {
+		return view
+	},
+	preApply: func(o Options, config runtime.Object) error {
+		if o.PreApply == nil {
+			return nil
+		}
+		return o.PreApply(config.(*kubeApiAdmission.ValidatingWebhookConfiguration))
+	},
+	updateOnly: func(o Options) bool {
+		return o.WebhookConfigSelector != nil
+	},
+	reportUpdate:      (*Controller).reportConfigUpdated,
+	reportUpdateError: MetricsReporter.ReportValidationConfigUpdateError,
+	reportDeleteError: MetricsReporter.ReportValidationConfigDeleteError,
+}
+
+// resource returns the lowercase kind used in logs, e.g. validatingwebhookconfiguration.
+func (k configKind) resource() string {
+	return strings.ToLower(k.gvk.Kind)
+}
+
+// merge returns a copy of current with the fields managed by the controller
+// set from desired. See mergeDesired.
+func (k configKind) merge(current, desired runtime.Object, preserveSelectors bool) runtime.Object {
+	return k.fromView(mergeDesired(k.view(current), k.view(desired), preserveSelectors), desired)
+}
+
+// objectName returns the name of the webhook config.
+func objectName(config runtime.Object) string {
+	return config.(kubeApiMeta.Object).GetName()
+}
+
+// clusterRoleOwnerRefs returns the owner reference to the ClusterRole.
+func clusterRoleOwnerRefs(clusterRole *kubeApiRbac.ClusterRole) []kubeApiMeta.OwnerReference {
+	return []kubeApiMeta.OwnerReference{
+		*kubeApiMeta.NewControllerRef(
+			clusterRole,
+			kubeApiRbac.SchemeGroupVersion.WithKind("ClusterRole"),
+		),
+	}
+}
+
+var (
+	validatingConfigResource = kubeApiAdmission.SchemeGroupVersion.WithResource("validatingwebhookconfigurations")
+	clusterRoleResource      = kubeApiRbac.SchemeGroupVersion.WithResource("clusterroles")
 )
 
-func findClusterRoleOwnerRefs(client kubernetes.Interface, clusterRoleName string) []kubeApiMeta.OwnerReference {
-	clusterRole, err := client.RbacV1().ClusterRoles().Get(clusterRoleName, kubeApiMeta.GetOptions{})
+// initial delay between retries of the clusterrole lookup, doubled after each retry.
+var clusterRoleLookupBackoff = 100 * time.Millisecond
+
+func findClusterRoleOwnerRefs(
+	ctx context.Context,
+	client kubeClient,
+	clusterRoleName string,
+	retries int,
+) []kubeApiMeta.OwnerReference {
+	clusterRole := &kubeApiRbac.ClusterRole{}
+	var err error
+	backoff := clusterRoleLookupBackoff
+	for attempt := 0; ; attempt++ {
+		err = client.get(ctx, clusterRoleResource, clusterRoleName, clusterRole)
+		if err == nil || kubeErrors.IsNotFound(err) || attempt >= retries || ctx.Err() != nil {
+			break
+		}
+		scope.Debugf("Could not get clusterrole %v (attempt %v of %v), retrying in %v: %v",
+			clusterRoleName, attempt+1, retries+1, backoff, err)
+		select {
+		case <-time.After(backoff):
+		case <-ctx.Done():
+		}
+		backoff *= 2
+	}
 	if err != nil {
 		scope.Warnf("Could not find clusterrole: %s to set ownerRef. "+
 			"The webhook configuration must be deleted manually.",
@@ -212,310 +1253,2441 @@ func findClusterRoleOwnerRefs(client kubernetes.Interface, clusterRoleName strin
 		return nil
 	}
 
-	return []kubeApiMeta.OwnerReference{
-		*kubeApiMeta.NewControllerRef(
-			clusterRole,
-			kubeApiRbac.SchemeGroupVersion.WithKind("ClusterRole"),
-		),
+	return clusterRoleOwnerRefs(clusterRole)
+}
+
+// refreshOwnerRefs recomputes the owner references from the ClusterRole,
+// e.g. after it was recreated with a new UID. The owner references are
+// cleared if the ClusterRole no longer exists.
+func (c *Controller) refreshOwnerRefs(o Options) {
+	if o.ClusterRoleName == "" {
+		return
+	}
+	c.stateMu.Lock()
+	defer c.stateMu.Unlock()
+	var ownerRefs []kubeApiMeta.OwnerReference
+	clusterRole, err := c.sharedInformers.Rbac().V1().ClusterRoles().Lister().Get(o.ClusterRoleName)
+	switch {
+	case err == nil:
+		ownerRefs = clusterRoleOwnerRefs(clusterRole)
+	case kubeErrors.IsNotFound(err):
+		if len(c.ownerRefs) > 0 {
+			scope.Warnf("Clusterrole %v not found. Clearing the ownerRef; "+
+				"the webhook configuration must be deleted manually.", o.ClusterRoleName)
+		}
+	default:
+		scope.Warnf("Could not get clusterrole %v to refresh the ownerRef: %v", o.ClusterRoleName, err)
+		return
 	}
+	if !reflect.DeepEqual(ownerRefs, c.ownerRefs) {
+		c.traceDecision("owner refs", "%v", ownerRefs)
+		scope.Infof("Updating the ownerRef of the webhook configuration to clusterrole %v: %v", o.ClusterRoleName, ownerRefs)
+		c.ownerRefs = ownerRefs
+	}
+}
+
+func (c *Controller) currentOwnerRefs() []kubeApiMeta.OwnerReference {
+	c.stateMu.Lock()
+	defer c.stateMu.Unlock()
+	return c.ownerRefs
+}
+
+// lockConfig serializes the writes to the named config between workers.
+func (c *Controller) lockConfig(name string) (unlock func()) {
+	c.configLocksMu.Lock()
+	mu, ok := c.configLocks[name]
+	if !ok {
+		mu = &sync.Mutex{}
+		c.configLocks[name] = mu
+	}
+	c.configLocksMu.Unlock()
+	mu.Lock()
+	return mu.Unlock
 }
 
 func New(o Options) (*Controller, error) {
-	return newController(o, filewatcher.NewWatcher, ioutil.ReadFile, nil)
+	return newController(o, filewatcher.NewWatcher, ioutil.ReadFile, newSharedInformerFactory, newRESTKubeClient, nil)
 }
 
 func newController(
 	o Options,
 	newFileWatcher filewatcher.NewFileWatcherFunc,
 	readFile readFileFunc,
+	newInformers newInformerFactoryFunc,
+	newKubeClient newKubeClientFunc,
 	reconcileDone func(),
-) (*Controller, error) {
+) (_ *Controller, err error) {
+	if o.LogLevel != nil {
+		scope.SetOutputLevel(*o.LogLevel)
+	}
 	caFileWatcher := newFileWatcher()
-	if err := caFileWatcher.Add(o.WebhookConfigPath); err != nil {
-		return nil, err
+	queue := newMetricsQueue(workqueue.DefaultItemBasedRateLimiter(), queueName)
+	var eventBroadcaster record.EventBroadcaster
+	// release the resources of a controller which isn't returned.
+	defer func() {
+		if err == nil {
+			return
+		}
+		queue.ShutDown()
+		if closeErr := caFileWatcher.Close(); closeErr != nil {
+			scope.Warnf("Error closing file watcher: %v", closeErr)
+		}
+		if eventBroadcaster != nil {
+			eventBroadcaster.Shutdown()
+		}
+	}()
+	watch := func(path, description string) error {
+		if err := caFileWatcher.Add(path); err != nil {
+			return &FileWatchError{Path: path, Description: description, Err: err}
+		}
+		return nil
 	}
-	if err := caFileWatcher.Add(o.CAPath); err != nil {
-		return nil, err
+	for _, path := range o.webhookConfigPaths() {
+		if err := watch(path, webhookConfigFileDescription); err != nil {
+			return nil, err
+		}
+	}
+	if o.caFromFile() {
+		if err := watch(o.CAPath, caFileDescription); err != nil {
+			return nil, err
+		}
+	}
+	for _, path := range o.AdditionalCAPaths {
+		if err := watch(path, caFileDescription); err != nil {
+			return nil, err
+		}
+	}
+	for _, name := range sortedKeys(o.PerWebhookCAPaths) {
+		if err := watch(o.PerWebhookCAPaths[name], caFileDescription); err != nil {
+			return nil, err
+		}
+	}
+	if o.ServingCertPath != "" {
+		if err := watch(o.ServingCertPath, servingCertFileDescription); err != nil {
+			return nil, err
+		}
 	}
 
+	ctx, cancel := o.reconcileContext()
+	defer cancel()
+
+	kube := newKubeClient(o.Client)
 	c := &Controller{
 		o:             o,
-		queue:         workqueue.NewRateLimitingQueue(workqueue.DefaultItemBasedRateLimiter()),
+		queue:         queue,
 		fw:            caFileWatcher,
 		readFile:      readFile,
+		newInformers:  newInformers,
 		reconcileDone: reconcileDone,
-		ownerRefs:     findClusterRoleOwnerRefs(o.Client, o.ClusterRoleName),
+		kube:          kube,
+		ownerRefs:     findClusterRoleOwnerRefs(ctx, kube, o.ClusterRoleName, o.clusterRoleLookupRetries()),
+		metrics:       o.metricsReporter(),
+		cache:         newDesiredConfigCache(),
+		summary:       newReconcileSummary(),
+		stopCh:        make(chan struct{}),
+		clock:         clock.RealClock{},
+		rand:          rand.New(rand.NewSource(time.Now().UnixNano())),
+	}
+	c.applyConfig = restApply(o.Client)
+	c.keyedDescriptions = make(map[reconcileKey]string)
+	c.sideEffectsChecked = make(map[string][sha256.Size]byte)
+	c.configLocks = make(map[string]*sync.Mutex)
+	c.lastSuccess = make(map[string]time.Time)
+	c.lastTemplate = make(map[string][]byte)
+	c.eventBroadcaster, c.recorder = newEventBroadcaster()
+	eventBroadcaster = c.eventBroadcaster
+	if o.EnableLeaderElection {
+		c.leaderIdentity = defaultLeaderIdentity()
+		c.leaderTimings = defaultLeaderTimings
 	}
 
-	c.sharedInformers = informers.NewSharedInformerFactoryWithOptions(o.Client, o.ResyncPeriod,
-		informers.WithNamespace(o.WatchedNamespace))
+	c.sharedInformers = newInformers(o, o.WatchedNamespace)
 
 	webhookInformer := c.sharedInformers.Admissionregistration().V1beta1().ValidatingWebhookConfigurations().Informer()
-	webhookInformer.AddEventHandler(makeHandler(c.queue, configGVK, o.WebhookConfigName))
+	if o.WebhookConfigSelector != nil {
+		handler := makeMatchingHandler(c.queue, c.metrics, configGVK, matchSelector(o.WebhookConfigSelector))
+		webhookInformer.AddEventHandler(handler)
+	} else {
+		var configNames []string
+		for _, config := range o.webhookConfigs() {
+			configNames = append(configNames, config.name)
+		}
+		webhookInformer.AddEventHandler(makeHandler(c.queue, c.metrics, configGVK, configNames...))
+	}
 
-	endpointInformer := c.sharedInformers.Core().V1().Endpoints().Informer()
-	endpointInformer.AddEventHandler(makeHandler(c.queue, endpointGVK, o.ServiceName))
+	if o.ClusterRoleName != "" {
+		clusterRoleInformer := c.sharedInformers.Rbac().V1().ClusterRoles().Informer()
+		clusterRoleInformer.AddEventHandler(makeHandler(c.queue, c.metrics, clusterRoleGVK, o.ClusterRoleName))
+	}
 
-	deploymentInformer := c.sharedInformers.Apps().V1().Deployments().Informer()
-	deploymentInformer.AddEventHandler(makeHandler(c.queue, deploymentGVK, o.GalleyDeploymentName))
+	if o.CASecretName != "" {
+		secretInformer := c.sharedInformers.Core().V1().Secrets().Informer()
+		secretInformer.AddEventHandler(makeHandler(c.queue, c.metrics, secretGVK, o.CASecretName))
+	} else if o.CAConfigMapName != "" {
+		configMapInformer := c.sharedInformers.Core().V1().ConfigMaps().Informer()
+		configMapInformer.AddEventHandler(makeHandler(c.queue, c.metrics, configMapGVK, o.CAConfigMapName))
+	}
 
-	return c, nil
-}
+	if o.ManageMutatingWebhook {
+		mutatingInformer := c.sharedInformers.Admissionregistration().V1beta1().MutatingWebhookConfigurations().Informer()
+		mutatingInformer.AddEventHandler(makeHandler(c.queue, c.metrics, mutatingConfigGVK, o.MutatingWebhookConfigName))
+	}
 
-func (c *Controller) Start(stop <-chan struct{}) {
-	go c.startFileWatcher(stop)
-	go c.sharedInformers.Start(stop)
+	endpointInformer := c.informersFor(o, o.serviceNamespace()).Core().V1().Endpoints().Informer()
+	endpointInformer.AddEventHandler(makeHandler(c.queue, c.metrics, endpointGVK, o.ServiceName))
 
-	for _, ready := range c.sharedInformers.WaitForCacheSync(stop) {
-		if !ready {
-			return
-		}
-	}
+	serviceInformer := c.informersFor(o, o.serviceNamespace()).Core().V1().Services().Informer()
+	serviceInformer.AddEventHandler(cache.ResourceEventHandlerFuncs{UpdateFunc: c.onServiceUpdate})
 
-	req := &reconcileRequest{"initial request to kickstart reconciliation"}
-	c.queue.Add(req)
+	deploymentInformer := c.informersFor(o, o.galleyNamespace()).Apps().V1().Deployments().Informer()
+	deploymentInformer.AddEventHandler(makeHandler(c.queue, c.metrics, deploymentGVK, o.GalleyDeploymentName))
+
+	if len(o.RequiredCRDs) > 0 {
+		c.crdInformers = apiextensionsinformers.NewSharedInformerFactory(o.APIExtensionsClient, o.ResyncPeriod)
+		crdInformer := c.crdInformers.Apiextensions().V1beta1().CustomResourceDefinitions().Informer()
+		crdInformer.AddEventHandler(makeHandler(c.queue, c.metrics, crdGVK, o.RequiredCRDs...))
+	}
+
+	if o.FailOnInvalidConfigAtStartup && !o.UnregisterValidationWebhook {
+		for _, config := range o.webhookConfigs() {
+			if _, err := c.buildValidatingWebhookConfiguration(o, config); err != nil {
+				return nil, fmt.Errorf("invalid validatingwebhookconfiguration %v: %v", config.name, err)
+			}
+		}
+		if o.ManageMutatingWebhook {
+			if _, err := c.buildMutatingWebhookConfiguration(o); err != nil {
+				return nil, fmt.Errorf("invalid mutatingwebhookconfiguration %v: %v", o.MutatingWebhookConfigName, err)
+			}
+		}
+	}
+
+	return c, nil
+}
+
+// informersFor returns the informer factory scoped to the namespace.
+func (c *Controller) informersFor(o Options, namespace string) informerFactory {
+	if namespace == o.WatchedNamespace {
+		return c.sharedInformers
+	}
+	if factory, ok := c.namespacedInformers[namespace]; ok {
+		return factory
+	}
+	factory := c.newInformers(o, namespace)
+	if c.namespacedInformers == nil {
+		c.namespacedInformers = make(map[string]informerFactory)
+	}
+	c.namespacedInformers[namespace] = factory
+	return factory
+}
+
+func (c *Controller) allInformers() []informerFactory {
+	all := []informerFactory{c.sharedInformers}
+	for _, factory := range c.namespacedInformers {
+		all = append(all, factory)
+	}
+	return all
+}
+
+// Start runs the controller until the stop channel is closed or Stop is
+// called. The error of StartWithError is logged.
+func (c *Controller) Start(externalStop <-chan struct{}) {
+	if err := c.StartWithError(externalStop); err != nil {
+		scope.Errorf("Could not start the validation controller: %v", err)
+	}
+}
+
+// syncInformers starts the informers until stop is closed and waits for
+// their caches to sync.
+func (c *Controller) syncInformers(stop <-chan struct{}) error {
+	// the factories start their informers in the background. Starting them
+	// synchronously ensures WaitForCacheSync waits for every informer.
+	for _, factory := range c.allInformers() {
+		factory.Start(stop)
+	}
+	if c.crdInformers != nil {
+		c.crdInformers.Start(stop)
+	}
+
+	var notSynced []string
+	for _, factory := range c.allInformers() {
+		for informerType, ready := range factory.WaitForCacheSync(stop) {
+			if !ready {
+				notSynced = append(notSynced, informerType.String())
+			}
+		}
+	}
+	if c.crdInformers != nil {
+		for informerType, ready := range c.crdInformers.WaitForCacheSync(stop) {
+			if !ready {
+				notSynced = append(notSynced, informerType.String())
+			}
+		}
+	}
+	if len(notSynced) > 0 {
+		sort.Strings(notSynced)
+		return fmt.Errorf("informer caches not synced: %v", strings.Join(notSynced, ", "))
+	}
+	return nil
+}
+
+// ReconcileOnce syncs the informer caches, reconciles the webhook configs
+// once and returns the result, e.g. for a Job or an init container. None of
+// the long-running goroutines are started and the controller is stopped
+// before it returns, so it can't be started afterwards. Leader election is
+// not used. Events aren't recorded since nothing would send them to the
+// kube-apiserver before it returns; the outcome of each write is logged. A
+// reconcile which completes without installing or unregistering the webhook
+// configs, e.g. since the endpoint is not ready or a config was refused or
+// rejected as invalid, is an error.
+func (c *Controller) ReconcileOnce(ctx context.Context) error {
+	c.discardEvents()
+	defer c.Stop()
+
+	stop := make(chan struct{})
+	done := make(chan struct{})
+	defer close(done)
+	go func() {
+		select {
+		case <-ctx.Done():
+		case <-done:
+		}
+		close(stop)
+	}()
+	if err := c.syncInformers(stop); err != nil {
+		return err
+	}
+	if err := c.reconcileRequest(ctx, c.options(), &reconcileRequest{description: "reconcile once"}); err != nil {
+		return err
+	}
+	if state := c.summary.state(); state != "installed" && state != "unregistered" {
+		return fmt.Errorf("webhook configs not reconciled: %v", state)
+	}
+	return nil
+}
+
+// StartWithError is like Start but returns an error if the informer caches
+// could not be synced, in which case the controller is stopped and the
+// workers are not started.
+func (c *Controller) StartWithError(externalStop <-chan struct{}) error {
+	// stop when either the caller's stop channel is closed or Stop is called.
+	stop := make(chan struct{})
+	go func() {
+		select {
+		case <-externalStop:
+		case <-c.stopCh:
+		}
+		close(stop)
+		c.Stop()
+	}()
+	o := c.options()
+	c.startFileWatcher(o, stop)
+	c.startRecordingEvents(o)
+	if err := c.syncInformers(stop); err != nil {
+		c.Stop()
+		return err
+	}
+
+	if o.ResyncPeriod > 0 {
+		go c.runResync(o.ResyncPeriod, stop)
+	}
+	go c.runLastSuccessReporter(stop)
+	go c.queue.run(stop)
+
+	if o.EnableLeaderElection {
+		c.workers.Add(1)
+		go func() {
+			defer c.workers.Done()
+			c.runLeaderElection(o, stop)
+		}()
+	}
+	c.startWorkers(o)
+	return nil
+}
+
+// runResync enqueues a reconcile every period until stop is closed. The
+// informer resyncs don't trigger a reconcile since the watched objects are
+// unchanged.
+func (c *Controller) runResync(period time.Duration, stop <-chan struct{}) {
+	for {
+		select {
+		case <-stop:
+			return
+		case <-c.clock.After(period):
+			c.enqueueKeyed(resyncReconcileKey, "periodic resync")
+		}
+	}
+}
+
+// interval of reporting the time since each config was last successfully reconciled.
+const lastSuccessReportInterval = 10 * time.Second
+
+// runLastSuccessReporter reports the time since each config was last
+// successfully reconciled until stop is closed. It runs apart from the
+// workers so the metric keeps increasing if reconciles stop completing.
+func (c *Controller) runLastSuccessReporter(stop <-chan struct{}) {
+	for {
+		select {
+		case <-stop:
+			return
+		case <-c.clock.After(lastSuccessReportInterval):
+			c.reportSecondsSinceLastSuccess()
+		}
+	}
+}
+
+func (c *Controller) reportSecondsSinceLastSuccess() {
+	c.lastSuccessMu.Lock()
+	defer c.lastSuccessMu.Unlock()
+	for _, name := range sortedKeys(c.lastSuccess) {
+		c.metrics.ReportSecondsSinceLastSuccess(name, c.clock.Since(c.lastSuccess[name]))
+	}
+}
+
+// reportConfigUpdated reports the successful reconcile of the named
+// validatingwebhookconfiguration.
+func (c *Controller) reportConfigUpdated(name string) {
+	c.lastSuccessMu.Lock()
+	c.lastSuccess[name] = c.clock.Now()
+	c.lastSuccessMu.Unlock()
+	c.metrics.ReportValidationConfigUpdate(name)
+	c.metrics.ReportSecondsSinceLastSuccess(name, 0)
+}
 
-	go c.runWorker()
+// kickstart enqueues the initial reconcile, delayed by a random jitter of
+// up to StartupJitter so controllers started together don't all write at once.
+func (c *Controller) kickstart(o Options) {
+	req := &reconcileRequest{description: "initial request to kickstart reconciliation"}
+	if o.StartupJitter <= 0 {
+		c.queue.Add(req)
+		return
+	}
+	delay := time.Duration(c.rand.Int63n(int64(o.StartupJitter)))
+	scope.Infof("Delaying the initial reconcile by %v", delay)
+	c.queue.AddAfter(req, delay)
+}
+
+// startWorkers kicks off reconciliation and runs the workers until the queue is shut down.
+func (c *Controller) startWorkers(o Options) {
+	c.kickstart(o)
+
+	for i := 0; i < o.workers(); i++ {
+		c.workers.Add(1)
+		go func() {
+			defer c.workers.Done()
+			c.runWorker()
+		}()
+	}
+}
+
+// Stop shuts down the controller. The workqueue is drained of the
+// in-progress reconcile and Stop waits for the worker to exit before closing
+// the file watcher. It is safe to call more than once.
+func (c *Controller) Stop() {
+	c.stopOnce.Do(func() {
+		close(c.stopCh)
+		c.queue.ShutDown()
+		c.workers.Wait()
+		if err := c.fw.Close(); err != nil {
+			scope.Warnf("Error closing file watcher: %v", err)
+		}
+		if c.eventBroadcaster != nil {
+			c.eventBroadcaster.Shutdown()
+		}
+		c.logSummary()
+	})
+}
+
+func (c *Controller) startFileWatcher(o Options, stop <-chan struct{}) {
+	for _, path := range o.webhookConfigPaths() {
+		go c.watchFile(path, webhookConfigFileDescription, stop)
+	}
+	if o.ServingCertPath != "" {
+		go c.watchFile(o.ServingCertPath, servingCertFileDescription, stop)
+	}
+	if o.caFromFile() {
+		go c.watchFile(o.CAPath, caFileDescription, stop)
+	}
+	for _, path := range o.AdditionalCAPaths {
+		go c.watchFile(path, caFileDescription, stop)
+	}
+	for _, name := range sortedKeys(o.PerWebhookCAPaths) {
+		go c.watchFile(o.PerWebhookCAPaths[name], fmt.Sprintf("%v of webhook %v", caFileDescription, name), stop)
+	}
 }
 
-func (c *Controller) startFileWatcher(stop <-chan struct{}) {
+func (c *Controller) watchFile(path, description string, stop <-chan struct{}) {
 	for {
 		select {
-		case ev := <-c.fw.Events(c.o.WebhookConfigPath):
-			req := &reconcileRequest{fmt.Sprintf(
-				"validatingwebhookconfiguration file changed: %v", ev)}
-			c.queue.Add(req)
-		case ev := <-c.fw.Events(c.o.CAPath):
-			req := &reconcileRequest{fmt.Sprintf("CA file changed: %v", ev)}
-			c.queue.Add(req)
-		case err := <-c.fw.Errors(c.o.WebhookConfigPath):
-			scope.Warnf("error watching local validatingwebhookconfiguration file: %v", err)
-		case err := <-c.fw.Errors(c.o.CAPath):
-			scope.Warnf("error watching local CA bundle: %v", err)
+		case ev, ok := <-c.fw.Events(path):
+			if !ok {
+				return
+			}
+			c.onFileChanged(path, description, ev)
+		case err, ok := <-c.fw.Errors(path):
+			if !ok {
+				return
+			}
+			scope.Warnf("error watching local %v: %v", description, err)
 		case <-stop:
 			return
 		}
 	}
-}
+}
+
+func (c *Controller) onFileChanged(path, description string, ev fsnotify.Event) {
+	o := c.options()
+	if o.ServingCertPath != "" && (path == o.ServingCertPath || path == o.CAPath) {
+		c.checkServingCert(o)
+	}
+	if path == o.ServingCertPath {
+		// the serving cert isn't part of the webhook config.
+		return
+	}
+	c.cache.invalidateFile(path)
+	c.enqueueKeyed(fileReconcileKey(path), fmt.Sprintf("%v changed: %v", description, ev))
+}
+
+// UpdateOptions validates and swaps the controller options at runtime and
+// enqueues a reconcile to apply them. Options which determine the watched
+// files, informers, and owner references are fixed when the controller is
+// created and cannot be changed.
+func (c *Controller) UpdateOptions(o Options) error {
+	if err := o.Validate(); err != nil {
+		return err
+	}
+
+	c.optionsMu.Lock()
+	if err := checkImmutableOptions(c.o, o); err != nil {
+		c.optionsMu.Unlock()
+		return err
+	}
+	o.generation = c.o.generation + 1
+	c.o = o
+	// cached configs may have been built with the previous options.
+	c.cache.reset()
+	c.optionsMu.Unlock()
+
+	req := &reconcileRequest{description: "controller options updated"}
+	c.queue.Add(req)
+	return nil
+}
+
+// options returns a snapshot of the controller options. Each reconcile and
+// event handler takes one snapshot and passes it down, so the options can't
+// change halfway through and c.o isn't read concurrently with UpdateOptions.
+func (c *Controller) options() Options {
+	c.optionsMu.RLock()
+	defer c.optionsMu.RUnlock()
+	return c.o
+}
+
+// checkImmutableOptions returns an error if any option which cannot be
+// changed after the controller is created differs between old and updated.
+func checkImmutableOptions(old, updated Options) error {
+	var errs *multierror.Error
+	immutable := []struct {
+		name    string
+		changed bool
+	}{
+		{"Client", !sameInstance(old.Client, updated.Client)},
+		{"WatchedNamespace", old.WatchedNamespace != updated.WatchedNamespace},
+		{"ResyncPeriod", old.ResyncPeriod != updated.ResyncPeriod},
+		{"CAPath", old.CAPath != updated.CAPath},
+		{"CASecretName", old.CASecretName != updated.CASecretName},
+		{"CAConfigMapName", old.CAConfigMapName != updated.CAConfigMapName},
+		{"CAConfigMapKey", old.caConfigMapKey() != updated.caConfigMapKey()},
+		{"PerWebhookCAPaths", !reflect.DeepEqual(old.PerWebhookCAPaths, updated.PerWebhookCAPaths)},
+		{"AdditionalCAPaths", !reflect.DeepEqual(old.AdditionalCAPaths, updated.AdditionalCAPaths)},
+		{"DryRun", old.DryRun != updated.DryRun},
+		{"Workers", old.workers() != updated.workers()},
+		{"ServingCertPath", old.ServingCertPath != updated.ServingCertPath},
+		{"WebhookConfigName", old.WebhookConfigName != updated.WebhookConfigName},
+		{"WebhookConfigPath", old.WebhookConfigPath != updated.WebhookConfigPath},
+		{"WebhookConfigNames", !reflect.DeepEqual(old.WebhookConfigNames, updated.WebhookConfigNames)},
+		{"WebhookConfigPaths", !reflect.DeepEqual(old.WebhookConfigPaths, updated.WebhookConfigPaths)},
+		{"WebhookConfigSelector", !reflect.DeepEqual(old.WebhookConfigSelector, updated.WebhookConfigSelector)},
+		{"ServiceName", old.ServiceName != updated.ServiceName},
+		{"ServiceNamespace", old.serviceNamespace() != updated.serviceNamespace()},
+		{"GalleyNamespace", old.galleyNamespace() != updated.galleyNamespace()},
+		{"GalleyDeploymentName", old.GalleyDeploymentName != updated.GalleyDeploymentName},
+		{"ClusterRoleName", old.ClusterRoleName != updated.ClusterRoleName},
+		{"MetricsReporter", !sameInstance(old.MetricsReporter, updated.MetricsReporter)},
+		{"APIExtensionsClient", !sameInstance(old.APIExtensionsClient, updated.APIExtensionsClient)},
+		{"RequiredCRDs", !reflect.DeepEqual(old.RequiredCRDs, updated.RequiredCRDs)},
+		{"ManageMutatingWebhook", old.ManageMutatingWebhook != updated.ManageMutatingWebhook},
+		{"MutatingWebhookConfigName", old.MutatingWebhookConfigName != updated.MutatingWebhookConfigName},
+		{"MutatingWebhookConfigPath", old.MutatingWebhookConfigPath != updated.MutatingWebhookConfigPath},
+		{"EnableLeaderElection", old.EnableLeaderElection != updated.EnableLeaderElection},
+		{"LeaderElectionNamespace", old.LeaderElectionNamespace != updated.LeaderElectionNamespace},
+	}
+	for _, option := range immutable {
+		if option.changed {
+			errs = multierror.Append(errs, fmt.Errorf("option %v cannot be changed at runtime", option.name))
+		}
+	}
+	return errs.ErrorOrNil()
+}
+
+// sameInstance reports whether a and b hold the same implementation of an
+// interface option. Comparing the interfaces with == panics when the
+// dynamic type is not comparable, so reference types are compared by
+// pointer and everything else by value.
+func sameInstance(a, b interface{}) bool {
+	if a == nil || b == nil {
+		return a == nil && b == nil
+	}
+	va, vb := reflect.ValueOf(a), reflect.ValueOf(b)
+	if va.Type() != vb.Type() {
+		return false
+	}
+	switch va.Kind() {
+	case reflect.Ptr, reflect.Map, reflect.Slice, reflect.Func, reflect.Chan, reflect.UnsafePointer:
+		return va.Pointer() == vb.Pointer()
+	}
+	return reflect.DeepEqual(a, b)
+}
+
+func (c *Controller) runWorker() {
+	for c.processNextWorkItem() {
+	}
+}
+
+func (c *Controller) processNextWorkItem() (cont bool) {
+	obj, shutdown := c.queue.Get()
+	if shutdown {
+		return false
+	}
+	defer c.queue.Done(obj)
+
+	o := c.options()
+	var req *reconcileRequest
+	switch item := obj.(type) {
+	case *reconcileRequest:
+		req = item
+	case reconcileKey:
+		req = c.keyedRequest(item)
+	default:
+		// don't retry an invalid reconcileRequest item
+		c.queue.Forget(obj)
+		return true
+	}
+
+	if !c.isLeader(o) {
+		// the next leader reconciles from scratch.
+		scope.Debugf("Dropping %v while not leading", req)
+		c.queue.Forget(obj)
+		if req.done != nil {
+			select {
+			case req.done <- errNotLeader:
+			default:
+			}
+		}
+		return true
+	}
+
+	ctx, cancel := o.reconcileContext()
+	err := c.reconcileRequest(ctx, o, req)
+	cancel()
+	if err != nil && o.OnReconcileError != nil {
+		o.OnReconcileError(req.String(), err)
+	} else if err == nil && o.OnReconcileSuccess != nil {
+		o.OnReconcileSuccess(req.String())
+	}
+	if req.done != nil {
+		// only the first attempt is reported. Retries are not waited on.
+		select {
+		case req.done <- err:
+		default:
+		}
+	}
+	if err != nil {
+		if o.MaxReconcileRetries > 0 && c.queue.NumRequeues(obj) >= o.MaxReconcileRetries {
+			scope.Errorf("Dropping %v after %v retries: %v", req, o.MaxReconcileRetries, err)
+			c.metrics.ReportValidationConfigRetriesExhausted()
+			c.queue.Forget(obj)
+			return true
+		}
+		c.queue.AddRateLimited(obj)
+		utilruntime.HandleError(err)
+	} else {
+		c.queue.Forget(obj)
+	}
+	return true
+}
+
+// Reconcile enqueues a reconcile for the given reason and blocks until it
+// completes, returning its result. A failed reconcile is still retried by the
+// controller. It is safe to call concurrently with the controller's worker,
+// which must have been started with Start.
+func (c *Controller) Reconcile(reason string) error {
+	if c.queue.ShuttingDown() {
+		return errors.New("controller is shutting down")
+	}
+	req := &reconcileRequest{description: reason, done: make(chan error, 1)}
+	c.queue.Add(req)
+	return <-req.done
+}
+
+// reconcile the desired state with the kube-apiserver. Client calls are
+// abandoned once ctx is done.
+func (c *Controller) reconcileRequest(ctx context.Context, o Options, req *reconcileRequest) (err error) {
+	defer func() {
+		if c.reconcileDone != nil {
+			c.reconcileDone()
+		}
+	}()
+
+	var failure string
+	defer func() { c.summary.record(c.clock.Now(), failure, err) }()
+
+	ctx, span := c.startReconcileSpan(ctx, o, req)
+	defer func() { span.end(c.summary.state(), failure, err) }()
+
+	trace := c.beginTrace(o, req)
+	defer func() { c.endTrace(trace, err) }()
+
+	scope.Info("Reconcile(enter)", req.logFields()...)
+	defer func() {
+		outcome := c.summary.state()
+		if err != nil {
+			outcome = "error"
+		}
+		fields := append(req.logFields(), zap.String(logFieldOutcome, outcome))
+		if failure != "" {
+			fields = append(fields, zap.String(logFieldReason, failure))
+		}
+		scope.Info("Reconcile(exit)", fields...)
+	}()
+
+	c.stateMu.Lock()
+	if c.startTime.IsZero() {
+		c.startTime = c.clock.Now()
+	}
+	endpointReadyOnce, gracePassed := c.endpointReadyOnce, c.gracePassed
+	c.stateMu.Unlock()
+
+	// don't write the webhook configs while the endpoint isn't ready, so the
+	// webhooks failing closed don't reject every request. Once it was first
+	// ready, the webhooks failing closed are set to fail open instead with
+	// FailOpenWhenUnready.
+	ready, reason, err := c.isEndpointReady(o)
+	if err != nil {
+		scope.Errorf("Error checking endpoint readiness: %v", err)
+		return err
+	}
+	c.traceDecision("endpoint ready", "%v", ready)
+	failOpen := false
+	switch {
+	case ready:
+		if !endpointReadyOnce {
+			c.stateMu.Lock()
+			c.endpointReadyOnce = true
+			c.stateMu.Unlock()
+		}
+	case endpointReadyOnce && o.FailOpenWhenUnready:
+		scope.Warnf("Endpoint %v/%v no longer ready: %v. Webhooks failing closed are set to fail open until it is ready.",
+			o.serviceNamespace(), o.ServiceName, reason)
+		failOpen = true
+	default:
+		scope.Infof("Endpoint %v/%v not ready: %v", o.serviceNamespace(), o.ServiceName, reason)
+		c.metrics.ReportValidationConfigSkippedEndpointNotReady(reason)
+		c.summary.setState("endpoint not ready")
+		if !endpointReadyOnce && o.EndpointReadyTimeout > 0 {
+			configs, err := c.managedConfigs(o)
+			if err != nil {
+				return err
+			}
+			c.checkEndpointReadyTimeout(o, configs, reason)
+		}
+		return nil
+	}
+
+	// don't update the webhook config if its already managed by an existing galley deployment.
+	if o.GalleyDeploymentName != "" && o.deferToGalley() {
+		running, err := c.isGalleyDeploymentRunning(o)
+		if err != nil {
+			scope.Errorf("Error checking galley deployment: %v", err)
+			return err
+		}
+		c.traceDecision("galley running", "%v", running)
+		if running {
+			scope.Info("Galley deployment detected")
+			c.metrics.ReportValidationConfigSkippedGalleyRunning()
+			c.summary.setSettled(c.clock.Now(), "deferred to galley deployment")
+			return nil
+		}
+	}
+
+	c.refreshOwnerRefs(o)
+
+	configs, err := c.managedConfigs(o)
+	if err != nil {
+		return err
+	}
+
+	// leave the webhook configs alone while reconciliation is paused.
+	if paused := c.pausedConfig(configs); paused != "" {
+		c.traceDecision("paused", "%v", paused)
+		scope.Infof("Reconciliation paused by annotation %v=true on validatingwebhookconfiguration %v",
+			pauseAnnotation, paused)
+		c.metrics.ReportValidationConfigSkippedPaused(paused)
+		c.summary.setSettled(c.clock.Now(), "paused")
+		return nil
+	}
+
+	// actively remove the webhook configuration if the controller is running but the webhook
+	c.traceDecision("unregister", "%v", o.UnregisterValidationWebhook)
+	if o.UnregisterValidationWebhook {
+		if o.ManageMutatingWebhook {
+			if err := c.deleteWebhookConfiguration(ctx, o, mutatingConfigKind, o.MutatingWebhookConfigName); err != nil {
+				c.traceDecision("delete", "%v: %v", o.MutatingWebhookConfigName, err)
+				return err
+			}
+			c.traceDecision("delete", "%v: deleted", o.MutatingWebhookConfigName)
+		}
+		// tear down in the reverse order the configs were applied.
+		for i := len(configs) - 1; i >= 0; i-- {
+			if err := c.deleteWebhookConfiguration(ctx, o, validatingConfigKind, configs[i].name); err != nil {
+				c.traceDecision("delete", "%v: %v", configs[i].name, err)
+				return err
+			}
+			c.traceDecision("delete", "%v: deleted", configs[i].name)
+		}
+		c.summary.setSettled(c.clock.Now(), "unregistered")
+		return nil
+	}
+
+	// don't install the webhook config before the CRDs it validates can be created.
+	if len(o.RequiredCRDs) > 0 {
+		established, err := c.areRequiredCRDsEstablished(o)
+		if err != nil {
+			scope.Errorf("Error checking required CRDs: %v", err)
+			return err
+		}
+		c.traceDecision("required CRDs established", "%v", established)
+		if !established {
+			c.summary.setState("required CRDs not established")
+			return nil
+		}
+	}
+
+	// give the webhook server time to stabilize before the first write.
+	if o.StartupGracePeriod > 0 && !gracePassed {
+		passed, err := c.startupGracePassed(o)
+		if err != nil {
+			return err
+		}
+		c.traceDecision("startup grace period passed", "%v", passed)
+		if !passed {
+			c.summary.setState("startup grace period")
+			return nil
+		}
+		c.stateMu.Lock()
+		c.gracePassed = true
+		c.stateMu.Unlock()
+	}
+
+	// reconcile every config in order so one which can't be built or
+	// written doesn't hold back the others. The write errors are returned
+	// together to retry the reconcile. Configs whose write was refused or
+	// deferred aren't installed but don't stop the others.
+	var errs *multierror.Error
+	var failedBuilds, notApplied []string
+	for _, config := range configs {
+		desired, err := c.buildValidatingWebhookConfiguration(o, config)
+		if err != nil {
+			c.traceDecision("build", "%v: %v", config.name, err)
+			scope.Errorf("Failed to build validatingwebhookconfiguration %v: %v", config.name, err)
+			c.metrics.ReportValidationConfigLoadError(config.name, err.(*configError).Reason())
+			failure = err.(*configError).Reason()
+			// no point in retrying unless a local config or cert file changes.
+			failedBuilds = append(failedBuilds, config.name)
+			continue
+		}
+		c.traceDecision("build", "%v: ok", config.name)
+		if o.WebhookConfigSelector != nil {
+			// the template is shared by the matching configs.
+			desired.Name = config.name
+		}
+		c.warnServicePortMismatches(o, desired)
+		if failOpen {
+			if names := failOpenWebhooks(desired); len(names) > 0 {
+				c.traceDecision("fail open", "%v: %v", config.name, names)
+			}
+		}
+		applied, err := c.updateWebhookConfiguration(ctx, o, validatingConfigKind, desired)
+		if err != nil {
+			c.traceDecision("write", "%v: %v", config.name, err)
+			errs = multierror.Append(errs, err)
+			continue
+		}
+		if !applied {
+			notApplied = append(notApplied, config.name)
+		}
+	}
+	if o.ManageMutatingWebhook {
+		name := o.MutatingWebhookConfigName
+		if desired, err := c.buildMutatingWebhookConfiguration(o); err != nil {
+			c.traceDecision("build", "%v: %v", name, err)
+			scope.Errorf("Failed to build mutatingwebhookconfiguration %v: %v", name, err)
+			c.metrics.ReportValidationConfigLoadError(name, err.(*configError).Reason())
+			failure = err.(*configError).Reason()
+			failedBuilds = append(failedBuilds, name)
+		} else {
+			c.traceDecision("build", "%v: ok", name)
+			if failOpen {
+				if names := failOpenMutatingWebhooks(desired); len(names) > 0 {
+					c.traceDecision("fail open", "%v: %v", name, names)
+				}
+			}
+			applied, err := c.updateWebhookConfiguration(ctx, o, mutatingConfigKind, desired)
+			if err != nil {
+				c.traceDecision("write", "%v: %v", name, err)
+				errs = multierror.Append(errs, err)
+			} else if !applied {
+				notApplied = append(notApplied, name)
+			}
+		}
+	}
+	if errs != nil {
+		if len(errs.Errors) == 1 {
+			// keep the reason of a single error for the summary and retries.
+			return errs.Errors[0]
+		}
+		return errs
+	}
+	if len(failedBuilds) > 0 {
+		c.summary.setState(fmt.Sprintf("failed to build %v", strings.Join(failedBuilds, ", ")))
+		return nil
+	}
+	if len(notApplied) > 0 {
+		c.traceDecision("installed", "false: not applied: %v", notApplied)
+		failure = "not applied"
+		c.summary.setState(fmt.Sprintf("not applied: %v", strings.Join(notApplied, ", ")))
+		return nil
+	}
+	c.summary.setSettled(c.clock.Now(), "installed")
+
+	if o.PruneStaleRevisionConfigs {
+		return c.pruneStaleRevisionConfigs(ctx, o, configs)
+	}
+	return nil
+}
+
+// pausedConfig returns the name of the first live config annotated to pause
+// reconciliation, if any.
+func (c *Controller) pausedConfig(configs []webhookConfig) string {
+	lister := c.sharedInformers.Admissionregistration().V1beta1().ValidatingWebhookConfigurations().Lister()
+	for _, config := range configs {
+		current, err := lister.Get(config.name)
+		if err == nil && current.Annotations[pauseAnnotation] == "true" {
+			return config.name
+		}
+	}
+	return ""
+}
+
+// pruneStaleRevisionConfigs deletes the webhook configs managed by this
+// controller for other revisions. Nothing is pruned until every config of
+// the current revision has been observed installed so validation isn't
+// interrupted while the new configs are being created.
+func (c *Controller) pruneStaleRevisionConfigs(ctx context.Context, o Options, configs []webhookConfig) error {
+	lister := c.sharedInformers.Admissionregistration().V1beta1().ValidatingWebhookConfigurations().Lister()
+
+	var names []string
+	for _, config := range configs {
+		current, err := lister.Get(config.name)
+		if err != nil || current.Labels[revisionLabel] != o.Revision {
+			scope.Infof("Deferring pruning of stale revisions until validatingwebhookconfiguration %v is installed",
+				config.name)
+			return nil
+		}
+		names = append(names, config.name)
+	}
+
+	managed, err := lister.List(kubeLabels.SelectorFromSet(kubeLabels.Set{managedByLabel: o.managedBy()}))
+	if err != nil {
+		return err
+	}
+	for _, config := range managed {
+		if containsName(names, config.Name) || config.Labels[revisionLabel] == o.Revision {
+			continue
+		}
+		scope.Infof("Pruning validatingwebhookconfiguration %v of stale revision %q",
+			config.Name, config.Labels[revisionLabel])
+		if err := c.deleteWebhookConfiguration(ctx, o, validatingConfigKind, config.Name); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// failOpenWebhooks sets the failurePolicy of the webhooks which fail closed
+// to Ignore and returns their names.
+func failOpenWebhooks(config *kubeApiAdmission.ValidatingWebhookConfiguration) []string {
+	var names []string
+	for i, webhook := range config.Webhooks {
+		if webhook.FailurePolicy == nil || *webhook.FailurePolicy == kubeApiAdmission.Fail {
+			ignore := kubeApiAdmission.Ignore
+			config.Webhooks[i].FailurePolicy = &ignore
+			names = append(names, webhook.Name)
+		}
+	}
+	return names
+}
+
+// failOpenMutatingWebhooks is failOpenWebhooks for the mutating config.
+func failOpenMutatingWebhooks(config *kubeApiAdmission.MutatingWebhookConfiguration) []string {
+	var names []string
+	for i, webhook := range config.Webhooks {
+		if webhook.FailurePolicy == nil || *webhook.FailurePolicy == kubeApiAdmission.Fail {
+			ignore := kubeApiAdmission.Ignore
+			config.Webhooks[i].FailurePolicy = &ignore
+			names = append(names, webhook.Name)
+		}
+	}
+	return names
+}
+
+// startupGracePassed returns true once the startup grace period has elapsed
+// and the endpoint is ready. Otherwise a reconcile is scheduled for when the
+// period elapses.
+func (c *Controller) startupGracePassed(o Options) (bool, error) {
+	c.stateMu.Lock()
+	startTime := c.startTime
+	c.stateMu.Unlock()
+	if remaining := o.StartupGracePeriod - c.clock.Since(startTime); remaining > 0 {
+		scope.Infof("Startup grace period: deferring installation of validatingwebhookconfiguration for %v", remaining)
+		c.queue.AddAfter(&reconcileRequest{description: "startup grace period elapsed"}, remaining)
+		return false, nil
+	}
+	ready, reason, err := c.isEndpointReady(o)
+	if err != nil {
+		scope.Errorf("Error checking endpoint readiness: %v", err)
+		return false, err
+	}
+	if !ready {
+		scope.Infof("Startup grace period elapsed but endpoint is not ready: %v", reason)
+	}
+	return ready, nil
+}
+
+// checkEndpointReadyTimeout escalates once if the endpoint hasn't been ready
+// since the controller started for longer than the EndpointReadyTimeout.
+// Otherwise a reconcile is scheduled for when the timeout elapses so it is
+// noticed even if the endpoint doesn't change. The escalation is recorded
+// on each of the managed configs.
+func (c *Controller) checkEndpointReadyTimeout(o Options, configs []webhookConfig, reason string) {
+	c.stateMu.Lock()
+	startTime, timedOut := c.startTime, c.endpointReadyTimedOut
+	c.stateMu.Unlock()
+	if timedOut {
+		return
+	}
+	if remaining := o.EndpointReadyTimeout - c.clock.Since(startTime); remaining > 0 {
+		c.keyedMu.Lock()
+		c.keyedDescriptions[endpointReadyTimeoutReconcileKey] = "endpoint ready timeout elapsed"
+		c.keyedMu.Unlock()
+		c.queue.AddAfter(endpointReadyTimeoutReconcileKey, remaining)
+		return
+	}
+	c.stateMu.Lock()
+	c.endpointReadyTimedOut = true
+	c.stateMu.Unlock()
+
+	scope.Errorf("Endpoint %v/%v not ready within %v of starting: %v. The webhook config is installed once it is ready.",
+		o.serviceNamespace(), o.ServiceName, o.EndpointReadyTimeout, reason)
+	c.metrics.ReportEndpointReadyTimeout()
+	for _, config := range configs {
+		c.recordConfigEvent(config.name, kubeApiCore.EventTypeWarning, eventReasonEndpointNotReady,
+			"Endpoint %v/%v not ready within %v: %v",
+			o.serviceNamespace(), o.ServiceName, o.EndpointReadyTimeout, reason)
+	}
+}
+
+// Reasons the webhook endpoint is not ready. These are bounded so they can
+// be used as metric labels.
+const (
+	endpointNotFound             = "endpoint not found"
+	endpointNoSubsets            = "no subsets"
+	endpointNoAddressesReady     = "no subset addresses ready"
+	endpointTooFewAddressesReady = "too few subset addresses ready"
+)
+
+// isEndpointReady returns whether the webhook endpoint is ready and, if
+// not, why.
+func (c *Controller) isEndpointReady(o Options) (ready bool, reason string, err error) {
+	namespace := o.serviceNamespace()
+	endpoint, err := c.informersFor(o, namespace).Core().V1().
+		Endpoints().Lister().Endpoints(namespace).Get(o.ServiceName)
+	if err != nil {
+		if kubeErrors.IsNotFound(err) {
+			return false, endpointNotFound, nil
+		}
+		return false, "", err
+	}
+	ready, reason = isEndpointReady(endpoint, o.MinReadyEndpoints)
+	return ready, reason, nil
+}
+
+func isEndpointReady(endpoint *kubeApiCore.Endpoints, minReady int) (ready bool, reason string) {
+	if len(endpoint.Subsets) == 0 {
+		return false, endpointNoSubsets
+	}
+	if minReady < 1 {
+		minReady = 1
+	}
+	var numReady, numNotReady int
+	for _, subset := range endpoint.Subsets {
+		numReady += len(subset.Addresses)
+		numNotReady += len(subset.NotReadyAddresses)
+	}
+	if numReady == 0 {
+		return false, endpointNoAddressesReady
+	}
+	if numReady < minReady {
+		scope.Debugf("%v of %v required subset addresses ready (%v not ready)", numReady, minReady, numNotReady)
+		return false, endpointTooFewAddressesReady
+	}
+	return true, ""
+}
+
+func (c *Controller) areRequiredCRDsEstablished(o Options) (established bool, err error) {
+	lister := c.crdInformers.Apiextensions().V1beta1().CustomResourceDefinitions().Lister()
+	for _, name := range o.RequiredCRDs {
+		crd, err := lister.Get(name)
+		if kubeErrors.IsNotFound(err) {
+			scope.Infof("Required CRD %v not found", name)
+			return false, nil
+		}
+		if err != nil {
+			return false, err
+		}
+		if !isCRDEstablished(crd) {
+			scope.Infof("Required CRD %v not established", name)
+			return false, nil
+		}
+	}
+	return true, nil
+}
+
+func isCRDEstablished(crd *kubeApiExtensions.CustomResourceDefinition) bool {
+	for _, cond := range crd.Status.Conditions {
+		if cond.Type == kubeApiExtensions.Established {
+			return cond.Status == kubeApiExtensions.ConditionTrue
+		}
+	}
+	return false
+}
+
+// onServiceUpdate enqueues a reconcile when the selector or ports of the
+// webhook service change, and warns when the selector changes while the
+// webhook config is installed. A selector which no longer matches the
+// webhook server pods breaks the webhook before the endpoint readiness
+// check can notice.
+func (c *Controller) onServiceUpdate(prev, curr interface{}) {
+	o := c.options()
+	prevService, ok := prev.(*kubeApiCore.Service)
+	if !ok {
+		return
+	}
+	currService, ok := curr.(*kubeApiCore.Service)
+	if !ok || currService.Name != o.ServiceName {
+		return
+	}
+	if !reflect.DeepEqual(prevService.Spec.Ports, currService.Spec.Ports) {
+		req := &reconcileRequest{
+			description: fmt.Sprintf("ports of service %v/%v changed", currService.Namespace, currService.Name),
+			namespace:   currService.Namespace,
+		}
+		c.queue.Add(req)
+	}
+	if reflect.DeepEqual(prevService.Spec.Selector, currService.Spec.Selector) {
+		return
+	}
+
+	lister := c.sharedInformers.Admissionregistration().V1beta1().ValidatingWebhookConfigurations().Lister()
+	configs, _ := c.managedConfigs(o)
+	for _, config := range configs {
+		if _, err := lister.Get(config.name); err == nil {
+			scope.Warnf("Selector of webhook service %v/%v changed from %v to %v while validatingwebhookconfiguration "+
+				"%v is installed. Verify the selector still matches the webhook server pods.",
+				currService.Namespace, currService.Name, prevService.Spec.Selector, currService.Spec.Selector, config.name)
+			c.metrics.ReportServiceSelectorChanged()
+			break
+		}
+	}
+
+	req := &reconcileRequest{
+		description: fmt.Sprintf("selector of service %v/%v changed", currService.Namespace, currService.Name),
+		namespace:   currService.Namespace,
+	}
+	c.queue.Add(req)
+}
+
+// warnServicePortMismatches warns about webhooks of the config which call
+// the webhook service on a port the service doesn't expose.
+func (c *Controller) warnServicePortMismatches(o Options, config *kubeApiAdmission.ValidatingWebhookConfiguration) {
+	namespace := o.serviceNamespace()
+	service, err := c.informersFor(o, namespace).Core().V1().Services().Lister().Services(namespace).Get(o.ServiceName)
+	if err != nil {
+		return
+	}
+	if mismatched := servicePortMismatches(config, service); len(mismatched) > 0 {
+		c.traceDecision("service ports", "%v: mismatched %v", config.Name, mismatched)
+		scope.Warnf("validatingwebhookconfiguration %v: webhooks %v call a port not exposed by service %v/%v",
+			config.Name, mismatched, service.Namespace, service.Name)
+	}
+}
+
+// resolveServicePort returns the number of the ServicePortName port of the
+// webhook service, or nil when ServicePortName is empty.
+func (c *Controller) resolveServicePort(o Options) (*int32, *configError) {
+	if o.ServicePortName == "" {
+		return nil, nil
+	}
+	namespace := o.serviceNamespace()
+	service, err := c.informersFor(o, namespace).Core().V1().Services().Lister().Services(namespace).Get(o.ServiceName)
+	if err != nil {
+		return nil, &configError{err, "could not resolve webhook service port"}
+	}
+	for _, servicePort := range service.Spec.Ports {
+		if servicePort.Name == o.ServicePortName {
+			port := servicePort.Port
+			return &port, nil
+		}
+	}
+	return nil, &configError{
+		fmt.Errorf("service %v/%v has no port named %q", namespace, o.ServiceName, o.ServicePortName),
+		"could not resolve webhook service port",
+	}
+}
+
+// setServicePort sets the port of the webhooks of the config which call
+// the webhook service. The ports of the template are kept when port is nil.
+func setServicePort(o Options, config *kubeApiAdmission.ValidatingWebhookConfiguration, port *int32) {
+	if port == nil {
+		return
+	}
+	for i := range config.Webhooks {
+		ref := config.Webhooks[i].ClientConfig.Service
+		if ref == nil || ref.Name != o.ServiceName || ref.Namespace != o.serviceNamespace() {
+			continue
+		}
+		p := *port
+		ref.Port = &p
+	}
+}
+
+// servicePortMismatches returns the webhooks which call the service on a
+// port it doesn't expose. The port defaults to 443.
+func servicePortMismatches(config *kubeApiAdmission.ValidatingWebhookConfiguration, service *kubeApiCore.Service) []string {
+	var mismatched []string
+	for _, webhook := range config.Webhooks {
+		ref := webhook.ClientConfig.Service
+		if ref == nil || ref.Name != service.Name || ref.Namespace != service.Namespace {
+			continue
+		}
+		port := int32(443)
+		if ref.Port != nil {
+			port = *ref.Port
+		}
+		exposed := false
+		for _, servicePort := range service.Spec.Ports {
+			if servicePort.Port == port {
+				exposed = true
+				break
+			}
+		}
+		if !exposed {
+			mismatched = append(mismatched, fmt.Sprintf("%v (port %v)", webhook.Name, port))
+		}
+	}
+	return mismatched
+}
+
+func (c *Controller) isGalleyDeploymentRunning(o Options) (running bool, err error) {
+	namespace := o.galleyNamespace()
+	galley, err := c.informersFor(o, namespace).Apps().V1().
+		Deployments().Lister().Deployments(namespace).Get(o.GalleyDeploymentName)
+
+	// galley does/doesn't exist
+	if err != nil {
+		if kubeErrors.IsNotFound(err) {
+			c.setGalleyUnavailableSince(time.Time{})
+			return false, nil
+		}
+		return false, err
+	}
+
+	// galley is scaled down to zero replicas. This is useful for debugging
+	// to force the istiod controller to run.
+	if galley.Spec.Replicas == nil || *galley.Spec.Replicas == 0 {
+		c.setGalleyUnavailableSince(time.Time{})
+		return false, nil
+	}
+
+	if galley.Status.AvailableReplicas > 0 || galley.Status.ReadyReplicas > 0 {
+		c.setGalleyUnavailableSince(time.Time{})
+		return true, nil
+	}
+
+	// galley is deployed but unavailable, e.g. its rollout is stuck. Keep
+	// deferring to it for the grace period before taking over.
+	c.stateMu.Lock()
+	if c.galleyUnavailableSince.IsZero() {
+		c.galleyUnavailableSince = c.clock.Now()
+	}
+	since := c.galleyUnavailableSince
+	c.stateMu.Unlock()
+	if remaining := o.galleyUnavailableGracePeriod() - c.clock.Since(since); remaining > 0 {
+		scope.Infof("Galley deployment %v/%v has no available replicas, deferring to it for %v",
+			namespace, o.GalleyDeploymentName, remaining)
+		c.queue.AddAfter(&reconcileRequest{description: "galley unavailable grace period elapsed"}, remaining)
+		return true, nil
+	}
+	scope.Warnf("Galley deployment %v/%v has had no available replicas for %v, taking over reconciling config",
+		namespace, o.GalleyDeploymentName, c.clock.Since(since))
+	return false, nil
+}
+
+func (c *Controller) setGalleyUnavailableSince(since time.Time) {
+	c.stateMu.Lock()
+	defer c.stateMu.Unlock()
+	c.galleyUnavailableSince = since
+}
+
+// deleteWebhookConfiguration deletes the named config of the kind, if present.
+func (c *Controller) deleteWebhookConfiguration(ctx context.Context, o Options, kind configKind, name string) error {
+	defer c.lockConfig(name)()
+
+	resource := kind.resource()
+	if o.DryRun {
+		if _, err := kind.cached(c, name); err == nil {
+			c.traceDecision("write", "%v: dry-run delete", name)
+			scope.Infof("Dry-run: would delete %v %v", resource, name)
+		}
+		return nil
+	}
+	err := c.kube.delete(ctx, kind.gvr, name)
+	if kubeErrors.IsNotFound(err) {
+		return nil
+	}
+	if err != nil {
+		scope.Error("Failed to delete "+resource, writeErrorLogFields(name, err)...)
+		kind.reportDeleteError(c.metrics, name, kubeErrors.ReasonForError(err))
+		return err
+	}
+	scope.Info("Successfully deleted "+resource, writeLogFields(name, "deleted")...)
+	c.recordEvent(kind.gvk, name, kubeApiCore.EventTypeNormal, eventReasonDeleted, "Deleted by %v", o.managedBy())
+	return nil
+}
+
+// updateWebhookConfiguration creates or updates the config of the kind to
+// match desired. It returns true if the config was written or already
+// matched, and false if the write was refused or deferred, e.g. since the
+// config isn't owned by the controller or was rejected as invalid.
+func (c *Controller) updateWebhookConfiguration(ctx context.Context, o Options, kind configKind, desired runtime.Object) (bool, error) {
+	name := objectName(desired)
+	resource := kind.resource()
+	defer c.lockConfig(name)()
+
+	current, err := kind.cached(c, name)
+
+	if err == nil && c.updateRefused(o, kind, kind.view(current), kind.view(desired)) {
+		return false, nil
+	}
+	if kubeErrors.IsNotFound(err) && kind.updateOnly(o) {
+		// configs matching the selector are only updated. It was deleted
+		// since it was listed.
+		return true, nil
+	}
+
+	if o.UseServerSideApply {
+		if kubeErrors.IsNotFound(err) {
+			current = nil
+		} else if err != nil {
+			return false, err
+		}
+		return c.applyWebhookConfiguration(ctx, o, kind, current, desired)
+	}
+
+	if kubeErrors.IsNotFound(err) {
+		c.traceDecision("diff", "%v: not found", name)
+		if o.DryRun {
+			c.traceDecision("write", "%v: dry-run create", name)
+			scope.Infof("Dry-run: would create %v %v", resource, name)
+			kind.reportUpdate(c, name)
+			return true, nil
+		}
+		if c.throttleWrite(o, name) {
+			return false, nil
+		}
+		if c.preApplyRejected(o, kind, desired) {
+			return false, nil
+		}
+		if err := c.kube.create(ctx, kind.gvr, desired, kind.newConfig()); err != nil {
+			kind.reportUpdateError(c.metrics, name, kubeErrors.ReasonForError(err))
+			c.recordEvent(kind.gvk, name, kubeApiCore.EventTypeWarning, eventReasonUpdateFailed, "Create failed: %v", err)
+			return false, c.handleWriteError("create", resource, name, err)
+		}
+		c.recordWrite(ctx)
+		c.traceDecision("write", "%v: created", name)
+		scope.Info("Successfully created "+resource, writeLogFields(name, "created")...)
+		kind.reportUpdate(c, name)
+		c.recordEvent(kind.gvk, name, kubeApiCore.EventTypeNormal, eventReasonCreated, "Created by %v", o.managedBy())
+		return true, nil
+	}
+
+	updated := kind.merge(current, desired, o.PreserveSelectors)
+	changed := !reflect.DeepEqual(updated, current)
+	c.traceDecision("diff", "%v: changed=%v", name, changed)
+	if changed {
+		diff := c.recordDiff(resource, name, current, updated)
+		if o.DryRun {
+			c.traceDecision("write", "%v: dry-run update", name)
+			scope.Infof("Dry-run: would update %v %v: %v", resource, name, diff)
+			kind.reportUpdate(c, name)
+			return true, nil
+		}
+		if c.throttleWrite(o, name) {
+			return false, nil
+		}
+		if c.preApplyRejected(o, kind, updated) {
+			return false, nil
+		}
+		// updated carries the resourceVersion of the cached config, so a
+		// concurrent write since the cache was synced is a conflict rather
+		// than lost.
+		err := c.kube.update(ctx, kind.gvr, name, updated, kind.newConfig())
+		if kubeErrors.IsConflict(err) {
+			var applied bool
+			if applied, err = c.updateLiveWebhookConfiguration(ctx, o, kind, desired); err == nil && !applied {
+				return false, nil
+			}
+		}
+		if err != nil {
+			kind.reportUpdateError(c.metrics, name, kubeErrors.ReasonForError(err))
+			c.recordEvent(kind.gvk, name, kubeApiCore.EventTypeWarning, eventReasonUpdateFailed, "Update failed: %v", err)
+			return false, c.handleWriteError("update", resource, name, err)
+		}
+		c.recordWrite(ctx)
+		c.traceDecision("write", "%v: updated", name)
+		c.recordEvent(kind.gvk, name, kubeApiCore.EventTypeNormal, eventReasonUpdated, "Updated by %v", o.managedBy())
+	}
+	outcome := "unchanged"
+	if changed {
+		outcome = "updated"
+	}
+	scope.Info("Successfully updated "+resource, writeLogFields(name, outcome)...)
+	kind.reportUpdate(c, name)
+	return true, nil
+}
+
+// updateRefused runs the guards shared by the validating and mutating
+// configs before desired is written over the current config. It returns
+// true if the write is refused since the config is managed by another
+// controller, isn't owned by this one, or its caBundle would shrink.
+func (c *Controller) updateRefused(o Options, kind configKind, current, desired *kubeApiAdmission.ValidatingWebhookConfiguration) bool {
+	resource := kind.resource()
+	if manager, other := o.managedByOther(current.Labels); other {
+		c.traceDecision("diff", "%v: managed by %q", desired.Name, manager)
+		scope.Warnf("Not updating %v %v managed by %q instead of %q",
+			resource, desired.Name, manager, o.managedBy())
+		return true
+	}
+	// a config labeled as managed by this controller is owned even if its
+	// owner references were stripped, which are restored by the update.
+	if !ownedBy(current, desired.OwnerReferences) && current.Labels[managedByLabel] != o.managedBy() {
+		if o.RefuseUnowned {
+			c.traceDecision("diff", "%v: not owned by %v", desired.Name, o.ClusterRoleName)
+			scope.Errorf("Not updating %v %v without an owner reference to clusterrole %v. "+
+				"Delete it or allow adopting unowned configs.", resource, desired.Name, o.ClusterRoleName)
+			kind.reportUpdateError(c.metrics, desired.Name, reasonNotOwned)
+			c.recordEvent(kind.gvk, desired.Name, kubeApiCore.EventTypeWarning, eventReasonUpdateFailed,
+				"Not owned by clusterrole %v", o.ClusterRoleName)
+			return true
+		}
+		scope.Warnf("Adopting %v %v without an owner reference to clusterrole %v",
+			resource, desired.Name, o.ClusterRoleName)
+	}
+	if !o.AllowCABundleShrink {
+		if shrunk := shrunkCABundles(current, desired); len(shrunk) > 0 {
+			c.traceDecision("diff", "%v: caBundle shrunk: %v", desired.Name, shrunk)
+			scope.Warnf("Not updating %v %v: the caBundle of webhooks %v would be "+
+				"replaced by an empty bundle or one with fewer certificates", resource, desired.Name, shrunk)
+			c.metrics.ReportCABundleShrinkRefused(desired.Name)
+			return true
+		}
+	}
+	if drift := ownerRefDrift(current, desired.OwnerReferences); len(drift) > 0 {
+		c.traceDecision("diff", "%v: owner references drifted: %v", desired.Name, drift)
+		scope.Infof("Restoring the owner references of %v %v: %v",
+			resource, desired.Name, strings.Join(drift, ", "))
+	}
+	return false
+}
+
+// updateLiveWebhookConfiguration retries an update which conflicted
+// against the config read from the kube-apiserver rather than the informer
+// cache, which may not have observed the conflicting write yet. It returns
+// false if the config was left as is without matching desired.
+func (c *Controller) updateLiveWebhookConfiguration(ctx context.Context, o Options, kind configKind, desired runtime.Object) (bool, error) {
+	name := objectName(desired)
+	live := kind.newConfig()
+	if err := c.kube.get(ctx, kind.gvr, name, live); err != nil {
+		return false, err
+	}
+	liveView := kind.view(live)
+	if manager, other := o.managedByOther(liveView.Labels); other {
+		c.traceDecision("diff", "%v: managed by %q", name, manager)
+		scope.Warnf("Not updating %v %v managed by %q instead of %q",
+			kind.resource(), name, manager, o.managedBy())
+		return false, nil
+	}
+	updated := kind.merge(live, desired, o.PreserveSelectors)
+	if reflect.DeepEqual(updated, live) {
+		c.traceDecision("diff", "%v: changed=false after conflict", name)
+		return true, nil
+	}
+	if c.preApplyRejected(o, kind, updated) {
+		return false, nil
+	}
+	scope.Infof("Update of %v %v conflicted, retrying with resourceVersion %v",
+		kind.resource(), name, liveView.ResourceVersion)
+	err := c.kube.update(ctx, kind.gvr, name, updated, kind.newConfig())
+	return err == nil, err
+}
+
+// reasonPreApplyRejected is reported when a pre-apply hook rejects a config.
+const reasonPreApplyRejected kubeApiMeta.StatusReason = "PreApplyRejected"
+
+// preApplyRejected runs the pre-apply hook of the kind, if any, and returns
+// true if it rejected writing the config.
+func (c *Controller) preApplyRejected(o Options, kind configKind, config runtime.Object) bool {
+	err := kind.preApply(o, config)
+	if err == nil {
+		return false
+	}
+	name := objectName(config)
+	c.traceDecision("write", "%v: rejected by pre-apply hook: %v", name, err)
+	scope.Errorf("Not writing %v %v rejected by the pre-apply hook: %v", kind.resource(), name, err)
+	kind.reportUpdateError(c.metrics, name, reasonPreApplyRejected)
+	c.recordEvent(kind.gvk, name, kubeApiCore.EventTypeWarning, eventReasonUpdateFailed, "Rejected before write: %v", err)
+	return true
+}
+
+// reasonNotOwned is reported when an existing config isn't updated because
+// it lacks the owner references of the controller.
+const reasonNotOwned kubeApiMeta.StatusReason = "NotOwned"
+
+// ownedBy returns true if the config has each of the owner references,
+// ignoring the UID so an owner which was recreated still matches. Any
+// config is owned by an empty list of owner references.
+func ownedBy(config *kubeApiAdmission.ValidatingWebhookConfiguration, ownerRefs []kubeApiMeta.OwnerReference) bool {
+	for _, want := range ownerRefs {
+		found := false
+		for _, ref := range config.OwnerReferences {
+			if ref.APIVersion == want.APIVersion && ref.Kind == want.Kind && ref.Name == want.Name {
+				found = true
+				break
+			}
+		}
+		if !found {
+			return false
+		}
+	}
+	return true
+}
+
+// ownerRefDrift describes how the owner references of the config differ
+// from the ownerRefs of the controller. Each of the ownerRefs must be
+// present as the controller reference of the current owner. Any other
+// owner reference is unexpected: the config would be garbage collected
+// along with an owner which isn't managed by the controller.
+func ownerRefDrift(config *kubeApiAdmission.ValidatingWebhookConfiguration, ownerRefs []kubeApiMeta.OwnerReference) []string {
+	sameOwner := func(a, b kubeApiMeta.OwnerReference) bool {
+		return a.APIVersion == b.APIVersion && a.Kind == b.Kind && a.Name == b.Name
+	}
+	var drift []string
+	for _, want := range ownerRefs {
+		found := false
+		for _, ref := range config.OwnerReferences {
+			if !sameOwner(ref, want) {
+				continue
+			}
+			found = true
+			if ref.UID != want.UID {
+				drift = append(drift, fmt.Sprintf("owner reference to %v %v has stale uid %v", ref.Kind, ref.Name, ref.UID))
+			}
+			if ref.Controller == nil || !*ref.Controller {
+				drift = append(drift, fmt.Sprintf("owner reference to %v %v is not the controller reference", ref.Kind, ref.Name))
+			}
+		}
+		if !found {
+			drift = append(drift, fmt.Sprintf("missing owner reference to %v %v", want.Kind, want.Name))
+		}
+	}
+	for _, ref := range config.OwnerReferences {
+		expected := false
+		for _, want := range ownerRefs {
+			if sameOwner(ref, want) {
+				expected = true
+				break
+			}
+		}
+		if !expected {
+			drift = append(drift, fmt.Sprintf("unexpected owner reference to %v %v", ref.Kind, ref.Name))
+		}
+	}
+	return drift
+}
+
+// mergeDesired returns a copy of current with the fields managed by the
+// controller set from desired. If preserveSelectors is true the
+// namespaceSelector and objectSelector of the current webhooks are merged
+// into the desired ones.
+func mergeDesired(
+	current, desired *kubeApiAdmission.ValidatingWebhookConfiguration,
+	preserveSelectors bool,
+) *kubeApiAdmission.ValidatingWebhookConfiguration {
+	updated := current.DeepCopyObject().(*kubeApiAdmission.ValidatingWebhookConfiguration)
+	updated.Webhooks = desired.Webhooks
+	if preserveSelectors {
+		updated.Webhooks = mergeSelectors(current.Webhooks, desired.Webhooks)
+	}
+	updated.Webhooks = keepWebhookOrder(current.Webhooks, updated.Webhooks)
+	updated.OwnerReferences = desired.OwnerReferences
+	for k, v := range desired.Labels {
+		if updated.Labels == nil {
+			updated.Labels = make(map[string]string)
+		}
+		updated.Labels[k] = v
+	}
+	return updated
+}
+
+// shrunkCABundles returns the names of the webhooks whose current non-empty
+// caBundle would be replaced by an empty bundle or one with fewer PEM
+// certificates. Replacing a bundle by a different one of the same size,
+// e.g. when the CA is rotated, isn't a shrink.
+func shrunkCABundles(current, desired *kubeApiAdmission.ValidatingWebhookConfiguration) []string {
+	var shrunk []string
+	for _, webhook := range desired.Webhooks {
+		for _, live := range current.Webhooks {
+			if live.Name != webhook.Name {
+				continue
+			}
+			if len(live.ClientConfig.CABundle) == 0 {
+				continue
+			}
+			if len(webhook.ClientConfig.CABundle) == 0 ||
+				countPEMCertificates(webhook.ClientConfig.CABundle) < countPEMCertificates(live.ClientConfig.CABundle) {
+				shrunk = append(shrunk, webhook.Name)
+			}
+		}
+	}
+	return shrunk
+}
+
+func countPEMCertificates(caBundle []byte) int {
+	var n int
+	for block, rest := pem.Decode(caBundle); block != nil; block, rest = pem.Decode(rest) {
+		if block.Type == "CERTIFICATE" {
+			n++
+		}
+	}
+	return n
+}
+
+// keepWebhookOrder returns the desired webhooks in the order of the current
+// webhooks of the same name, followed by the new webhooks in the desired
+// order. Reordering the webhooks of the template alone doesn't rewrite the
+// live config.
+func keepWebhookOrder(current, desired []kubeApiAdmission.ValidatingWebhook) []kubeApiAdmission.ValidatingWebhook {
+	ordered := make([]kubeApiAdmission.ValidatingWebhook, 0, len(desired))
+	added := make(map[int]bool, len(desired))
+	for _, live := range current {
+		for i, webhook := range desired {
+			if !added[i] && webhook.Name == live.Name {
+				ordered = append(ordered, webhook)
+				added[i] = true
+				break
+			}
+		}
+	}
+	for i, webhook := range desired {
+		if !added[i] {
+			ordered = append(ordered, webhook)
+		}
+	}
+	return ordered
+}
+
+// mergeSelectors returns a copy of the desired webhooks with the selector
+// labels and expressions added to the current webhooks of the same name
+// out-of-band, e.g. by a cluster admin excluding sensitive namespaces.
+// Labels set by both keep the desired value.
+func mergeSelectors(current, desired []kubeApiAdmission.ValidatingWebhook) []kubeApiAdmission.ValidatingWebhook {
+	merged := make([]kubeApiAdmission.ValidatingWebhook, 0, len(desired))
+	for _, webhook := range desired {
+		webhook := *webhook.DeepCopy()
+		for _, live := range current {
+			if live.Name == webhook.Name {
+				webhook.NamespaceSelector = mergeSelector(live.NamespaceSelector, webhook.NamespaceSelector)
+				webhook.ObjectSelector = mergeSelector(live.ObjectSelector, webhook.ObjectSelector)
+				break
+			}
+		}
+		merged = append(merged, webhook)
+	}
+	return merged
+}
+
+func mergeSelector(current, desired *kubeApiMeta.LabelSelector) *kubeApiMeta.LabelSelector {
+	if current == nil {
+		return desired
+	}
+	if desired == nil {
+		return current.DeepCopy()
+	}
+	for k, v := range current.MatchLabels {
+		if _, ok := desired.MatchLabels[k]; ok {
+			continue
+		}
+		if desired.MatchLabels == nil {
+			desired.MatchLabels = make(map[string]string)
+		}
+		desired.MatchLabels[k] = v
+	}
+	for _, expr := range current.MatchExpressions {
+		found := false
+		for _, want := range desired.MatchExpressions {
+			if reflect.DeepEqual(expr, want) {
+				found = true
+				break
+			}
+		}
+		if !found {
+			desired.MatchExpressions = append(desired.MatchExpressions, expr)
+		}
+	}
+	return desired
+}
+
+// handleWriteError logs a failed create or update of the named config of
+// the given resource. Invalid errors are returned as nil since the apiserver will keep
+// rejecting the same config. Retrying won't help until the template changes.
+func (c *Controller) handleWriteError(op, resource, name string, err error) error {
+	if !kubeErrors.IsInvalid(err) {
+		scope.Error(fmt.Sprintf("Failed to %v %v", op, resource), writeErrorLogFields(name, err)...)
+		return err
+	}
+	scope.Error(fmt.Sprintf("Failed to %v %v: rejected as invalid by the kube-apiserver. "+
+		"Fix the webhook config template; the %v will not be retried until it changes.", op, resource, op),
+		writeErrorLogFields(name, err)...)
+	if status, ok := err.(kubeErrors.APIStatus); ok && status.Status().Details != nil {
+		for _, cause := range status.Status().Details.Causes {
+			scope.Errorf("  field %v: %v (%v)", cause.Field, cause.Message, cause.Type)
+		}
+	}
+	return nil
+}
+
+// throttleWrite returns true if writing the named config must be deferred to
+// honor MinUpdateInterval. A reconcile is scheduled for when the write is
+// permitted.
+func (c *Controller) throttleWrite(o Options, name string) bool {
+	if o.MinUpdateInterval <= 0 {
+		return false
+	}
+	c.writeMu.Lock()
+	defer c.writeMu.Unlock()
+	if c.lastWrite.IsZero() {
+		return false
+	}
+	wait := o.MinUpdateInterval - c.clock.Since(c.lastWrite)
+	if wait <= 0 {
+		return false
+	}
+	scope.Infof("Deferring write of validatingwebhookconfiguration %v for %v", name, wait)
+	c.traceDecision("write", "%v: deferred for %v", name, wait)
+	c.queue.AddAfter(&reconcileRequest{description: fmt.Sprintf("deferred write of %v", name)}, wait)
+	return true
+}
+
+func (c *Controller) recordWrite(ctx context.Context) {
+	if span, ok := ctx.Value(reconcileSpanKey{}).(*reconcileSpan); ok {
+		span.wrote = true
+	}
+	c.writeMu.Lock()
+	defer c.writeMu.Unlock()
+	c.lastWrite = c.clock.Now()
+}
+
+// descriptions of the watched files.
+const (
+	webhookConfigFileDescription = "validatingwebhookconfiguration file"
+	caFileDescription            = "CA file"
+	servingCertFileDescription   = "serving cert file"
+)
+
+// FileWatchError is returned by New when a local file can't be watched,
+// e.g. because it doesn't exist.
+type FileWatchError struct {
+	// Path of the file.
+	Path string
+	// Description of the file, e.g. "CA file".
+	Description string
+	Err         error
+}
+
+func (e *FileWatchError) Error() string {
+	return fmt.Sprintf("could not watch %v %v: %v", e.Description, e.Path, e.Err)
+}
+
+func (e *FileWatchError) Unwrap() error {
+	return e.Err
+}
+
+type configError struct {
+	err    error
+	reason string
+}
+
+func (e configError) Error() string {
+	return e.err.Error()
+}
+
+func (e configError) Reason() string {
+	return e.reason
+}
+
+// DesiredWebhooks returns the webhooks of the managed
+// validatingwebhookconfigurations as they would be written with the current
+// options, templates and CA bundle, in the order the configs are applied.
+// Nothing is written. An error building any of the configs is returned.
+func (c *Controller) DesiredWebhooks() ([]kubeApiAdmission.ValidatingWebhook, error) {
+	configs, err := c.desiredConfigs(c.options())
+	if err != nil {
+		return nil, err
+	}
+	var webhooks []kubeApiAdmission.ValidatingWebhook
+	for _, desired := range configs {
+		for _, webhook := range desired.Webhooks {
+			webhooks = append(webhooks, *webhook.DeepCopy())
+		}
+	}
+	return webhooks, nil
+}
+
+// desiredConfigs builds the managed validatingwebhookconfigurations in the
+// order they are applied.
+func (c *Controller) desiredConfigs(o Options) ([]*kubeApiAdmission.ValidatingWebhookConfiguration, error) {
+	configs, err := c.managedConfigs(o)
+	if err != nil {
+		return nil, err
+	}
+	desired := make([]*kubeApiAdmission.ValidatingWebhookConfiguration, 0, len(configs))
+	for _, config := range configs {
+		built, err := c.buildValidatingWebhookConfiguration(o, config)
+		if err != nil {
+			return nil, err
+		}
+		desired = append(desired, built)
+	}
+	return desired, nil
+}
+
+// sideEffectsCheckedFor returns true if the named config was last built
+// without error from the template and options with the given key, so risky
+// sideEffects were already warned about.
+func (c *Controller) sideEffectsCheckedFor(name string, key [sha256.Size]byte) bool {
+	c.sideEffectsMu.Lock()
+	defer c.sideEffectsMu.Unlock()
+	return c.sideEffectsChecked[name] == key
+}
+
+func (c *Controller) setSideEffectsChecked(name string, key [sha256.Size]byte) {
+	c.sideEffectsMu.Lock()
+	defer c.sideEffectsMu.Unlock()
+	c.sideEffectsChecked[name] = key
+}
+
+func (c *Controller) buildValidatingWebhookConfiguration(o Options, config webhookConfig) (*kubeApiAdmission.ValidatingWebhookConfiguration, error) { // nolint: lll
+	webhook, err := c.readTemplate(o, config)
+	if err != nil {
+		return nil, &configError{err, "could not read validatingwebhookconfiguration file"}
+	}
+	// risky sideEffects are warned about once per template and options.
+	checkedKey := desiredConfigKey(o.generation, webhook, nil, nil)
+	o.sideEffectsWarned = c.sideEffectsCheckedFor(config.name, checkedKey)
+	// checked before the cache since the outcome depends on the current time.
+	caBundle, webhookCABundles, err := c.readVerifiedCABundles(o)
+	if err != nil {
+		return nil, err
+	}
+	// resolved before the cache since the port of the service may change.
+	servicePort, cerr := c.resolveServicePort(o)
+	if cerr != nil {
+		return nil, cerr
+	}
+	if !o.CacheDesiredConfig {
+		desired, err := buildValidatingWebhookConfiguration(o, caBundle, webhookCABundles, webhook, c.currentOwnerRefs())
+		if err != nil {
+			return nil, err
+		}
+		c.setSideEffectsChecked(config.name, checkedKey)
+		setServicePort(o, desired, servicePort)
+		c.reportCABundleExpiry(config.name, caBundle, webhookCABundles)
+		return desired, nil
+	}
 
-func (c *Controller) runWorker() {
-	for c.processNextWorkItem() {
+	key := desiredConfigKey(o.generation, webhook, caBundle, webhookCABundles)
+	if desired := c.cache.getDesired(config.name, key); desired != nil {
+		desired.OwnerReferences = c.currentOwnerRefs()
+		setServicePort(o, desired, servicePort)
+		c.reportCABundleExpiry(config.name, caBundle, webhookCABundles)
+		return desired, nil
+	}
+	desired, err := buildValidatingWebhookConfiguration(o, caBundle, webhookCABundles, webhook, c.currentOwnerRefs())
+	if err != nil {
+		return nil, err
 	}
+	c.setSideEffectsChecked(config.name, checkedKey)
+	setServicePort(o, desired, servicePort)
+	c.cache.putDesired(config.name, key, desired)
+	c.reportCABundleExpiry(config.name, caBundle, webhookCABundles)
+	return desired, nil
 }
 
-func (c *Controller) processNextWorkItem() (cont bool) {
-	obj, shutdown := c.queue.Get()
-	if shutdown {
-		return false
+// readVerifiedCABundles reads the CA bundle and the bundles of
+// PerWebhookCAPaths, and checks that their certificates are valid.
+func (c *Controller) readVerifiedCABundles(o Options) ([]byte, map[string][]byte, error) {
+	caBundle, cerr := c.readCABundles(o)
+	if cerr != nil {
+		return nil, nil, cerr
 	}
-	defer c.queue.Done(obj)
-
-	req, ok := obj.(*reconcileRequest)
-	if !ok {
-		// don't retry an invalid reconcileRequest item
-		c.queue.Forget(req)
-		return true
+	readFile := func(path string) ([]byte, error) {
+		return c.readCachedFile(o, path)
+	}
+	webhookCABundles, cerr := readWebhookCABundles(o.PerWebhookCAPaths, readFile)
+	if cerr != nil {
+		return nil, nil, cerr
 	}
+	if err := c.verifyCABundleValidity(o, caBundle); err != nil {
+		return nil, nil, err
+	}
+	for _, name := range sortedKeys(webhookCABundles) {
+		if err := c.verifyCABundleValidity(o, webhookCABundles[name]); err != nil {
+			return nil, nil, &configError{fmt.Errorf("webhook %v: %v", name, err), err.(*configError).Reason()}
+		}
+	}
+	return caBundle, webhookCABundles, nil
+}
 
-	if err := c.reconcileRequest(req); err != nil {
-		c.queue.AddRateLimited(obj)
-		utilruntime.HandleError(err)
-	} else {
-		c.queue.Forget(obj)
+// reportCABundleExpiry reports the time until the earliest expiring
+// certificate of the CA bundles of a successfully loaded config expires.
+func (c *Controller) reportCABundleExpiry(configName string, caBundle []byte, webhookCABundles map[string][]byte) {
+	bundles := [][]byte{caBundle}
+	for _, name := range sortedKeys(webhookCABundles) {
+		bundles = append(bundles, webhookCABundles[name])
+	}
+	if expiry, ok := earliestCertExpiry(bundles...); ok {
+		c.metrics.ReportCABundleExpiry(configName, expiry.Sub(c.clock.Now()))
 	}
-	return true
 }
 
-// reconcile the desired state with the kube-apiserver.
-func (c *Controller) reconcileRequest(req *reconcileRequest) error {
-	defer func() {
-		if c.reconcileDone != nil {
-			c.reconcileDone()
+// earliestCertExpiry returns the earliest NotAfter of the certificates in the bundles.
+func earliestCertExpiry(bundles ...[]byte) (earliest time.Time, ok bool) {
+	for _, bundle := range bundles {
+		for block, rest := pem.Decode(bundle); block != nil; block, rest = pem.Decode(rest) {
+			if block.Type != "CERTIFICATE" {
+				continue
+			}
+			cert, err := x509.ParseCertificate(block.Bytes)
+			if err != nil {
+				continue
+			}
+			if !ok || cert.NotAfter.Before(earliest) {
+				earliest, ok = cert.NotAfter, true
+			}
 		}
-	}()
-
-	scope.Infof("Reconcile(enter): %v", req)
-	defer func() { scope.Info("Reconcile(exit)") }()
+	}
+	return earliest, ok
+}
 
-	// don't create the webhook config before the endpoint is ready
-	if !c.endpointReadyOnce {
-		ready, err := c.isEndpointReady()
+// readWebhookCABundles reads the CA bundles of PerWebhookCAPaths, keyed by webhook name.
+func readWebhookCABundles(paths map[string]string, readFile readFileFunc) (map[string][]byte, *configError) {
+	if len(paths) == 0 {
+		return nil, nil
+	}
+	bundles := make(map[string][]byte, len(paths))
+	for _, name := range sortedKeys(paths) {
+		bundle, err := readFile(paths[name])
 		if err != nil {
-			scope.Errorf("Error checking endpoint readiness: %v", err)
-			return err
-		}
-		if !ready {
-			scope.Infof("Endpoint not ready: ready=%v err=%v", ready, err)
-			return nil
+			return nil, &configError{fmt.Errorf("webhook %v: %v", name, err), "could not read caBundle file"}
 		}
-		c.endpointReadyOnce = true
+		bundles[name] = bundle
 	}
+	return bundles, nil
+}
 
-	// don't update the webhook config if its already managed by an existing galley deployment.
-	if c.o.GalleyDeploymentName != "" {
-		running, err := c.isGalleyDeploymentRunning()
+func sortedKeys(m interface{}) []string {
+	keys := reflect.ValueOf(m).MapKeys()
+	names := make([]string, 0, len(keys))
+	for _, key := range keys {
+		names = append(names, key.String())
+	}
+	sort.Strings(names)
+	return names
+}
+
+// readCABundles reads the CA bundle followed by the AdditionalCAPaths
+// bundles, each verified on its own.
+func (c *Controller) readCABundles(o Options) ([]byte, *configError) {
+	caBundle, err := c.readCABundle(o)
+	if err != nil {
+		return nil, &configError{err, "could not read caBundle file"}
+	}
+	if len(o.AdditionalCAPaths) == 0 {
+		return caBundle, nil
+	}
+	bundles := [][]byte{bytes.TrimRight(caBundle, "\n")}
+	for _, path := range o.AdditionalCAPaths {
+		additional, err := c.readCachedFile(o, path)
 		if err != nil {
-			scope.Errorf("Error checking galley deployment: %v", err)
-			return err
+			return nil, &configError{fmt.Errorf("%v: %v", path, err), "could not read caBundle file"}
 		}
-		if running {
-			scope.Info("Galley deployment detected")
-			return nil
+		if err := o.caBundleVerifier()(additional); err != nil {
+			return nil, &configError{fmt.Errorf("%v: %v", path, err), caBundleErrorReason(err)}
 		}
+		bundles = append(bundles, bytes.TrimRight(additional, "\n"))
 	}
+	return append(bytes.Join(bundles, []byte("\n")), '\n'), nil
+}
 
-	// actively remove the webhook configuration if the controller is running but the webhook
-	if c.o.UnregisterValidationWebhook {
-		return c.deleteValidatingWebhookConfiguration()
-	}
+// keys of the CA bundle in CASecretName, in order of preference.
+var caSecretKeys = []string{"ca.crt", "cert-chain.pem"}
 
-	desired, err := c.buildValidatingWebhookConfiguration()
+// readCABundle reads the CA bundle from CASecretName if set, CAConfigMapName
+// if set, or CAPath otherwise.
+func (c *Controller) readCABundle(o Options) ([]byte, error) {
+	if o.caFromFile() {
+		return c.readCachedFile(o, o.CAPath)
+	}
+	if o.CASecretName == "" {
+		return c.readCABundleFromConfigMap(o)
+	}
+	secret, err := c.sharedInformers.Core().V1().Secrets().Lister().
+		Secrets(o.WatchedNamespace).Get(o.CASecretName)
 	if err != nil {
-		scope.Errorf("Failed to build validatingwebhookconfiguration: %v", err)
-		reportValidationConfigLoadError(err.(*configError).Reason())
-		// no point in retrying unless a local config or cert file changes.
-		return nil
+		return nil, err
 	}
-
-	return c.updateValidatingWebhookConfiguration(desired)
+	for _, key := range caSecretKeys {
+		if caBundle, ok := secret.Data[key]; ok {
+			return caBundle, nil
+		}
+	}
+	return nil, fmt.Errorf("secret %v/%v has none of the keys %v", secret.Namespace, secret.Name, caSecretKeys)
 }
 
-func (c *Controller) isEndpointReady() (ready bool, err error) {
-	endpoint, err := c.sharedInformers.Core().V1().
-		Endpoints().Lister().Endpoints(c.o.WatchedNamespace).Get(c.o.ServiceName)
+func (c *Controller) readCABundleFromConfigMap(o Options) ([]byte, error) {
+	configMap, err := c.sharedInformers.Core().V1().ConfigMaps().Lister().
+		ConfigMaps(o.WatchedNamespace).Get(o.CAConfigMapName)
 	if err != nil {
-		if kubeErrors.IsNotFound(err) {
-			return false, nil
+		return nil, err
+	}
+	key := o.caConfigMapKey()
+	if caBundle, ok := configMap.Data[key]; ok {
+		return []byte(caBundle), nil
+	}
+	if caBundle, ok := configMap.BinaryData[key]; ok {
+		return caBundle, nil
+	}
+	return nil, fmt.Errorf("configmap %v/%v has no key %v", configMap.Namespace, configMap.Name, key)
+}
+
+// readTemplate reads the template of the config. A missing template is
+// reported with a metric and a Warning event since the config is no longer
+// kept up to date. With UseCachedTemplateOnError the last successfully read
+// template is returned in place of any read error.
+func (c *Controller) readTemplate(o Options, config webhookConfig) ([]byte, error) {
+	webhook, err := c.readCachedFile(o, config.path)
+	if err == nil {
+		if o.UseCachedTemplateOnError {
+			c.lastTemplateMu.Lock()
+			c.lastTemplate[config.path] = webhook
+			c.lastTemplateMu.Unlock()
 		}
-		return false, err
+		return webhook, nil
 	}
-	ready, _ = isEndpointReady(endpoint)
-	return ready, nil
+	if os.IsNotExist(err) {
+		scope.Warnf("Template %v of validatingwebhookconfiguration %v is missing", config.path, config.name)
+		c.metrics.ReportValidationTemplateMissing(config.name)
+		c.recordConfigEvent(config.name, kubeApiCore.EventTypeWarning, eventReasonTemplateMissing,
+			"Template %v is missing", config.path)
+	}
+	if !o.UseCachedTemplateOnError {
+		return nil, err
+	}
+	c.lastTemplateMu.Lock()
+	cached, ok := c.lastTemplate[config.path]
+	c.lastTemplateMu.Unlock()
+	if !ok {
+		return nil, err
+	}
+	scope.Warnf("Using the last loaded template of validatingwebhookconfiguration %v: %v", config.name, err)
+	return cached, nil
 }
 
-func isEndpointReady(endpoint *kubeApiCore.Endpoints) (ready bool, reason string) {
-	if len(endpoint.Subsets) == 0 {
-		return false, "no subsets"
+// readCachedFile reads the file through the cache when CacheDesiredConfig is enabled.
+func (c *Controller) readCachedFile(o Options, path string) ([]byte, error) {
+	if !o.CacheDesiredConfig {
+		return c.readFile(path)
 	}
-	for _, subset := range endpoint.Subsets {
-		if len(subset.Addresses) > 0 {
-			return true, ""
-		}
+	if contents, ok := c.cache.getFile(path); ok {
+		return contents, nil
+	}
+	contents, err := c.readFile(path)
+	if err != nil {
+		return nil, err
 	}
-	return false, "no subset addresses ready"
+	c.cache.putFile(path, contents)
+	return contents, nil
 }
 
-func (c *Controller) isGalleyDeploymentRunning() (running bool, err error) {
-	galley, err := c.sharedInformers.Apps().V1().
-		Deployments().Lister().Deployments(c.o.WatchedNamespace).Get(c.o.GalleyDeploymentName)
+// BuildValidatingWebhookConfiguration returns the validatingwebhookconfiguration
+// the controller would write with the default options, without a client.
+// The webhook template is decoded and checked, the caBundle is verified and
+// stamped onto every webhook, and the ownerRefs are set. It is intended for
+// previewing and testing webhook templates.
+func BuildValidatingWebhookConfiguration(
+	caBundle, webhook []byte,
+	ownerRefs []kubeApiMeta.OwnerReference,
+) (*kubeApiAdmission.ValidatingWebhookConfiguration, error) {
+	return buildValidatingWebhookConfiguration(Options{}, caBundle, nil, webhook, ownerRefs)
+}
 
-	// galley does/doesn't exist
-	if err != nil {
-		if kubeErrors.IsNotFound(err) {
-			return false, nil
+func buildValidatingWebhookConfiguration(
+	o Options,
+	caBundle []byte,
+	webhookCABundles map[string][]byte,
+	webhook []byte,
+	ownerRefs []kubeApiMeta.OwnerReference,
+) (*kubeApiAdmission.ValidatingWebhookConfiguration, error) {
+	config, errs := buildAndValidateConfig(o, caBundle, webhookCABundles, webhook, ownerRefs, true)
+	if len(errs) > 0 {
+		return nil, errs[0]
+	}
+	return config, nil
+}
+
+// buildAndValidateConfig decodes the template, stamps the runtime fields and
+// runs the config checks. Webhooks listed in webhookCABundles get their own
+// bundle instead of caBundle. If failFast is false all checks are run and
+// every error found is returned.
+func buildAndValidateConfig(
+	o Options,
+	caBundle []byte,
+	webhookCABundles map[string][]byte,
+	webhook []byte,
+	ownerRefs []kubeApiMeta.OwnerReference,
+	failFast bool,
+) (*kubeApiAdmission.ValidatingWebhookConfiguration, []*configError) {
+	if o.RenderTemplate {
+		rendered, err := renderTemplate(o, webhook)
+		if err != nil {
+			return nil, []*configError{{err, "could not render validatingwebhookconfiguration template"}}
 		}
-		return false, err
+		webhook = rendered
+	}
+	config, err := decodeValidatingConfig(o.Codec, webhook)
+	if err != nil {
+		return nil, []*configError{{err, "could not decode validatingwebhookconfiguration file"}}
 	}
+	return stampAndCheckConfig(o, config, caBundle, webhookCABundles, ownerRefs, failFast)
+}
 
-	// galley is scaled down to zero replicas. This is useful for debugging
-	// to force the istiod controller to run.
-	if galley.Spec.Replicas == nil || *galley.Spec.Replicas == 0 {
-		return false, nil
+// stampAndCheckConfig stamps the runtime fields of the decoded config and
+// runs the config checks, as described by buildAndValidateConfig.
+func stampAndCheckConfig(
+	o Options,
+	config *kubeApiAdmission.ValidatingWebhookConfiguration,
+	caBundle []byte,
+	webhookCABundles map[string][]byte,
+	ownerRefs []kubeApiMeta.OwnerReference,
+	failFast bool,
+) (*kubeApiAdmission.ValidatingWebhookConfiguration, []*configError) {
+	var errs []*configError
+	if err := o.caBundleVerifier()(caBundle); err != nil {
+		errs = append(errs, &configError{err, caBundleErrorReason(err)})
+		if failFast {
+			return nil, errs
+		}
+	}
+	for _, name := range sortedKeys(webhookCABundles) {
+		if err := o.caBundleVerifier()(webhookCABundles[name]); err != nil {
+			errs = append(errs, &configError{fmt.Errorf("webhook %v: %v", name, err), caBundleErrorReason(err)})
+			if failFast {
+				return nil, errs
+			}
+		}
+	}
+	// update runtime fields
+	config.OwnerReferences = ownerRefs
+	for k, v := range o.ManagedLabels {
+		if config.Labels == nil {
+			config.Labels = make(map[string]string)
+		}
+		config.Labels[k] = v
+	}
+	if o.Revision != "" || o.ManagedByLabelValue != "" {
+		if config.Labels == nil {
+			config.Labels = make(map[string]string)
+		}
+		config.Labels[managedByLabel] = o.managedBy()
+	}
+	if o.Revision != "" {
+		config.Labels[revisionLabel] = o.Revision
+	}
+	for i := range config.Webhooks {
+		if o.FailurePolicyOverride != nil {
+			failurePolicy := *o.FailurePolicyOverride
+			config.Webhooks[i].FailurePolicy = &failurePolicy
+		}
+		if o.ObjectSelector != nil {
+			config.Webhooks[i].ObjectSelector = o.ObjectSelector.DeepCopy()
+		}
+		if o.WebhookTimeoutSeconds != nil {
+			timeout := *o.WebhookTimeoutSeconds
+			config.Webhooks[i].TimeoutSeconds = &timeout
+		}
+		if containsName(o.SkipCAInjectionWebhooks, config.Webhooks[i].Name) {
+			continue
+		}
+		if bundle, ok := webhookCABundles[config.Webhooks[i].Name]; ok {
+			config.Webhooks[i].ClientConfig.CABundle = bundle
+			continue
+		}
+		config.Webhooks[i].ClientConfig.CABundle = caBundle
 	}
 
-	return true, nil
+	for _, check := range configChecks {
+		if err := check(o, config); err != nil {
+			errs = append(errs, err)
+			if failFast {
+				return nil, errs
+			}
+		}
+	}
+	return config, errs
 }
 
-func (c *Controller) deleteValidatingWebhookConfiguration() error {
-	err := c.o.Client.AdmissionregistrationV1beta1().
-		ValidatingWebhookConfigurations().Delete(c.o.WebhookConfigName, &kubeApiMeta.DeleteOptions{})
+// renderTemplate renders the webhook config file as a text/template with
+// the Options as data, e.g. {{ .ServiceName }} or {{ .WatchedNamespace }}.
+func renderTemplate(o Options, webhook []byte) ([]byte, error) {
+	tmpl, err := template.New("webhook").Option("missingkey=error").Parse(string(webhook))
 	if err != nil {
-		scope.Errorf("Failed to delete validatingwebhookconfiguration: %v", err)
-		reportValidationConfigDeleteError(kubeErrors.ReasonForError(err))
-		return err
+		return nil, err
 	}
-	scope.Info("Successfully deleted validatingwebhookconfiguration")
-	return nil
+	var rendered bytes.Buffer
+	if err := tmpl.Execute(&rendered, o); err != nil {
+		return nil, err
+	}
+	return rendered.Bytes(), nil
 }
 
-func (c *Controller) updateValidatingWebhookConfiguration(desired *kubeApiAdmission.ValidatingWebhookConfiguration) error {
-	current, err := c.sharedInformers.Admissionregistration().V1beta1().
-		ValidatingWebhookConfigurations().Lister().Get(c.o.WebhookConfigName)
+// configCheck validates a config after its runtime fields are stamped.
+// Checks may also normalize the config in place.
+type configCheck func(o Options, config *kubeApiAdmission.ValidatingWebhookConfiguration) *configError
+
+var configChecks = []configCheck{
+	checkWebhooksPresent,
+	checkDuplicateWebhooks,
+	checkCABundlePresent,
+	checkTimeoutSeconds,
+	checkManagedNamespaces,
+	checkFailurePolicySideEffects,
+	checkServiceMatch,
+}
 
-	if kubeErrors.IsNotFound(err) {
-		_, err := c.o.Client.AdmissionregistrationV1beta1().
-			ValidatingWebhookConfigurations().Create(desired)
-		if err != nil {
-			scope.Errorf("Failed to create validatingwebhookconfiguration: %v", err)
-			reportValidationConfigUpdateError(kubeErrors.ReasonForError(err))
-			return err
-		}
-		scope.Info("Successfully created validatingwebhookconfiguration")
-		reportValidationConfigUpdate()
+func checkWebhooksPresent(o Options, config *kubeApiAdmission.ValidatingWebhookConfiguration) *configError {
+	if len(config.Webhooks) > 0 || o.AllowEmptyWebhooks {
 		return nil
 	}
+	return &configError{fmt.Errorf("validatingwebhookconfiguration %v has no webhooks", config.Name), "no webhooks in config"}
+}
 
-	updated := current.DeepCopyObject().(*kubeApiAdmission.ValidatingWebhookConfiguration)
-	updated.Webhooks = desired.Webhooks
-	updated.OwnerReferences = desired.OwnerReferences
-
-	if !reflect.DeepEqual(updated, current) {
-		_, err := c.o.Client.AdmissionregistrationV1beta1().
-			ValidatingWebhookConfigurations().Update(updated)
-		if err != nil {
-			scope.Errorf("Failed to update validatingwebhookconfiguration: %v", err)
-			reportValidationConfigUpdateError(kubeErrors.ReasonForError(err))
-			return err
+func checkCABundlePresent(o Options, config *kubeApiAdmission.ValidatingWebhookConfiguration) *configError {
+	if !o.RequireCABundle {
+		return nil
+	}
+	var missing []string
+	for _, webhook := range config.Webhooks {
+		if webhook.ClientConfig.Service != nil && len(webhook.ClientConfig.CABundle) == 0 {
+			missing = append(missing, webhook.Name)
 		}
 	}
-	scope.Info("Successfully updated validatingwebhookconfiguration")
-	reportValidationConfigUpdate()
+	if len(missing) > 0 {
+		return &configError{fmt.Errorf("webhooks %q have no caBundle", missing), "missing caBundle"}
+	}
 	return nil
 }
 
-type configError struct {
-	err    error
-	reason string
+// bounds of webhook timeoutSeconds accepted by the kube-apiserver.
+const (
+	minTimeoutSeconds = 1
+	maxTimeoutSeconds = 30
+)
+
+func checkTimeoutSeconds(_ Options, config *kubeApiAdmission.ValidatingWebhookConfiguration) *configError {
+	for _, webhook := range config.Webhooks {
+		if timeout := webhook.TimeoutSeconds; timeout != nil && (*timeout < minTimeoutSeconds || *timeout > maxTimeoutSeconds) {
+			return &configError{
+				fmt.Errorf("webhook %q timeoutSeconds %v is not between %v and %v",
+					webhook.Name, *timeout, minTimeoutSeconds, maxTimeoutSeconds),
+				"invalid timeoutSeconds",
+			}
+		}
+	}
+	return nil
 }
 
-func (e configError) Error() string {
-	return e.err.Error()
+// namespaceNameLabel is the label of each namespace with its name.
+const namespaceNameLabel = "kubernetes.io/metadata.name"
+
+func checkManagedNamespaces(o Options, config *kubeApiAdmission.ValidatingWebhookConfiguration) *configError {
+	if len(o.ManagedNamespaces) == 0 {
+		return nil
+	}
+	var outOfScope []string
+	for _, webhook := range config.Webhooks {
+		if !selectsOnlyNamespaces(webhook.NamespaceSelector, o.ManagedNamespaces) {
+			outOfScope = append(outOfScope, webhook.Name)
+		}
+	}
+	if len(outOfScope) == 0 {
+		return nil
+	}
+	return &configError{
+		fmt.Errorf("the namespaceSelector of webhooks %q may match namespaces other than %v", outOfScope, o.ManagedNamespaces),
+		"namespaceSelector not limited to managed namespaces",
+	}
 }
 
-func (e configError) Reason() string {
-	return e.reason
+// selectsOnlyNamespaces returns true if the selector only matches namespaces
+// with one of the names. The requirements of a selector are ANDed so one
+// requirement on the namespace name limits the selector.
+func selectsOnlyNamespaces(selector *kubeApiMeta.LabelSelector, names []string) bool {
+	if selector == nil {
+		return false
+	}
+	if name, ok := selector.MatchLabels[namespaceNameLabel]; ok && containsName(names, name) {
+		return true
+	}
+	for _, requirement := range selector.MatchExpressions {
+		if requirement.Key != namespaceNameLabel || requirement.Operator != kubeApiMeta.LabelSelectorOpIn ||
+			len(requirement.Values) == 0 {
+			continue
+		}
+		limited := true
+		for _, value := range requirement.Values {
+			if !containsName(names, value) {
+				limited = false
+				break
+			}
+		}
+		if limited {
+			return true
+		}
+	}
+	return false
 }
 
-func (c *Controller) buildValidatingWebhookConfiguration() (*kubeApiAdmission.ValidatingWebhookConfiguration, error) {
-	webhook, err := c.readFile(c.o.WebhookConfigPath)
-	if err != nil {
-		return nil, &configError{err, "could not read validatingwebhookconfiguration file"}
+// checkFailurePolicySideEffects warns about webhooks which fail closed but
+// don't declare their side effects. The kube-apiserver rejects all dry-run
+// requests matching such webhooks.
+func checkFailurePolicySideEffects(o Options, config *kubeApiAdmission.ValidatingWebhookConfiguration) *configError {
+	var risky []string
+	for _, webhook := range config.Webhooks {
+		if webhook.FailurePolicy == nil || *webhook.FailurePolicy != kubeApiAdmission.Fail || webhook.SideEffects == nil {
+			continue
+		}
+		if sideEffects := *webhook.SideEffects; sideEffects == kubeApiAdmission.SideEffectClassUnknown ||
+			sideEffects == kubeApiAdmission.SideEffectClassSome {
+			risky = append(risky, webhook.Name)
+		}
 	}
-	caBundle, err := c.readFile(c.o.CAPath)
-	if err != nil {
-		return nil, &configError{err, "could not read caBundle file"}
+	if len(risky) == 0 {
+		return nil
+	}
+	err := fmt.Errorf("webhooks %q fail closed with sideEffects Unknown or Some which rejects dry-run requests; "+
+		"consider sideEffects None or NoneOnDryRun", risky)
+	if o.StrictSideEffects {
+		return &configError{err, "risky failurePolicy and sideEffects"}
 	}
-	return buildValidatingWebhookConfiguration(caBundle, webhook, c.ownerRefs)
+	if o.sideEffectsWarned {
+		return nil
+	}
+	scope.Warnf("validatingwebhookconfiguration %v: %v", config.Name, err)
+	o.metricsReporter().ReportRiskySideEffects(config.Name)
+	return nil
 }
 
-func buildValidatingWebhookConfiguration(
-	caBundle, webhook []byte,
-	ownerRefs []kubeApiMeta.OwnerReference,
-) (*kubeApiAdmission.ValidatingWebhookConfiguration, error) {
-	config, err := decodeValidatingConfig(webhook)
-	if err != nil {
-		return nil, &configError{err, "could not decode validatingwebhookconfiguration file"}
+func checkServiceMatch(o Options, config *kubeApiAdmission.ValidatingWebhookConfiguration) *configError {
+	if o.ServiceName == "" {
+		return nil
 	}
-	if err := verifyCABundle(caBundle); err != nil {
-		return nil, &configError{err, "could not verify caBundle"}
+	var mismatched []string
+	for _, webhook := range config.Webhooks {
+		service := webhook.ClientConfig.Service
+		if service == nil {
+			continue
+		}
+		if service.Name != o.ServiceName || service.Namespace != o.serviceNamespace() {
+			mismatched = append(mismatched, fmt.Sprintf("%v (%v/%v)", webhook.Name, service.Namespace, service.Name))
+		}
 	}
-	// update runtime fields
-	config.OwnerReferences = ownerRefs
-	for i := range config.Webhooks {
-		config.Webhooks[i].ClientConfig.CABundle = caBundle
+	if len(mismatched) == 0 {
+		return nil
 	}
+	err := fmt.Errorf("webhooks %v don't call the webhook service %v/%v", mismatched, o.serviceNamespace(), o.ServiceName)
+	if o.StrictServiceCheck {
+		return &configError{err, "service mismatch"}
+	}
+	scope.Warnf("validatingwebhookconfiguration %v: %v", config.Name, err)
+	return nil
+}
 
-	return config, nil
+func checkDuplicateWebhooks(o Options, config *kubeApiAdmission.ValidatingWebhookConfiguration) *configError {
+	if err := dedupWebhooks(config, o.DedupWebhooks); err != nil {
+		return &configError{err, "duplicate webhook names"}
+	}
+	return nil
+}
+
+// dedupWebhooks returns an error naming the first webhook whose name is
+// reused within the config. If drop is true the duplicates are removed
+// from the config instead.
+func dedupWebhooks(config *kubeApiAdmission.ValidatingWebhookConfiguration, drop bool) error {
+	seen := make(map[string]bool, len(config.Webhooks))
+	webhooks := config.Webhooks[:0]
+	for _, webhook := range config.Webhooks {
+		if seen[webhook.Name] {
+			if !drop {
+				return fmt.Errorf("duplicate webhook name %q in validatingwebhookconfiguration %v",
+					webhook.Name, config.Name)
+			}
+			scope.Warnf("Dropping duplicate webhook %q from validatingwebhookconfiguration %v",
+				webhook.Name, config.Name)
+			continue
+		}
+		seen[webhook.Name] = true
+		webhooks = append(webhooks, webhook)
+	}
+	config.Webhooks = webhooks
+	return nil
 }
 
 var (
@@ -527,22 +3699,40 @@ var (
 )
 
 func init() {
-	scheme = runtime.NewScheme()
+	codec, scheme = NewCodec()
+}
+
+// NewCodec returns a YAML codec for admissionregistration v1beta1 and the
+// scheme backing it. The addToScheme funcs register further types,
+// conversions or defaulting funcs with the scheme, e.g. with
+// runtime.Scheme.AddTypeDefaultingFunc. NewCodec panics if any of them
+// fails.
+func NewCodec(addToScheme ...func(*runtime.Scheme) error) (runtime.Codec, *runtime.Scheme) {
+	scheme := runtime.NewScheme()
 	utilruntime.Must(kubeApiAdmission.AddToScheme(scheme))
-	opt := json.SerializerOptions{true, false, false}
+	for _, add := range addToScheme {
+		utilruntime.Must(add(scheme))
+	}
+	opt := json.SerializerOptions{Yaml: true, Pretty: false, Strict: false}
 	yamlSerializer := json.NewSerializerWithOptions(json.DefaultMetaFactory, scheme, scheme, opt)
-	codec = versioning.NewDefaultingCodecForScheme(
+	codec := versioning.NewDefaultingCodecForScheme(
 		scheme,
 		yamlSerializer,
 		yamlSerializer,
 		kubeApiAdmission.SchemeGroupVersion,
 		runtime.InternalGroupVersioner,
 	)
+	return codec, scheme
 }
 
-func decodeValidatingConfig(encoded []byte) (*kubeApiAdmission.ValidatingWebhookConfiguration, error) {
+// decodeValidatingConfig decodes the encoded config with the decoder, or
+// with the codec of this package if decoder is nil.
+func decodeValidatingConfig(decoder runtime.Decoder, encoded []byte) (*kubeApiAdmission.ValidatingWebhookConfiguration, error) {
+	if decoder == nil {
+		decoder = codec
+	}
 	var config kubeApiAdmission.ValidatingWebhookConfiguration
-	if _, _, err := codec.Decode(encoded, nil, &config); err != nil {
+	if _, _, err := decoder.Decode(encoded, nil, &config); err != nil {
 		return nil, err
 	}
 
@@ -562,16 +3752,78 @@ func decodeValidatingConfig(encoded []byte) (*kubeApiAdmission.ValidatingWebhook
 	return &config, nil
 }
 
+// errCABundleBase64 is returned for a caBundle which is base64-encoded PEM,
+// which the kube-apiserver would otherwise encode again.
+var errCABundleBase64 = errors.New("caBundle appears to be base64-encoded PEM; supply raw PEM")
+
+// caBundleErrorReason returns the reason of the configError of an error
+// verifying a caBundle.
+func caBundleErrorReason(err error) string {
+	if err == errCABundleBase64 {
+		return err.Error()
+	}
+	return "could not verify caBundle"
+}
+
+// isBase64PEM returns true if data decodes as base64, ignoring whitespace,
+// to PEM.
+func isBase64PEM(data []byte) bool {
+	decoded, err := base64.StdEncoding.DecodeString(strings.Join(strings.Fields(string(data)), ""))
+	if err != nil {
+		return false
+	}
+	block, _ := pem.Decode(decoded)
+	return block != nil
+}
+
+// verifyCABundle verifies every PEM block in the caBundle is a valid x509
+// certificate. All malformed blocks are reported.
 func verifyCABundle(caBundle []byte) error {
-	block, _ := pem.Decode(caBundle)
+	block, rest := pem.Decode(caBundle)
 	if block == nil {
+		if isBase64PEM(caBundle) {
+			return errCABundleBase64
+		}
 		return errors.New("could not decode pem")
 	}
-	if block.Type != "CERTIFICATE" {
-		return fmt.Errorf("cert contains wrong pem type: %q", block.Type)
+	var errs *multierror.Error
+	for i := 0; block != nil; i++ {
+		if block.Type != "CERTIFICATE" {
+			errs = multierror.Append(errs, fmt.Errorf("cert %v contains wrong pem type: %q", i, block.Type))
+		} else if _, err := x509.ParseCertificate(block.Bytes); err != nil {
+			errs = multierror.Append(errs, fmt.Errorf("cert %v contains invalid x509 certificate: %v", i, err))
+		}
+		block, rest = pem.Decode(rest)
+	}
+	return errs.ErrorOrNil()
+}
+
+func (c *Controller) verifyCABundleValidity(o Options, caBundle []byte) error {
+	if err := verifyCABundleValidity(caBundle, c.clock.Now(), o.CertValiditySkew); err != nil {
+		c.metrics.ReportCABundleValidityError(err.Reason())
+		return err
 	}
-	if _, err := x509.ParseCertificate(block.Bytes); err != nil {
-		return fmt.Errorf("cert contains invalid x509 certificate: %v", err)
+	return nil
+}
+
+// verifyCABundleValidity verifies every certificate in the caBundle is
+// within its validity window at now, allowing for the given clock skew.
+// Blocks which aren't valid certificates are left to verifyCABundle.
+func verifyCABundleValidity(caBundle []byte, now time.Time, skew time.Duration) *configError {
+	for block, rest := pem.Decode(caBundle); block != nil; block, rest = pem.Decode(rest) {
+		if block.Type != "CERTIFICATE" {
+			continue
+		}
+		cert, err := x509.ParseCertificate(block.Bytes)
+		if err != nil {
+			continue
+		}
+		if now.Add(skew).Before(cert.NotBefore) {
+			return &configError{fmt.Errorf("cert %q is not valid until %v", cert.Subject, cert.NotBefore), "caBundle not yet valid"}
+		}
+		if now.Add(-skew).After(cert.NotAfter) {
+			return &configError{fmt.Errorf("cert %q expired at %v", cert.Subject, cert.NotAfter), "caBundle expired"}
+		}
 	}
 	return nil
 }
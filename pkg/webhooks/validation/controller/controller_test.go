@@ -15,27 +15,57 @@
 package controller
 
 import (
+	"bytes"
+	"context"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/pem"
+	"errors"
 	"fmt"
+	"io/ioutil"
+	"math/rand"
+	"net/http"
+	"net/http/httptest"
 	"os"
+	"path/filepath"
+	"reflect"
+	goruntime "runtime"
 	"sync"
+	"sync/atomic"
 	"testing"
 	"time"
 
+	"github.com/fsnotify/fsnotify"
 	. "github.com/onsi/gomega"
 	kubeApiAdmission "k8s.io/api/admissionregistration/v1beta1"
 	kubeApiApp "k8s.io/api/apps/v1"
 	kubeApiCore "k8s.io/api/core/v1"
+	kubeApiRbac "k8s.io/api/rbac/v1"
+	kubeApiExtensions "k8s.io/apiextensions-apiserver/pkg/apis/apiextensions/v1beta1"
+	apiextensionsfake "k8s.io/apiextensions-apiserver/pkg/client/clientset/clientset/fake"
 	kubeErrors "k8s.io/apimachinery/pkg/api/errors"
 	kubeApiMeta "k8s.io/apimachinery/pkg/apis/meta/v1"
 	kubeApisMeta "k8s.io/apimachinery/pkg/apis/meta/v1"
+	kubeLabels "k8s.io/apimachinery/pkg/labels"
 	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/apimachinery/pkg/util/clock"
+	"k8s.io/apimachinery/pkg/util/validation/field"
+	"k8s.io/client-go/informers"
+	"k8s.io/client-go/kubernetes"
 	"k8s.io/client-go/kubernetes/fake"
 	kubeTypedAdmission "k8s.io/client-go/kubernetes/typed/admissionregistration/v1beta1"
 	kubeTypedApp "k8s.io/client-go/kubernetes/typed/apps/v1"
 	kubeTypedCore "k8s.io/client-go/kubernetes/typed/core/v1"
+	"k8s.io/client-go/rest"
+	k8stesting "k8s.io/client-go/testing"
 	"k8s.io/client-go/tools/cache"
+	"k8s.io/client-go/tools/record"
+	"k8s.io/client-go/util/workqueue"
 
 	"istio.io/pkg/filewatcher"
+	"istio.io/pkg/log"
 
 	"istio.io/istio/pkg/mcp/testing/testcerts"
 )
@@ -61,6 +91,11 @@ var (
 		Spec: kubeApiApp.DeploymentSpec{
 			Replicas: &[]int32{1}[0],
 		},
+		Status: kubeApiApp.DeploymentStatus{
+			Replicas:          1,
+			ReadyReplicas:     1,
+			AvailableReplicas: 1,
+		},
 	}
 
 	unpatchedIstiodWebhookConfig = &kubeApiAdmission.ValidatingWebhookConfiguration{
@@ -114,6 +149,9 @@ var (
 	webhookConfigWithCABundle0       *kubeApiAdmission.ValidatingWebhookConfiguration
 	galleyWebhookConfigWithCABundle1 *kubeApiAdmission.ValidatingWebhookConfiguration
 
+	// time within the validity window of both caBundle0 and caBundle1.
+	testNow = time.Date(2020, 1, 1, 0, 0, 0, 0, time.UTC)
+
 	caBundle0 = []byte(`-----BEGIN CERTIFICATE-----
 MIIC9DCCAdygAwIBAgIJAIFe3lWPaalKMA0GCSqGSIb3DQEBCwUAMA4xDDAKBgNV
 BAMMA19jYTAgFw0xNzEyMjIxODA0MjRaGA8yMjkxMTAwNzE4MDQyNFowDjEMMAoG
@@ -183,10 +221,13 @@ type fakeController struct {
 	injectedMu       sync.Mutex
 	injectedCABundle []byte
 	injectedConfig   []byte
+	injectedFiles    map[string][]byte
+	readFileCount    map[string]int
 
 	fakeWatcher *filewatcher.FakeWatcher
 	*fake.Clientset
 	reconcileDoneCh chan struct{}
+	recorder        *record.FakeRecorder
 }
 
 const (
@@ -200,7 +241,55 @@ const (
 	istiodClusterRole    = "istiod-istio-system"
 )
 
-func createTestController(t *testing.T) *fakeController {
+// fakeKubeClient sends the requests as actions of the fake clientset, which
+// has no REST client. Like the typed fake clients, it only checks ctx
+// before a request.
+type fakeKubeClient struct {
+	fake interface {
+		Invokes(action k8stesting.Action, defaultReturnObj runtime.Object) (runtime.Object, error)
+	}
+}
+
+func newFakeKubeClient(client kubernetes.Interface) kubeClient {
+	return fakeKubeClient{client.(*fake.Clientset)}
+}
+
+func (f fakeKubeClient) invoke(ctx context.Context, action k8stesting.Action, into runtime.Object) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	obj, err := f.fake.Invokes(action, into)
+	if err != nil {
+		return err
+	}
+	if obj != nil && into != nil && obj != into {
+		reflect.ValueOf(into).Elem().Set(reflect.ValueOf(obj).Elem())
+	}
+	return nil
+}
+
+func (f fakeKubeClient) get(ctx context.Context, resource schema.GroupVersionResource, name string, into runtime.Object) error {
+	return f.invoke(ctx, k8stesting.NewRootGetAction(resource, name), into)
+}
+
+func (f fakeKubeClient) create(ctx context.Context, resource schema.GroupVersionResource, obj, into runtime.Object) error {
+	return f.invoke(ctx, k8stesting.NewRootCreateAction(resource, obj), into)
+}
+
+func (f fakeKubeClient) update(
+	ctx context.Context,
+	resource schema.GroupVersionResource,
+	_ string,
+	obj, into runtime.Object,
+) error {
+	return f.invoke(ctx, k8stesting.NewRootUpdateAction(resource, obj), into)
+}
+
+func (f fakeKubeClient) delete(ctx context.Context, resource schema.GroupVersionResource, name string) error {
+	return f.invoke(ctx, k8stesting.NewRootDeleteAction(resource, name), nil)
+}
+
+func createTestController(t *testing.T, opts ...func(*Options)) *fakeController {
 	fakeClient := fake.NewSimpleClientset()
 	o := Options{
 		WatchedNamespace:     namespace,
@@ -213,6 +302,13 @@ func createTestController(t *testing.T) *fakeController {
 		GalleyDeploymentName: galleyDeploymentName,
 		ClusterRoleName:      istiodClusterRole,
 	}
+	for _, opt := range opts {
+		opt(&o)
+	}
+	// replicas may share a client.
+	if client, ok := o.Client.(*fake.Clientset); ok {
+		fakeClient = client
+	}
 
 	caChanged := make(chan bool, 10)
 	configChanged := make(chan bool, 10)
@@ -232,6 +328,7 @@ func createTestController(t *testing.T) *fakeController {
 		configChangedCh:  configChanged,
 		injectedCABundle: caBundle0,
 		injectedConfig:   []byte(istiodWebhookConfigEncoded),
+		readFileCount:    make(map[string]int),
 		fakeWatcher:      fakeWatcher,
 		Clientset:        fakeClient,
 		reconcileDoneCh:  make(chan struct{}, 100),
@@ -241,12 +338,16 @@ func createTestController(t *testing.T) *fakeController {
 		fc.injectedMu.Lock()
 		defer fc.injectedMu.Unlock()
 
+		fc.readFileCount[filename]++
 		switch filename {
 		case o.CAPath:
 			return fc.injectedCABundle, nil
 		case o.WebhookConfigPath:
 			return fc.injectedConfig, nil
 		}
+		if contents, ok := fc.injectedFiles[filename]; ok {
+			return contents, nil
+		}
 		return nil, os.ErrNotExist
 	}
 
@@ -259,14 +360,19 @@ func createTestController(t *testing.T) *fakeController {
 	}
 
 	var err error
-	fc.Controller, err = newController(o, newFileWatcher, readFile, reconcileDone)
+	fc.Controller, err = newController(o, newFileWatcher, readFile, newSharedInformerFactory, newFakeKubeClient, reconcileDone)
 	if err != nil {
 		t.Fatalf("failed to create test controller: %v", err)
 	}
+	fc.Controller.clock = clock.NewFakeClock(testNow)
+	fc.Controller.eventBroadcaster.Shutdown()
+	fc.Controller.eventBroadcaster = nil
+	fc.recorder = record.NewFakeRecorder(1000)
+	fc.Controller.recorder = fc.recorder
 
 	si := fc.Controller.sharedInformers
-	fc.endpointStore = si.Core().V1().Endpoints().Informer().GetStore()
-	fc.deploymentStore = si.Apps().V1().Deployments().Informer().GetStore()
+	fc.endpointStore = fc.Controller.informersFor(o, o.serviceNamespace()).Core().V1().Endpoints().Informer().GetStore()
+	fc.deploymentStore = fc.Controller.informersFor(o, o.galleyNamespace()).Apps().V1().Deployments().Informer().GetStore()
 	fc.configStore = si.Admissionregistration().V1beta1().ValidatingWebhookConfigurations().Informer().GetStore()
 	fc.clusterRoleStore = si.Rbac().V1().ClusterRoles().Informer().GetStore()
 
@@ -277,6 +383,10 @@ func (fc *fakeController) ValidatingWebhookConfigurations() kubeTypedAdmission.V
 	return fc.o.Client.AdmissionregistrationV1beta1().ValidatingWebhookConfigurations()
 }
 
+func (fc *fakeController) MutatingWebhookConfigurations() kubeTypedAdmission.MutatingWebhookConfigurationInterface {
+	return fc.o.Client.AdmissionregistrationV1beta1().MutatingWebhookConfigurations()
+}
+
 func (fc *fakeController) Endpoints() kubeTypedCore.EndpointsInterface {
 	return fc.o.Client.CoreV1().Endpoints(fc.o.WatchedNamespace)
 }
@@ -289,7 +399,7 @@ func reconcileHelper(t *testing.T, c *fakeController) {
 	t.Helper()
 
 	c.ClearActions()
-	c.reconcileRequest(&reconcileRequest{"test"})
+	c.reconcileRequest(context.Background(), c.options(), &reconcileRequest{description: "test"})
 }
 
 func TestGreenfield(t *testing.T) {
@@ -310,6 +420,35 @@ func TestGreenfield(t *testing.T) {
 		Should(Equal(webhookConfigWithCABundle0), "istiod config created when endpoint is ready")
 }
 
+func TestDeferToGalleyDisabled(t *testing.T) {
+	g := NewGomegaWithT(t)
+	deferToGalley := false
+	o := Options{
+		WatchedNamespace:     namespace,
+		CAPath:               caPath,
+		WebhookConfigName:    galleyWebhookName,
+		WebhookConfigPath:    configPath,
+		ServiceName:          istiod,
+		GalleyDeploymentName: galleyDeploymentName,
+		DeferToGalley:        &deferToGalley,
+	}
+	g.Expect(o.Validate()).Should(Succeed())
+
+	c := createTestController(t, func(o *Options) {
+		o.DeferToGalley = &deferToGalley
+	})
+	c.deploymentStore.Add(galleyDeployment)
+	c.configStore.Add(galleyWebhookConfigWithCABundle1)
+	c.endpointStore.Add(istiodEndpoint)
+	_, err := c.ValidatingWebhookConfigurations().Create(galleyWebhookConfigWithCABundle1)
+	g.Expect(err).Should(Succeed())
+
+	reconcileHelper(t, c)
+	g.Expect(c.Actions()[0].Matches("update", "validatingwebhookconfigurations")).Should(BeTrue())
+	g.Expect(c.ValidatingWebhookConfigurations().Get(galleyWebhookName, kubeApisMeta.GetOptions{})).
+		Should(Equal(webhookConfigWithCABundle0), "istiod webhook should be installed despite the running galley")
+}
+
 func TestUpgradeDowngrade(t *testing.T) {
 	g := NewGomegaWithT(t)
 	c := createTestController(t)
@@ -354,6 +493,75 @@ func TestUpgradeDowngrade(t *testing.T) {
 		Should(Equal(webhookConfigWithCABundle0), "istiod webhook should exist when galley with zero replicas is removed")
 }
 
+func TestGalleyDeploymentHealth(t *testing.T) {
+	scaledToZero := galleyDeployment.DeepCopy()
+	scaledToZero.Spec.Replicas = &[]int32{0}[0]
+	scaledToZero.Status = kubeApiApp.DeploymentStatus{}
+	stuck := galleyDeployment.DeepCopy()
+	stuck.Status = kubeApiApp.DeploymentStatus{Replicas: 1, UnavailableReplicas: 1}
+
+	cases := []struct {
+		name       string
+		deployment *kubeApiApp.Deployment
+		// whether reconcile defers to galley before and after the grace period.
+		deferBefore bool
+		deferAfter  bool
+	}{
+		{"healthy", galleyDeployment, true, true},
+		{"scaled to zero", scaledToZero, false, false},
+		{"stuck rollout", stuck, true, false},
+	}
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			g := NewGomegaWithT(t)
+			c := createTestController(t, func(o *Options) {
+				o.GalleyUnavailableGracePeriod = time.Minute
+			})
+			fakeClock := c.clock.(*clock.FakeClock)
+			c.endpointStore.Add(istiodEndpoint)
+			c.deploymentStore.Add(tc.deployment)
+
+			reconcileHelper(t, c)
+			if tc.deferBefore {
+				g.Expect(c.Actions()).Should(BeEmpty())
+			} else {
+				g.Expect(c.Actions()[0].Matches("create", "validatingwebhookconfigurations")).Should(BeTrue())
+				g.Expect(c.ValidatingWebhookConfigurations().Delete(galleyWebhookName, &kubeApisMeta.DeleteOptions{})).
+					Should(Succeed())
+			}
+
+			fakeClock.Step(time.Minute)
+			reconcileHelper(t, c)
+			if tc.deferAfter {
+				g.Expect(c.Actions()).Should(BeEmpty())
+				return
+			}
+			g.Expect(c.Actions()[0].Matches("create", "validatingwebhookconfigurations")).Should(BeTrue())
+		})
+	}
+
+	// galley recovering before the grace period elapses restarts it.
+	g := NewGomegaWithT(t)
+	c := createTestController(t, func(o *Options) {
+		o.GalleyUnavailableGracePeriod = time.Minute
+	})
+	fakeClock := c.clock.(*clock.FakeClock)
+	c.endpointStore.Add(istiodEndpoint)
+	c.deploymentStore.Add(stuck)
+	reconcileHelper(t, c)
+	fakeClock.Step(30 * time.Second)
+	c.deploymentStore.Update(galleyDeployment)
+	reconcileHelper(t, c)
+	c.deploymentStore.Update(stuck)
+	fakeClock.Step(30 * time.Second)
+	reconcileHelper(t, c)
+	g.Expect(c.Actions()).Should(BeEmpty(), "grace period restarted after galley recovered")
+
+	o := createTestController(t).o
+	o.GalleyUnavailableGracePeriod = -time.Second
+	g.Expect(o.Validate()).ShouldNot(Succeed())
+}
+
 func TestUnregisterValidationWebhook(t *testing.T) {
 	g := NewGomegaWithT(t)
 	c := createTestController(t)
@@ -446,6 +654,21 @@ func TestLoadCaCertPem(t *testing.T) {
 			cert:      testcerts.BadCert,
 			wantError: true,
 		},
+		{
+			name:      "valid chain",
+			cert:      bytes.Join([][]byte{caBundle0, caBundle1}, []byte("\n")),
+			wantError: false,
+		},
+		{
+			name:      "chain with invalid x509",
+			cert:      bytes.Join([][]byte{caBundle0, testcerts.BadCert}, []byte("\n")),
+			wantError: true,
+		},
+		{
+			name:      "chain with non-cert block",
+			cert:      bytes.Join([][]byte{caBundle0, testcerts.ServerKey}, []byte("\n")),
+			wantError: true,
+		},
 	}
 
 	for i, c := range cases {
@@ -463,3 +686,3568 @@ func TestLoadCaCertPem(t *testing.T) {
 		})
 	}
 }
+
+type fakeMetricsReporter struct {
+	mu            sync.Mutex
+	updates       map[string]int
+	updateErrors  map[string][]kubeApiMeta.StatusReason
+	deleteErrors  map[string][]kubeApiMeta.StatusReason
+	loadErrors    map[string][]string
+	certMismatch  int
+	shrinkRefused map[string]int
+	// informer events by kind.
+	informerEvents  map[string]int
+	informerSkipped map[string]int
+	decodeErrors    map[string]int
+	skipped         map[string]int
+	notReady        []string
+	exhausted       int
+	validity        []string
+	expiry          map[string]time.Duration
+	sinceSuccess    map[string]time.Duration
+	templateMissing map[string]int
+	readyTimeouts   int
+	selector        int
+	sideEffects     map[string]int
+
+	mutatingUpdates      map[string]int
+	mutatingUpdateErrors map[string][]kubeApiMeta.StatusReason
+	mutatingDeleteErrors map[string][]kubeApiMeta.StatusReason
+}
+
+func newFakeMetricsReporter() *fakeMetricsReporter {
+	return &fakeMetricsReporter{
+		updates:         make(map[string]int),
+		sideEffects:     make(map[string]int),
+		shrinkRefused:   make(map[string]int),
+		informerEvents:  make(map[string]int),
+		informerSkipped: make(map[string]int),
+		decodeErrors:    make(map[string]int),
+		expiry:          make(map[string]time.Duration),
+		sinceSuccess:    make(map[string]time.Duration),
+		templateMissing: make(map[string]int),
+		skipped:         make(map[string]int),
+
+		mutatingUpdates:      make(map[string]int),
+		mutatingUpdateErrors: make(map[string][]kubeApiMeta.StatusReason),
+		mutatingDeleteErrors: make(map[string][]kubeApiMeta.StatusReason),
+		updateErrors:         make(map[string][]kubeApiMeta.StatusReason),
+		deleteErrors:         make(map[string][]kubeApiMeta.StatusReason),
+		loadErrors:           make(map[string][]string),
+	}
+}
+
+func (r *fakeMetricsReporter) ReportValidationConfigUpdateError(configName string, reason kubeApiMeta.StatusReason) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.updateErrors[configName] = append(r.updateErrors[configName], reason)
+}
+
+func (r *fakeMetricsReporter) ReportValidationConfigDeleteError(configName string, reason kubeApiMeta.StatusReason) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.deleteErrors[configName] = append(r.deleteErrors[configName], reason)
+}
+
+func (r *fakeMetricsReporter) ReportValidationConfigLoadError(configName string, reason string) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.loadErrors[configName] = append(r.loadErrors[configName], reason)
+}
+
+func (r *fakeMetricsReporter) ReportValidationConfigUpdate(configName string) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.updates[configName]++
+}
+
+func (r *fakeMetricsReporter) ReportMutatingConfigUpdateError(configName string, reason kubeApiMeta.StatusReason) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.mutatingUpdateErrors[configName] = append(r.mutatingUpdateErrors[configName], reason)
+}
+
+func (r *fakeMetricsReporter) ReportMutatingConfigDeleteError(configName string, reason kubeApiMeta.StatusReason) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.mutatingDeleteErrors[configName] = append(r.mutatingDeleteErrors[configName], reason)
+}
+
+func (r *fakeMetricsReporter) ReportMutatingConfigUpdate(configName string) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.mutatingUpdates[configName]++
+}
+
+func (r *fakeMetricsReporter) ReportCABundleValidityError(reason string) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.validity = append(r.validity, reason)
+}
+
+func (r *fakeMetricsReporter) ReportValidationConfigSkippedEndpointNotReady(reason string) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.skipped["endpoint not ready"]++
+	r.notReady = append(r.notReady, reason)
+}
+
+func (r *fakeMetricsReporter) ReportValidationConfigSkippedGalleyRunning() {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.skipped["galley running"]++
+}
+
+func (r *fakeMetricsReporter) ReportValidationConfigSkippedPaused(configName string) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.skipped["paused "+configName]++
+}
+
+func (r *fakeMetricsReporter) ReportValidationConfigRetriesExhausted() {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.exhausted++
+}
+
+func (r *fakeMetricsReporter) ReportCABundleExpiry(configName string, untilExpiry time.Duration) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.expiry[configName] = untilExpiry
+}
+
+func (r *fakeMetricsReporter) ReportSecondsSinceLastSuccess(configName string, sinceLastSuccess time.Duration) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.sinceSuccess[configName] = sinceLastSuccess
+}
+
+func (r *fakeMetricsReporter) ReportValidationTemplateMissing(configName string) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.templateMissing[configName]++
+}
+
+func (r *fakeMetricsReporter) ReportEndpointReadyTimeout() {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.readyTimeouts++
+}
+
+func (r *fakeMetricsReporter) ReportServiceSelectorChanged() {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.selector++
+}
+
+func (r *fakeMetricsReporter) ReportRiskySideEffects(configName string) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.sideEffects[configName]++
+}
+
+func (r *fakeMetricsReporter) ReportServingCertMismatch() {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.certMismatch++
+}
+
+func (r *fakeMetricsReporter) ReportInformerDecodeError(gvk schema.GroupVersionKind) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.decodeErrors[gvk.Kind]++
+}
+
+func (r *fakeMetricsReporter) ReportInformerEvent(gvk schema.GroupVersionKind, skipped bool) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.informerEvents[gvk.Kind]++
+	if skipped {
+		r.informerSkipped[gvk.Kind]++
+	}
+}
+
+func (r *fakeMetricsReporter) ReportCABundleShrinkRefused(configName string) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.shrinkRefused[configName]++
+}
+
+func TestMetricsReporter(t *testing.T) {
+	g := NewGomegaWithT(t)
+	c := createTestController(t)
+	reporter := newFakeMetricsReporter()
+	c.metrics = reporter
+
+	c.endpointStore.Add(istiodEndpoint)
+	reconcileHelper(t, c)
+	g.Expect(reporter.updates).Should(Equal(map[string]int{galleyWebhookName: 1}))
+	g.Expect(reporter.loadErrors).Should(BeEmpty())
+
+	c.injectedMu.Lock()
+	c.injectedConfig = []byte("bad configfile")
+	c.injectedMu.Unlock()
+	reconcileHelper(t, c)
+	g.Expect(reporter.updates).Should(Equal(map[string]int{galleyWebhookName: 1}))
+	g.Expect(reporter.loadErrors).Should(Equal(map[string][]string{
+		galleyWebhookName: {"could not decode validatingwebhookconfiguration file"},
+	}))
+}
+
+// updateCountingReporter only counts the webhook config updates.
+type updateCountingReporter struct {
+	NopMetricsReporter
+	updates int
+}
+
+func (r *updateCountingReporter) ReportValidationConfigUpdate(configName string) {
+	r.updates++
+}
+
+func TestNopMetricsReporter(t *testing.T) {
+	g := NewGomegaWithT(t)
+	reporter := &updateCountingReporter{}
+	c := createTestController(t, func(o *Options) {
+		o.MetricsReporter = reporter
+	})
+	c.endpointStore.Add(istiodEndpoint)
+
+	reconcileHelper(t, c)
+	g.Expect(reporter.updates).Should(Equal(1))
+}
+
+func TestMetricsReporterPerConfig(t *testing.T) {
+	g := NewGomegaWithT(t)
+	c := createTestController(t)
+	reporter := newFakeMetricsReporter()
+	c.metrics = reporter
+
+	good := unpatchedIstiodWebhookConfig.DeepCopyObject().(*kubeApiAdmission.ValidatingWebhookConfiguration)
+	good.Name = "config-good"
+	c.injectedFiles = map[string][]byte{
+		"good-path": []byte(runtime.EncodeOrDie(codec, good)),
+		"bad-path":  []byte("bad configfile"),
+	}
+	c.o.WebhookConfigNames = []string{"config-good", "config-bad"}
+	c.o.WebhookConfigPaths = map[string]string{
+		"config-good": "good-path",
+		"config-bad":  "bad-path",
+	}
+
+	c.endpointStore.Add(istiodEndpoint)
+	reconcileHelper(t, c)
+	g.Expect(reporter.updates).Should(Equal(map[string]int{"config-good": 1}))
+	g.Expect(reporter.loadErrors).Should(Equal(map[string][]string{
+		"config-bad": {"could not decode validatingwebhookconfiguration file"},
+	}))
+}
+
+func TestIsEndpointReadyMinReady(t *testing.T) {
+	addresses := func(n int) []kubeApiCore.EndpointAddress {
+		var out []kubeApiCore.EndpointAddress
+		for i := 0; i < n; i++ {
+			out = append(out, kubeApiCore.EndpointAddress{IP: fmt.Sprintf("192.168.1.%v", i+1)})
+		}
+		return out
+	}
+
+	cases := []struct {
+		name       string
+		subsets    []kubeApiCore.EndpointSubset
+		minReady   int
+		wantReady  bool
+		wantReason string
+	}{
+		{
+			name:       "no subsets",
+			minReady:   0,
+			wantReady:  false,
+			wantReason: endpointNoSubsets,
+		},
+		{
+			name:       "no addresses ready",
+			subsets:    []kubeApiCore.EndpointSubset{{NotReadyAddresses: addresses(1)}},
+			minReady:   0,
+			wantReady:  false,
+			wantReason: endpointNoAddressesReady,
+		},
+		{
+			name:      "single address with default minimum",
+			subsets:   []kubeApiCore.EndpointSubset{{Addresses: addresses(1)}},
+			minReady:  0,
+			wantReady: true,
+		},
+		{
+			name:       "single address below minimum",
+			subsets:    []kubeApiCore.EndpointSubset{{Addresses: addresses(1)}},
+			minReady:   2,
+			wantReady:  false,
+			wantReason: endpointTooFewAddressesReady,
+		},
+		{
+			name: "disrupted addresses are not counted",
+			subsets: []kubeApiCore.EndpointSubset{{
+				Addresses:         addresses(1),
+				NotReadyAddresses: addresses(2),
+			}},
+			minReady:   2,
+			wantReady:  false,
+			wantReason: endpointTooFewAddressesReady,
+		},
+		{
+			name: "minimum satisfied across subsets",
+			subsets: []kubeApiCore.EndpointSubset{
+				{Addresses: addresses(1)},
+				{Addresses: addresses(1), NotReadyAddresses: addresses(1)},
+			},
+			minReady:  2,
+			wantReady: true,
+		},
+	}
+
+	for i, c := range cases {
+		t.Run(fmt.Sprintf("[%v] %s", i, c.name), func(tt *testing.T) {
+			endpoint := istiodEndpoint.DeepCopy()
+			endpoint.Subsets = c.subsets
+			ready, reason := isEndpointReady(endpoint, c.minReady)
+			if ready != c.wantReady {
+				tt.Fatalf("got ready=%v (reason %q) want %v", ready, reason, c.wantReady)
+			}
+			if reason != c.wantReason {
+				tt.Fatalf("got reason %q want %q", reason, c.wantReason)
+			}
+		})
+	}
+}
+
+func TestReconcileOrder(t *testing.T) {
+	g := NewGomegaWithT(t)
+	c := createTestController(t)
+
+	names := []string{"config-b", "config-a", "config-c"}
+	c.injectedFiles = make(map[string][]byte)
+	c.o.WebhookConfigPaths = make(map[string]string)
+	for _, name := range names {
+		config := unpatchedIstiodWebhookConfig.DeepCopyObject().(*kubeApiAdmission.ValidatingWebhookConfiguration)
+		config.Name = name
+		path := name + "-path"
+		c.o.WebhookConfigPaths[name] = path
+		c.injectedFiles[path] = []byte(runtime.EncodeOrDie(codec, config))
+	}
+	c.o.WebhookConfigNames = names
+
+	c.endpointStore.Add(istiodEndpoint)
+	reconcileHelper(t, c)
+	var created []string
+	for _, action := range c.Actions() {
+		g.Expect(action.Matches("create", "validatingwebhookconfigurations")).Should(BeTrue())
+		created = append(created, action.(k8stesting.CreateAction).GetObject().(*kubeApiAdmission.ValidatingWebhookConfiguration).Name)
+	}
+	g.Expect(created).Should(Equal(names), "configs should be applied in order")
+
+	c.o.UnregisterValidationWebhook = true
+	reconcileHelper(t, c)
+	var deleted []string
+	for _, action := range c.Actions() {
+		g.Expect(action.Matches("delete", "validatingwebhookconfigurations")).Should(BeTrue())
+		deleted = append(deleted, action.(k8stesting.DeleteAction).GetName())
+	}
+	g.Expect(deleted).Should(Equal([]string{"config-c", "config-a", "config-b"}), "configs should be deleted in reverse order")
+}
+
+func TestManagedLabels(t *testing.T) {
+	g := NewGomegaWithT(t)
+	managedLabels := map[string]string{
+		managedByLabel:              managedByValue,
+		"app.kubernetes.io/part-of": "istio",
+	}
+	c := createTestController(t, func(o *Options) {
+		o.ManagedLabels = managedLabels
+	})
+	getConfig := func() *kubeApiAdmission.ValidatingWebhookConfiguration {
+		config, err := c.ValidatingWebhookConfigurations().Get(galleyWebhookName, kubeApisMeta.GetOptions{})
+		g.Expect(err).Should(Succeed())
+		return config
+	}
+
+	c.endpointStore.Add(istiodEndpoint)
+	reconcileHelper(t, c)
+	g.Expect(getConfig().Labels).Should(Equal(managedLabels))
+
+	// a label removed and another changed out-of-band are restored.
+	drifted := getConfig()
+	drifted.Labels = map[string]string{managedByLabel: managedByValue, "app.kubernetes.io/part-of": "other", "team": "a"}
+	_, err := c.ValidatingWebhookConfigurations().Update(drifted)
+	g.Expect(err).Should(Succeed())
+	c.configStore.Add(drifted)
+	reconcileHelper(t, c)
+	g.Expect(c.Actions()).Should(HaveLen(1))
+	g.Expect(c.Actions()[0].Matches("update", "validatingwebhookconfigurations")).Should(BeTrue())
+	g.Expect(getConfig().Labels).Should(Equal(map[string]string{
+		managedByLabel:              managedByValue,
+		"app.kubernetes.io/part-of": "istio",
+		"team":                      "a",
+	}), "labels not managed by the controller are kept")
+
+	c.configStore.Update(getConfig())
+	reconcileHelper(t, c)
+	g.Expect(c.Actions()).Should(BeEmpty(), "no update without drift")
+
+	o := Options{ServiceName: istiod, ManagedLabels: map[string]string{managedByLabel: "someone-else"}}
+	g.Expect(o.Validate()).Should(MatchError(ContainSubstring("conflicts with the managed-by label value")))
+}
+
+func TestServicePortName(t *testing.T) {
+	g := NewGomegaWithT(t)
+	reporter := newFakeMetricsReporter()
+	c := createTestController(t, func(o *Options) {
+		o.MetricsReporter = reporter
+		o.ServicePortName = "https-webhook"
+	})
+	serviceStore := c.informersFor(c.options(), namespace).Core().V1().Services().Informer().GetStore()
+	ports := func() []*int32 {
+		config, err := c.ValidatingWebhookConfigurations().Get(galleyWebhookName, kubeApisMeta.GetOptions{})
+		g.Expect(err).Should(Succeed())
+		c.configStore.Update(config)
+		var ports []*int32
+		for _, webhook := range config.Webhooks {
+			ports = append(ports, webhook.ClientConfig.Service.Port)
+		}
+		return ports
+	}
+
+	c.endpointStore.Add(istiodEndpoint)
+	reconcileHelper(t, c)
+	g.Expect(c.Actions()).Should(BeEmpty(), "no config until the port is resolved")
+	g.Expect(reporter.loadErrors[galleyWebhookName]).Should(Equal([]string{"could not resolve webhook service port"}))
+
+	service := &kubeApiCore.Service{
+		ObjectMeta: kubeApiMeta.ObjectMeta{Name: istiod, Namespace: namespace},
+		Spec: kubeApiCore.ServiceSpec{Ports: []kubeApiCore.ServicePort{
+			{Name: "grpc-xds", Port: 15010},
+			{Name: "https-webhook", Port: 15017},
+		}},
+	}
+	serviceStore.Add(service)
+	reconcileHelper(t, c)
+	port := int32(15017)
+	g.Expect(ports()).Should(Equal([]*int32{&port, &port}))
+
+	// the named port moves.
+	moved := service.DeepCopy()
+	moved.Spec.Ports[1].Port = 443
+	serviceStore.Update(moved)
+	reconcileHelper(t, c)
+	port = 443
+	g.Expect(ports()).Should(Equal([]*int32{&port, &port}))
+
+	// the ports of the template are kept without a port name.
+	c.o.ServicePortName = ""
+	reconcileHelper(t, c)
+	g.Expect(ports()).Should(Equal([]*int32{nil, nil}))
+
+	g.Expect(Options{ServiceName: istiod, ServicePortName: "https_webhook"}.Validate()).
+		Should(MatchError(ContainSubstring("invalid service port name")))
+}
+
+func TestTemplateDeleted(t *testing.T) {
+	for _, useCached := range []bool{false, true} {
+		t.Run(fmt.Sprintf("UseCachedTemplateOnError=%v", useCached), func(t *testing.T) {
+			g := NewGomegaWithT(t)
+			c := createTestController(t)
+			reporter := newFakeMetricsReporter()
+			c.metrics = reporter
+
+			const name, path = "config-a", "config-a-path"
+			config := unpatchedIstiodWebhookConfig.DeepCopyObject().(*kubeApiAdmission.ValidatingWebhookConfiguration)
+			config.Name = name
+			c.injectedFiles = map[string][]byte{path: []byte(runtime.EncodeOrDie(codec, config))}
+			c.o.WebhookConfigPaths = map[string]string{name: path}
+			c.o.WebhookConfigNames = []string{name}
+			c.o.UseCachedTemplateOnError = useCached
+
+			c.endpointStore.Add(istiodEndpoint)
+			reconcileHelper(t, c)
+			created, err := c.ValidatingWebhookConfigurations().Get(name, kubeApisMeta.GetOptions{})
+			g.Expect(err).Should(Succeed())
+			c.configStore.Add(created)
+
+			// delete the template and rotate the CA mid-run.
+			c.injectedMu.Lock()
+			delete(c.injectedFiles, path)
+			c.injectedCABundle = caBundle1
+			c.injectedMu.Unlock()
+			reconcileHelper(t, c)
+
+			g.Expect(reporter.templateMissing).Should(Equal(map[string]int{name: 1}))
+			var events []string
+			for len(c.recorder.Events) > 0 {
+				events = append(events, <-c.recorder.Events)
+			}
+			g.Expect(events).Should(ContainElement("Warning TemplateMissing Template config-a-path is missing"))
+
+			if !useCached {
+				g.Expect(c.Actions()).Should(BeEmpty(), "config is left in place")
+				g.Expect(reporter.loadErrors[name]).Should(Equal([]string{"could not read validatingwebhookconfiguration file"}))
+				return
+			}
+			g.Expect(c.Actions()).Should(HaveLen(1))
+			g.Expect(c.Actions()[0].Matches("update", "validatingwebhookconfigurations")).Should(BeTrue())
+			updated, err := c.ValidatingWebhookConfigurations().Get(name, kubeApisMeta.GetOptions{})
+			g.Expect(err).Should(Succeed())
+			for _, webhook := range updated.Webhooks {
+				g.Expect(webhook.ClientConfig.CABundle).Should(Equal(caBundle1), "cached template is used")
+			}
+			g.Expect(reporter.loadErrors[name]).Should(BeEmpty())
+		})
+	}
+}
+
+func TestCABundleSharedByConfigs(t *testing.T) {
+	g := NewGomegaWithT(t)
+	c := createTestController(t)
+
+	names := []string{"config-networking", "config-security"}
+	c.injectedFiles = make(map[string][]byte)
+	c.o.WebhookConfigPaths = make(map[string]string)
+	for _, name := range names {
+		config := unpatchedIstiodWebhookConfig.DeepCopyObject().(*kubeApiAdmission.ValidatingWebhookConfiguration)
+		config.Name = name
+		path := name + "-path"
+		c.o.WebhookConfigPaths[name] = path
+		c.injectedFiles[path] = []byte(runtime.EncodeOrDie(codec, config))
+	}
+	c.o.WebhookConfigNames = names
+	caBundles := func() map[string][][]byte {
+		bundles := make(map[string][][]byte)
+		for _, name := range names {
+			config, err := c.ValidatingWebhookConfigurations().Get(name, kubeApisMeta.GetOptions{})
+			g.Expect(err).Should(Succeed())
+			c.configStore.Update(config)
+			for _, webhook := range config.Webhooks {
+				bundles[name] = append(bundles[name], webhook.ClientConfig.CABundle)
+			}
+		}
+		return bundles
+	}
+
+	c.endpointStore.Add(istiodEndpoint)
+	reconcileHelper(t, c)
+	g.Expect(caBundles()).Should(Equal(map[string][][]byte{
+		"config-networking": {caBundle0, caBundle0},
+		"config-security":   {caBundle0, caBundle0},
+	}))
+
+	// a rotated CA is patched into every config.
+	c.injectedMu.Lock()
+	c.injectedCABundle = caBundle1
+	c.injectedMu.Unlock()
+	reconcileHelper(t, c)
+	g.Expect(c.Actions()).Should(HaveLen(2))
+	g.Expect(caBundles()).Should(Equal(map[string][][]byte{
+		"config-networking": {caBundle1, caBundle1},
+		"config-security":   {caBundle1, caBundle1},
+	}))
+
+	// a failed write doesn't hold back the other configs.
+	c.PrependReactor("update", "validatingwebhookconfigurations",
+		func(action k8stesting.Action) (bool, runtime.Object, error) {
+			config := action.(k8stesting.UpdateAction).GetObject().(*kubeApiAdmission.ValidatingWebhookConfiguration)
+			if config.Name != "config-networking" {
+				return false, nil, nil
+			}
+			return true, nil, kubeErrors.NewServerTimeout(
+				kubeApiAdmission.Resource("validatingwebhookconfigurations"), "update", 1)
+		})
+	c.injectedMu.Lock()
+	c.injectedCABundle = caBundle0
+	c.injectedMu.Unlock()
+	c.ClearActions()
+	g.Expect(c.reconcileRequest(context.Background(), c.options(), &reconcileRequest{description: "test"})).ShouldNot(Succeed())
+	g.Expect(caBundles()).Should(Equal(map[string][][]byte{
+		"config-networking": {caBundle1, caBundle1},
+		"config-security":   {caBundle0, caBundle0},
+	}))
+
+	// nor does a template which can't be built.
+	c.injectedMu.Lock()
+	c.injectedFiles["config-networking-path"] = []byte("junk")
+	c.injectedCABundle = caBundle1
+	c.injectedMu.Unlock()
+	reconcileHelper(t, c)
+	g.Expect(c.summary.state()).Should(Equal("failed to build config-networking"))
+	g.Expect(caBundles()["config-security"]).Should(Equal([][]byte{caBundle1, caBundle1}))
+}
+
+func TestSkipCAInjection(t *testing.T) {
+	g := NewGomegaWithT(t)
+
+	template := unpatchedIstiodWebhookConfig.DeepCopyObject().(*kubeApiAdmission.ValidatingWebhookConfiguration)
+	template.Webhooks[1].ClientConfig.CABundle = caBundle1
+	encoded := []byte(runtime.EncodeOrDie(codec, template))
+
+	config, err := buildValidatingWebhookConfiguration(Options{}, caBundle0, nil, encoded, nil)
+	g.Expect(err).Should(Succeed())
+	g.Expect(config.Webhooks[0].ClientConfig.CABundle).Should(Equal(caBundle0))
+	g.Expect(config.Webhooks[1].ClientConfig.CABundle).Should(Equal(caBundle0))
+
+	o := Options{SkipCAInjectionWebhooks: []string{"hook1"}}
+	config, err = buildValidatingWebhookConfiguration(o, caBundle0, nil, encoded, nil)
+	g.Expect(err).Should(Succeed())
+	g.Expect(config.Webhooks[0].ClientConfig.CABundle).Should(Equal(caBundle0))
+	g.Expect(config.Webhooks[1].ClientConfig.CABundle).Should(Equal(caBundle1), "skipped webhook keeps its own caBundle")
+}
+
+func TestRenderTemplate(t *testing.T) {
+	g := NewGomegaWithT(t)
+
+	o := Options{
+		RenderTemplate:    true,
+		WatchedNamespace:  namespace,
+		ServiceName:       istiod,
+		WebhookConfigName: galleyWebhookName,
+	}
+	want, err := buildValidatingWebhookConfiguration(Options{}, caBundle0, nil, []byte(istiodWebhookConfigEncoded), nil)
+	g.Expect(err).Should(Succeed())
+
+	template := unpatchedIstiodWebhookConfig.DeepCopyObject().(*kubeApiAdmission.ValidatingWebhookConfiguration)
+	template.Name = "{{ .WebhookConfigName }}"
+	for i := range template.Webhooks {
+		template.Webhooks[i].ClientConfig.Service.Name = "{{ .ServiceName }}"
+		template.Webhooks[i].ClientConfig.Service.Namespace = "{{ .WatchedNamespace }}"
+	}
+	encoded := []byte(runtime.EncodeOrDie(codec, template))
+
+	config, err := buildValidatingWebhookConfiguration(o, caBundle0, nil, encoded, nil)
+	g.Expect(err).Should(Succeed())
+	g.Expect(config).Should(Equal(want))
+
+	for _, bad := range []string{"{{ .ServiceName", "{{ .NoSuchOption }}"} {
+		_, err = buildValidatingWebhookConfiguration(o, caBundle0, nil, []byte(bad), nil)
+		g.Expect(err).ShouldNot(Succeed())
+		g.Expect(err.(*configError).Reason()).Should(Equal("could not render validatingwebhookconfiguration template"))
+	}
+
+	// templates are left as-is unless rendering is enabled.
+	o.RenderTemplate = false
+	config, err = buildValidatingWebhookConfiguration(o, caBundle0, nil, encoded, nil)
+	g.Expect(err).Should(Succeed())
+	g.Expect(config.Name).Should(Equal("{{ .WebhookConfigName }}"))
+}
+
+func TestCABundleVerifier(t *testing.T) {
+	g := NewGomegaWithT(t)
+
+	encoded := []byte(istiodWebhookConfigEncoded)
+	derBundle := []byte("der:0123456789")
+	o := Options{
+		CABundleVerifier: func(caBundle []byte) error {
+			if !bytes.HasPrefix(caBundle, []byte("der:")) {
+				return errors.New("not a DER bundle")
+			}
+			return nil
+		},
+	}
+
+	config, err := buildValidatingWebhookConfiguration(o, derBundle, nil, encoded, nil)
+	g.Expect(err).Should(Succeed())
+	for _, webhook := range config.Webhooks {
+		g.Expect(webhook.ClientConfig.CABundle).Should(Equal(derBundle), "verified bytes are used as is")
+	}
+
+	_, err = buildValidatingWebhookConfiguration(o, caBundle0, nil, encoded, nil)
+	g.Expect(err).ShouldNot(Succeed())
+	g.Expect(err.(*configError).Reason()).Should(Equal("could not verify caBundle"))
+
+	// PEM is verified by default.
+	_, err = buildValidatingWebhookConfiguration(Options{}, derBundle, nil, encoded, nil)
+	g.Expect(err).ShouldNot(Succeed())
+	g.Expect(err.(*configError).Reason()).Should(Equal("could not verify caBundle"))
+}
+
+func TestStrictServiceCheck(t *testing.T) {
+	g := NewGomegaWithT(t)
+
+	o := Options{ServiceName: istiod, WatchedNamespace: namespace}
+	mismatched := unpatchedIstiodWebhookConfig.DeepCopyObject().(*kubeApiAdmission.ValidatingWebhookConfiguration)
+	mismatched.Webhooks[1].ClientConfig.Service.Name = "istio-galley"
+	encodedMismatch := []byte(runtime.EncodeOrDie(codec, mismatched))
+
+	cases := []struct {
+		name     string
+		strict   bool
+		template []byte
+		reason   string
+	}{
+		{name: "match", strict: true, template: []byte(istiodWebhookConfigEncoded)},
+		{name: "mismatch-strict", strict: true, template: encodedMismatch, reason: "service mismatch"},
+		{name: "mismatch-lenient", template: encodedMismatch},
+	}
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			o.StrictServiceCheck = tc.strict
+			_, err := buildValidatingWebhookConfiguration(o, caBundle0, nil, tc.template, nil)
+			if tc.reason == "" {
+				g.Expect(err).Should(Succeed())
+			} else {
+				g.Expect(err).ShouldNot(Succeed())
+				g.Expect(err.(*configError).Reason()).Should(Equal(tc.reason))
+			}
+		})
+	}
+}
+
+func TestRequireCABundle(t *testing.T) {
+	g := NewGomegaWithT(t)
+
+	encoded := []byte(istiodWebhookConfigEncoded)
+	o := Options{SkipCAInjectionWebhooks: []string{"hook1"}}
+	_, err := buildValidatingWebhookConfiguration(o, caBundle0, nil, encoded, nil)
+	g.Expect(err).Should(Succeed())
+
+	o.RequireCABundle = true
+	_, err = buildValidatingWebhookConfiguration(o, caBundle0, nil, encoded, nil)
+	g.Expect(err).ShouldNot(Succeed())
+	g.Expect(err.(*configError).Reason()).Should(Equal("missing caBundle"))
+	g.Expect(err.Error()).Should(ContainSubstring(`"hook1"`))
+
+	template := unpatchedIstiodWebhookConfig.DeepCopyObject().(*kubeApiAdmission.ValidatingWebhookConfiguration)
+	template.Webhooks[1].ClientConfig.CABundle = caBundle1
+	_, err = buildValidatingWebhookConfiguration(o, caBundle0, nil, []byte(runtime.EncodeOrDie(codec, template)), nil)
+	g.Expect(err).Should(Succeed(), "the template provides the caBundle")
+}
+
+func TestTimeoutSeconds(t *testing.T) {
+	g := NewGomegaWithT(t)
+
+	for _, timeout := range []int32{1, 30} {
+		template := unpatchedIstiodWebhookConfig.DeepCopyObject().(*kubeApiAdmission.ValidatingWebhookConfiguration)
+		template.Webhooks[1].TimeoutSeconds = &timeout
+		_, err := buildValidatingWebhookConfiguration(Options{}, caBundle0, nil, []byte(runtime.EncodeOrDie(codec, template)), nil)
+		g.Expect(err).Should(Succeed())
+	}
+
+	for _, timeout := range []int32{0, 31} {
+		template := unpatchedIstiodWebhookConfig.DeepCopyObject().(*kubeApiAdmission.ValidatingWebhookConfiguration)
+		template.Webhooks[1].TimeoutSeconds = &timeout
+		_, err := buildValidatingWebhookConfiguration(Options{}, caBundle0, nil, []byte(runtime.EncodeOrDie(codec, template)), nil)
+		g.Expect(err).ShouldNot(Succeed())
+		g.Expect(err.(*configError).Reason()).Should(Equal("invalid timeoutSeconds"))
+		g.Expect(err.Error()).Should(ContainSubstring(fmt.Sprintf(`"hook1" timeoutSeconds %v`, timeout)))
+	}
+}
+
+func TestWebhookTimeoutSecondsOverride(t *testing.T) {
+	g := NewGomegaWithT(t)
+
+	fromTemplate := int32(5)
+	template := unpatchedIstiodWebhookConfig.DeepCopyObject().(*kubeApiAdmission.ValidatingWebhookConfiguration)
+	template.Webhooks[1].TimeoutSeconds = &fromTemplate
+	encoded := []byte(runtime.EncodeOrDie(codec, template))
+	timeouts := func(o Options) []*int32 {
+		config, err := buildValidatingWebhookConfiguration(o, caBundle0, nil, encoded, nil)
+		g.Expect(err).Should(Succeed())
+		var timeouts []*int32
+		for _, webhook := range config.Webhooks {
+			timeouts = append(timeouts, webhook.TimeoutSeconds)
+		}
+		return timeouts
+	}
+
+	// the template's timeouts are kept.
+	g.Expect(timeouts(Options{})).Should(Equal([]*int32{nil, &fromTemplate}))
+
+	override := int32(20)
+	g.Expect(timeouts(Options{WebhookTimeoutSeconds: &override})).Should(Equal([]*int32{&override, &override}))
+
+	o := createTestController(t).o
+	for _, timeout := range []int32{1, 30} {
+		timeout := timeout
+		o.WebhookTimeoutSeconds = &timeout
+		g.Expect(o.Validate()).Should(Succeed())
+	}
+	for _, timeout := range []int32{0, 31} {
+		timeout := timeout
+		o.WebhookTimeoutSeconds = &timeout
+		g.Expect(o.Validate()).ShouldNot(Succeed())
+	}
+}
+
+func TestCustomCodec(t *testing.T) {
+	g := NewGomegaWithT(t)
+
+	const defaultedLabel = "defaulted-by"
+	custom, customScheme := NewCodec(func(s *runtime.Scheme) error {
+		s.AddTypeDefaultingFunc(&kubeApiAdmission.ValidatingWebhookConfiguration{}, func(obj interface{}) {
+			config := obj.(*kubeApiAdmission.ValidatingWebhookConfiguration)
+			if config.Labels == nil {
+				config.Labels = make(map[string]string)
+			}
+			config.Labels[defaultedLabel] = "custom-scheme"
+		})
+		return nil
+	})
+	g.Expect(customScheme.Recognizes(kubeApiAdmission.SchemeGroupVersion.WithKind("ValidatingWebhookConfiguration"))).
+		Should(BeTrue())
+
+	encoded := []byte(istiodWebhookConfigEncoded)
+
+	config, err := decodeValidatingConfig(custom, encoded)
+	g.Expect(err).Should(Succeed())
+	g.Expect(config.Labels).Should(HaveKeyWithValue(defaultedLabel, "custom-scheme"))
+	g.Expect(config.Webhooks).Should(HaveLen(len(unpatchedIstiodWebhookConfig.Webhooks)))
+
+	config, err = decodeValidatingConfig(nil, encoded)
+	g.Expect(err).Should(Succeed())
+	g.Expect(config.Labels).ShouldNot(HaveKey(defaultedLabel), "the package codec is unchanged")
+
+	built, err := buildValidatingWebhookConfiguration(Options{Codec: custom}, caBundle0, nil, encoded, nil)
+	g.Expect(err).Should(Succeed())
+	g.Expect(built.Labels).Should(HaveKeyWithValue(defaultedLabel, "custom-scheme"))
+}
+
+func TestManagedNamespaces(t *testing.T) {
+	byName := func(names ...string) *kubeApiMeta.LabelSelector {
+		return &kubeApiMeta.LabelSelector{MatchExpressions: []kubeApiMeta.LabelSelectorRequirement{{
+			Key:      namespaceNameLabel,
+			Operator: kubeApiMeta.LabelSelectorOpIn,
+			Values:   names,
+		}}}
+	}
+	o := Options{ManagedNamespaces: []string{"team-a", "team-b"}}
+
+	cases := []struct {
+		name     string
+		selector *kubeApiMeta.LabelSelector
+		inScope  bool
+	}{
+		{"in", byName("team-a", "team-b"), true},
+		{"subset", byName("team-b"), true},
+		{"label", &kubeApiMeta.LabelSelector{MatchLabels: map[string]string{namespaceNameLabel: "team-a"}}, true},
+		{"in with other requirements", &kubeApiMeta.LabelSelector{
+			MatchLabels:      map[string]string{"istio-injection": "enabled"},
+			MatchExpressions: byName("team-a").MatchExpressions,
+		}, true},
+		{"empty", &kubeApiMeta.LabelSelector{}, false},
+		{"other namespace", byName("team-a", "kube-system"), false},
+		{"other label", &kubeApiMeta.LabelSelector{MatchLabels: map[string]string{"team": "a"}}, false},
+		{"not in", &kubeApiMeta.LabelSelector{MatchExpressions: []kubeApiMeta.LabelSelectorRequirement{{
+			Key:      namespaceNameLabel,
+			Operator: kubeApiMeta.LabelSelectorOpNotIn,
+			Values:   []string{"kube-system"},
+		}}}, false},
+	}
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			g := NewGomegaWithT(t)
+			template := unpatchedIstiodWebhookConfig.DeepCopyObject().(*kubeApiAdmission.ValidatingWebhookConfiguration)
+			for i := range template.Webhooks {
+				template.Webhooks[i].NamespaceSelector = tc.selector
+			}
+			encoded := []byte(runtime.EncodeOrDie(codec, template))
+
+			_, err := buildValidatingWebhookConfiguration(Options{}, caBundle0, nil, encoded, nil)
+			g.Expect(err).Should(Succeed(), "any selector is accepted without managed namespaces")
+
+			_, err = buildValidatingWebhookConfiguration(o, caBundle0, nil, encoded, nil)
+			if tc.inScope {
+				g.Expect(err).Should(Succeed())
+				return
+			}
+			g.Expect(err).ShouldNot(Succeed())
+			g.Expect(err.(*configError).Reason()).Should(Equal("namespaceSelector not limited to managed namespaces"))
+			g.Expect(err.Error()).Should(ContainSubstring(`["hook0" "hook1"]`))
+		})
+	}
+}
+
+func TestFailurePolicySideEffects(t *testing.T) {
+	g := NewGomegaWithT(t)
+	reporter := newFakeMetricsReporter()
+
+	// the template's webhooks default to failurePolicy Fail and sideEffects Unknown.
+	encoded := []byte(istiodWebhookConfigEncoded)
+	_, err := buildValidatingWebhookConfiguration(Options{MetricsReporter: reporter}, caBundle0, nil, encoded, nil)
+	g.Expect(err).Should(Succeed(), "risky combinations are only a warning by default")
+	g.Expect(reporter.sideEffects[galleyWebhookName]).Should(Equal(1))
+
+	_, err = buildValidatingWebhookConfiguration(Options{StrictSideEffects: true}, caBundle0, nil, encoded, nil)
+	g.Expect(err).ShouldNot(Succeed())
+	g.Expect(err.(*configError).Reason()).Should(Equal("risky failurePolicy and sideEffects"))
+	g.Expect(err.Error()).Should(ContainSubstring(`"hook0" "hook1"`))
+
+	none := kubeApiAdmission.SideEffectClassNone
+	noneOnDryRun := kubeApiAdmission.SideEffectClassNoneOnDryRun
+	template := unpatchedIstiodWebhookConfig.DeepCopyObject().(*kubeApiAdmission.ValidatingWebhookConfiguration)
+	template.Webhooks[0].SideEffects = &none
+	template.Webhooks[1].SideEffects = &noneOnDryRun
+	encoded = []byte(runtime.EncodeOrDie(codec, template))
+	_, err = buildValidatingWebhookConfiguration(Options{StrictSideEffects: true, MetricsReporter: reporter}, caBundle0, nil, encoded, nil)
+	g.Expect(err).Should(Succeed())
+	g.Expect(reporter.sideEffects[galleyWebhookName]).Should(Equal(1))
+}
+
+func TestFailurePolicySideEffectsWarnedOnce(t *testing.T) {
+	g := NewGomegaWithT(t)
+	reporter := newFakeMetricsReporter()
+	c := createTestController(t, func(o *Options) {
+		o.MetricsReporter = reporter
+	})
+	c.endpointStore.Add(istiodEndpoint)
+
+	reconcileHelper(t, c)
+	reconcileHelper(t, c)
+	g.Expect(reporter.sideEffects[galleyWebhookName]).Should(Equal(1), "warned once per template")
+
+	template := unpatchedIstiodWebhookConfig.DeepCopyObject().(*kubeApiAdmission.ValidatingWebhookConfiguration)
+	template.Webhooks[0].Rules[0].Operations = []kubeApiAdmission.OperationType{kubeApiAdmission.Create}
+	c.injectedMu.Lock()
+	c.injectedConfig = []byte(runtime.EncodeOrDie(codec, template))
+	c.injectedMu.Unlock()
+	reconcileHelper(t, c)
+	reconcileHelper(t, c)
+	g.Expect(reporter.sideEffects[galleyWebhookName]).Should(Equal(2), "warned again once the template changes")
+}
+
+func TestDuplicateWebhookNames(t *testing.T) {
+	g := NewGomegaWithT(t)
+
+	template := unpatchedIstiodWebhookConfig.DeepCopyObject().(*kubeApiAdmission.ValidatingWebhookConfiguration)
+	template.Webhooks[1].Name = template.Webhooks[0].Name
+	encoded := []byte(runtime.EncodeOrDie(codec, template))
+
+	_, err := buildValidatingWebhookConfiguration(Options{}, caBundle0, nil, encoded, nil)
+	g.Expect(err).ShouldNot(Succeed())
+	g.Expect(err.(*configError).Reason()).Should(Equal("duplicate webhook names"))
+	g.Expect(err.Error()).Should(ContainSubstring(`"hook0"`))
+
+	config, err := buildValidatingWebhookConfiguration(Options{DedupWebhooks: true}, caBundle0, nil, encoded, nil)
+	g.Expect(err).Should(Succeed())
+	g.Expect(config.Webhooks).Should(HaveLen(1))
+	g.Expect(config.Webhooks[0].ClientConfig.Service.Path).Should(Equal(&[]string{"/hook0"}[0]))
+}
+
+func TestCacheDesiredConfig(t *testing.T) {
+	g := NewGomegaWithT(t)
+	c := createTestController(t)
+	c.o.CacheDesiredConfig = true
+
+	c.endpointStore.Add(istiodEndpoint)
+	reconcileHelper(t, c)
+	g.Expect(c.ValidatingWebhookConfigurations().Get(galleyWebhookName, kubeApisMeta.GetOptions{})).
+		Should(Equal(webhookConfigWithCABundle0))
+	c.configStore.Add(webhookConfigWithCABundle0)
+
+	reconcileHelper(t, c)
+	g.Expect(c.Actions()).Should(BeEmpty())
+	g.Expect(c.readFileCount).Should(Equal(map[string]int{configPath: 1, caPath: 1}),
+		"files should not be re-read without a change event")
+
+	// the cached contents are used until the watcher reports a change.
+	c.injectedMu.Lock()
+	c.injectedCABundle = caBundle1
+	c.injectedMu.Unlock()
+	reconcileHelper(t, c)
+	g.Expect(c.Actions()).Should(BeEmpty())
+
+	c.cache.invalidateFile(caPath)
+	reconcileHelper(t, c)
+	g.Expect(c.readFileCount).Should(Equal(map[string]int{configPath: 1, caPath: 2}))
+	webhookConfigAfterCAUpdate := webhookConfigWithCABundle0.DeepCopyObject().(*kubeApiAdmission.ValidatingWebhookConfiguration)
+	webhookConfigAfterCAUpdate.Webhooks[0].ClientConfig.CABundle = caBundle1
+	webhookConfigAfterCAUpdate.Webhooks[1].ClientConfig.CABundle = caBundle1
+	g.Expect(c.ValidatingWebhookConfigurations().Get(galleyWebhookName, kubeApisMeta.GetOptions{})).
+		Should(Equal(webhookConfigAfterCAUpdate), "webhook should change after the cached CA file is invalidated")
+}
+
+func TestFailOnInvalidConfigAtStartup(t *testing.T) {
+	g := NewGomegaWithT(t)
+
+	o := Options{
+		WatchedNamespace:  namespace,
+		CAPath:            caPath,
+		WebhookConfigName: galleyWebhookName,
+		WebhookConfigPath: configPath,
+		ServiceName:       istiod,
+		Client:            fake.NewSimpleClientset(),
+	}
+	create := func(config []byte) (*Controller, error) {
+		newFileWatcher, _ := filewatcher.NewFakeWatcher(nil)
+		readFile := func(filename string) ([]byte, error) {
+			switch filename {
+			case caPath:
+				return caBundle0, nil
+			case configPath:
+				return config, nil
+			}
+			return nil, os.ErrNotExist
+		}
+		return newController(o, newFileWatcher, readFile, newSharedInformerFactory, newFakeKubeClient, nil)
+	}
+
+	_, err := create([]byte("bad configfile"))
+	g.Expect(err).Should(Succeed(), "invalid config is tolerated by default")
+
+	o.FailOnInvalidConfigAtStartup = true
+	before := goruntime.NumGoroutine()
+	_, err = create([]byte("bad configfile"))
+	g.Expect(err).ShouldNot(Succeed())
+	g.Expect(err.Error()).Should(ContainSubstring(galleyWebhookName))
+	g.Eventually(goruntime.NumGoroutine, 10*time.Second, 10*time.Millisecond).Should(BeNumerically("<=", before),
+		"the goroutines of the queue should exit")
+
+	_, err = create([]byte(istiodWebhookConfigEncoded))
+	g.Expect(err).Should(Succeed())
+}
+
+func TestServiceAndGalleyNamespaces(t *testing.T) {
+	g := NewGomegaWithT(t)
+	c := createTestController(t, func(o *Options) {
+		o.ServiceNamespace = "webhook-system"
+		o.GalleyNamespace = "galley-system"
+	})
+
+	// objects in the watched namespace are ignored.
+	c.endpointStore.Add(istiodEndpoint)
+	reconcileHelper(t, c)
+	g.Expect(c.Actions()).Should(BeEmpty())
+
+	endpoint := istiodEndpoint.DeepCopy()
+	endpoint.Namespace = "webhook-system"
+	c.endpointStore.Add(endpoint)
+	deployment := galleyDeployment.DeepCopy()
+	deployment.Namespace = "galley-system"
+	c.deploymentStore.Add(deployment)
+	reconcileHelper(t, c)
+	g.Expect(c.Actions()).Should(BeEmpty(), "galley deployment in its own namespace should defer reconcile")
+
+	c.deploymentStore.Delete(deployment)
+	reconcileHelper(t, c)
+	g.Expect(c.Actions()[0].Matches("create", "validatingwebhookconfigurations")).Should(BeTrue())
+}
+
+func TestMinUpdateInterval(t *testing.T) {
+	g := NewGomegaWithT(t)
+	c := createTestController(t, func(o *Options) {
+		o.MinUpdateInterval = time.Minute
+	})
+	fakeClock := clock.NewFakeClock(testNow)
+	c.clock = fakeClock
+
+	c.endpointStore.Add(istiodEndpoint)
+	reconcileHelper(t, c)
+	g.Expect(c.Actions()[0].Matches("create", "validatingwebhookconfigurations")).Should(BeTrue())
+	c.configStore.Add(webhookConfigWithCABundle0)
+
+	c.injectedMu.Lock()
+	c.injectedCABundle = caBundle1
+	c.injectedMu.Unlock()
+
+	fakeClock.Step(30 * time.Second)
+	reconcileHelper(t, c)
+	g.Expect(c.Actions()).Should(BeEmpty(), "write should be throttled")
+
+	fakeClock.Step(30 * time.Second)
+	reconcileHelper(t, c)
+	g.Expect(c.Actions()[0].Matches("update", "validatingwebhookconfigurations")).Should(BeTrue())
+}
+
+// uncomparableMetricsReporter is a MetricsReporter whose dynamic type
+// cannot be compared with ==.
+type uncomparableMetricsReporter struct {
+	*fakeMetricsReporter
+	labels []string
+}
+
+func TestUpdateOptions(t *testing.T) {
+	g := NewGomegaWithT(t)
+	c := createTestController(t)
+
+	c.endpointStore.Add(istiodEndpoint)
+	reconcileHelper(t, c)
+	g.Expect(c.Actions()[0].Matches("create", "validatingwebhookconfigurations")).Should(BeTrue())
+	c.configStore.Add(webhookConfigWithCABundle0)
+
+	o := c.o
+	o.WatchedNamespace = "other"
+	g.Expect(c.UpdateOptions(o)).ShouldNot(Succeed(), "immutable option changed")
+
+	o = c.o
+	o.MetricsReporter = uncomparableMetricsReporter{newFakeMetricsReporter(), []string{"a"}}
+	g.Expect(c.UpdateOptions(o)).ShouldNot(Succeed(), "immutable option changed")
+
+	o = c.o
+	o.MinReadyEndpoints = -1
+	g.Expect(c.UpdateOptions(o)).ShouldNot(Succeed(), "invalid option")
+	g.Expect(c.queue.Len()).Should(Equal(0))
+
+	o = c.o
+	o.UnregisterValidationWebhook = true
+	g.Expect(c.UpdateOptions(o)).Should(Succeed())
+	g.Expect(c.queue.Len()).Should(Equal(1), "reconcile should be enqueued")
+
+	reconcileHelper(t, c)
+	g.Expect(c.Actions()[0].Matches("delete", "validatingwebhookconfigurations")).Should(BeTrue())
+}
+
+// The options are swapped while the worker and the event handlers read
+// them. Run with -race.
+func TestUpdateOptionsWhileRunning(t *testing.T) {
+	g := NewGomegaWithT(t)
+	c := createTestController(t)
+	c.endpointStore.Add(istiodEndpoint)
+	o := c.options()
+
+	stop := make(chan struct{})
+	defer close(stop)
+	c.Start(stop)
+	defer c.Stop()
+
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		for i := 0; i < 20; i++ {
+			updated := o
+			updated.MaxReconcileRetries = i
+			if err := c.UpdateOptions(updated); err != nil {
+				t.Error(err)
+				return
+			}
+		}
+	}()
+	for i := 0; i < 20; i++ {
+		c.onFileChanged(o.CAPath, caFileDescription, fsnotify.Event{Name: o.CAPath, Op: fsnotify.Write})
+		c.onServiceUpdate(&kubeApiCore.Service{}, &kubeApiCore.Service{})
+	}
+	<-done
+	g.Expect(c.Reconcile("options updated")).Should(Succeed())
+	g.Expect(c.options().MaxReconcileRetries).Should(Equal(19))
+}
+
+func TestCheckImmutableOptionsUncomparable(t *testing.T) {
+	g := NewGomegaWithT(t)
+	reporter := uncomparableMetricsReporter{newFakeMetricsReporter(), []string{"a"}}
+	old := Options{MetricsReporter: reporter}
+
+	g.Expect(checkImmutableOptions(old, old)).Should(Succeed())
+	updated := Options{MetricsReporter: uncomparableMetricsReporter{reporter.fakeMetricsReporter, []string{"b"}}}
+	g.Expect(checkImmutableOptions(old, updated)).ShouldNot(Succeed())
+}
+
+func TestUpdateConflictResolvedFromLive(t *testing.T) {
+	g := NewGomegaWithT(t)
+	c := createTestController(t)
+	c.endpointStore.Add(istiodEndpoint)
+
+	cached := webhookConfigWithCABundle0.DeepCopyObject().(*kubeApiAdmission.ValidatingWebhookConfiguration)
+	cached.ResourceVersion = "1"
+	c.configStore.Add(cached)
+	// written concurrently and not yet observed by the informer.
+	live := cached.DeepCopyObject().(*kubeApiAdmission.ValidatingWebhookConfiguration)
+	live.ResourceVersion = "2"
+	live.Labels = map[string]string{"concurrent": "write"}
+	_, err := c.ValidatingWebhookConfigurations().Create(live)
+	g.Expect(err).Should(Succeed())
+
+	c.injectedMu.Lock()
+	c.injectedCABundle = caBundle1
+	c.injectedMu.Unlock()
+
+	var resourceVersions []string
+	c.PrependReactor("update", "validatingwebhookconfigurations",
+		func(action k8stesting.Action) (bool, runtime.Object, error) {
+			config := action.(k8stesting.UpdateAction).GetObject().(*kubeApiAdmission.ValidatingWebhookConfiguration)
+			resourceVersions = append(resourceVersions, config.ResourceVersion)
+			if config.ResourceVersion != live.ResourceVersion {
+				return true, nil, kubeErrors.NewConflict(
+					kubeApiAdmission.Resource("validatingwebhookconfigurations"), config.Name, errors.New("stale"))
+			}
+			return false, nil, nil
+		})
+
+	c.ClearActions()
+	g.Expect(c.reconcileRequest(context.Background(), c.options(), &reconcileRequest{description: "test"})).Should(Succeed())
+	g.Expect(resourceVersions).Should(Equal([]string{"1", "2"}))
+	g.Expect(c.queue.Len()).Should(Equal(0), "the conflict should not be requeued")
+
+	updated, err := c.ValidatingWebhookConfigurations().Get(galleyWebhookName, kubeApisMeta.GetOptions{})
+	g.Expect(err).Should(Succeed())
+	g.Expect(updated.Labels).Should(HaveKeyWithValue("concurrent", "write"))
+	for _, webhook := range updated.Webhooks {
+		g.Expect(webhook.ClientConfig.CABundle).Should(Equal(caBundle1))
+	}
+}
+
+func TestInvalidConfigRejected(t *testing.T) {
+	g := NewGomegaWithT(t)
+	reporter := newFakeMetricsReporter()
+	c := createTestController(t, func(o *Options) {
+		o.MetricsReporter = reporter
+	})
+
+	c.endpointStore.Add(istiodEndpoint)
+	c.configStore.Add(webhookConfigWithCABundle0)
+	c.injectedMu.Lock()
+	c.injectedCABundle = caBundle1
+	c.injectedMu.Unlock()
+
+	c.PrependReactor("update", "validatingwebhookconfigurations",
+		func(action k8stesting.Action) (bool, runtime.Object, error) {
+			return true, nil, kubeErrors.NewInvalid(
+				kubeApiAdmission.SchemeGroupVersion.WithKind("ValidatingWebhookConfiguration").GroupKind(),
+				galleyWebhookName,
+				field.ErrorList{field.Invalid(field.NewPath("webhooks").Index(0).Child("sideEffects"), "Unknown", "bad")})
+		})
+
+	c.ClearActions()
+	g.Expect(c.reconcileRequest(context.Background(), c.options(), &reconcileRequest{description: "test"})).Should(Succeed(), "invalid configs should not be retried")
+	g.Expect(c.Actions()[0].Matches("update", "validatingwebhookconfigurations")).Should(BeTrue())
+	g.Expect(reporter.updateErrors[galleyWebhookName]).Should(Equal([]kubeApiMeta.StatusReason{kubeApiMeta.StatusReasonInvalid}))
+}
+
+func TestPruneStaleRevisionConfigs(t *testing.T) {
+	g := NewGomegaWithT(t)
+	c := createTestController(t, func(o *Options) {
+		o.Revision = "canary"
+		o.PruneStaleRevisionConfigs = true
+	})
+
+	stale := webhookConfigWithCABundle0.DeepCopyObject().(*kubeApiAdmission.ValidatingWebhookConfiguration)
+	stale.Name = "istio-galley-stable"
+	stale.Labels = map[string]string{revisionLabel: "stable", managedByLabel: managedByValue}
+	unmanaged := webhookConfigWithCABundle0.DeepCopyObject().(*kubeApiAdmission.ValidatingWebhookConfiguration)
+	unmanaged.Name = "istio-galley-unmanaged"
+	unmanaged.Labels = map[string]string{revisionLabel: "stable"}
+	for _, config := range []*kubeApiAdmission.ValidatingWebhookConfiguration{stale, unmanaged} {
+		_, _ = c.ValidatingWebhookConfigurations().Create(config)
+		c.configStore.Add(config)
+	}
+
+	// the canary config is created but not yet observed installed.
+	c.endpointStore.Add(istiodEndpoint)
+	reconcileHelper(t, c)
+	g.Expect(c.Actions()).Should(HaveLen(1))
+	g.Expect(c.Actions()[0].Matches("create", "validatingwebhookconfigurations")).Should(BeTrue())
+
+	installed, err := c.ValidatingWebhookConfigurations().Get(galleyWebhookName, kubeApisMeta.GetOptions{})
+	g.Expect(err).Should(Succeed())
+	g.Expect(installed.Labels).Should(Equal(map[string]string{revisionLabel: "canary", managedByLabel: managedByValue}))
+	c.configStore.Add(installed)
+
+	// the canary is promoted once its config is installed.
+	reconcileHelper(t, c)
+	g.Expect(c.Actions()).Should(HaveLen(1))
+	g.Expect(c.Actions()[0].Matches("delete", "validatingwebhookconfigurations")).Should(BeTrue())
+	g.Expect(c.Actions()[0].(k8stesting.DeleteAction).GetName()).Should(Equal(stale.Name))
+}
+
+func TestUserAgent(t *testing.T) {
+	g := NewGomegaWithT(t)
+
+	g.Expect(UserAgent("1.5.0")).Should(Equal("istiod-validation-controller/1.5.0"))
+	g.Expect(UserAgent("")).Should(Equal("istiod-validation-controller/unknown"))
+
+	userAgents := make(chan string, 1)
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		userAgents <- r.UserAgent()
+		http.NotFound(w, r)
+	}))
+	defer server.Close()
+
+	config := &rest.Config{Host: server.URL, UserAgent: "other"}
+	client, err := NewClient(config, "1.5.0")
+	g.Expect(err).Should(Succeed())
+	g.Expect(config.UserAgent).Should(Equal("other"), "the caller's config should not be modified")
+
+	_, _ = client.AdmissionregistrationV1beta1().ValidatingWebhookConfigurations().
+		Get(galleyWebhookName, kubeApisMeta.GetOptions{})
+	g.Expect(<-userAgents).Should(Equal("istiod-validation-controller/1.5.0"))
+}
+
+func TestReconcileSummary(t *testing.T) {
+	g := NewGomegaWithT(t)
+	c := createTestController(t)
+
+	reconcileHelper(t, c)
+	g.Expect(c.summary.lastState).Should(Equal("endpoint not ready"))
+
+	c.endpointStore.Add(istiodEndpoint)
+	c.PrependReactor("create", "validatingwebhookconfigurations",
+		func(action k8stesting.Action) (bool, runtime.Object, error) {
+			return true, nil, kubeErrors.NewServerTimeout(
+				kubeApiAdmission.Resource("validatingwebhookconfigurations"), "create", 1)
+		})
+	g.Expect(c.reconcileRequest(context.Background(), c.options(), &reconcileRequest{description: "test"})).ShouldNot(Succeed())
+
+	c.injectedMu.Lock()
+	c.injectedCABundle = []byte("junk")
+	c.injectedMu.Unlock()
+	reconcileHelper(t, c)
+	g.Expect(c.summary.lastState).Should(Equal("failed to build " + galleyWebhookName))
+
+	g.Expect(c.summary.reconciles).Should(Equal(3))
+	g.Expect(c.summary.successes).Should(Equal(1))
+	g.Expect(c.summary.errors).Should(Equal(map[string]int{
+		string(kubeApiMeta.StatusReasonServerTimeout): 1,
+		"could not verify caBundle":                   1,
+	}))
+	c.logSummary()
+}
+
+func TestCABundleValidity(t *testing.T) {
+	g := NewGomegaWithT(t)
+	reporter := newFakeMetricsReporter()
+	c := createTestController(t, func(o *Options) {
+		o.CertValiditySkew = time.Hour
+		o.MetricsReporter = reporter
+	})
+	block, _ := pem.Decode(caBundle0)
+	cert, err := x509.ParseCertificate(block.Bytes)
+	g.Expect(err).Should(Succeed())
+	fakeClock := clock.NewFakeClock(cert.NotBefore.Add(-2 * time.Hour))
+	c.clock = fakeClock
+	c.endpointStore.Add(istiodEndpoint)
+
+	reconcileHelper(t, c)
+	g.Expect(c.Actions()).Should(BeEmpty())
+	g.Expect(reporter.validity).Should(Equal([]string{"caBundle not yet valid"}))
+
+	// within the allowed skew.
+	fakeClock.SetTime(cert.NotBefore.Add(-30 * time.Minute))
+	reconcileHelper(t, c)
+	g.Expect(c.Actions()[0].Matches("create", "validatingwebhookconfigurations")).Should(BeTrue())
+	c.configStore.Add(webhookConfigWithCABundle0)
+
+	fakeClock.SetTime(cert.NotAfter.Add(2 * time.Hour))
+	reconcileHelper(t, c)
+	g.Expect(c.Actions()).Should(BeEmpty())
+	g.Expect(reporter.validity).Should(Equal([]string{"caBundle not yet valid", "caBundle expired"}))
+	g.Expect(reporter.loadErrors[galleyWebhookName]).Should(Equal([]string{"caBundle not yet valid", "caBundle expired"}))
+
+	// every certificate in the bundle must be valid.
+	fakeClock.SetTime(testNow.Add(6 * 365 * 24 * time.Hour))
+	c.injectedMu.Lock()
+	c.injectedCABundle = bytes.Join([][]byte{caBundle0, caBundle1}, []byte("\n"))
+	c.injectedMu.Unlock()
+	reconcileHelper(t, c)
+	g.Expect(c.Actions()).Should(BeEmpty())
+	g.Expect(reporter.validity).Should(Equal([]string{"caBundle not yet valid", "caBundle expired", "caBundle expired"}))
+}
+
+func TestRequiredCRDs(t *testing.T) {
+	g := NewGomegaWithT(t)
+	c := createTestController(t, func(o *Options) {
+		o.APIExtensionsClient = apiextensionsfake.NewSimpleClientset()
+		o.RequiredCRDs = []string{"gateways.networking.istio.io"}
+	})
+	crdStore := c.crdInformers.Apiextensions().V1beta1().CustomResourceDefinitions().Informer().GetStore()
+
+	c.endpointStore.Add(istiodEndpoint)
+	reconcileHelper(t, c)
+	g.Expect(c.Actions()).Should(BeEmpty(), "no config when the CRD is missing")
+
+	crd := &kubeApiExtensions.CustomResourceDefinition{
+		ObjectMeta: kubeApiMeta.ObjectMeta{Name: "gateways.networking.istio.io"},
+	}
+	crdStore.Add(crd)
+	reconcileHelper(t, c)
+	g.Expect(c.Actions()).Should(BeEmpty(), "no config when the CRD is not established")
+
+	crd = crd.DeepCopy()
+	crd.Status.Conditions = []kubeApiExtensions.CustomResourceDefinitionCondition{{
+		Type:   kubeApiExtensions.Established,
+		Status: kubeApiExtensions.ConditionTrue,
+	}}
+	crdStore.Update(crd)
+	reconcileHelper(t, c)
+	g.Expect(c.Actions()[0].Matches("create", "validatingwebhookconfigurations")).Should(BeTrue())
+}
+
+func TestServiceSelectorChanged(t *testing.T) {
+	g := NewGomegaWithT(t)
+	reporter := newFakeMetricsReporter()
+	c := createTestController(t, func(o *Options) {
+		o.MetricsReporter = reporter
+	})
+
+	prev := &kubeApiCore.Service{
+		ObjectMeta: kubeApiMeta.ObjectMeta{Name: istiod, Namespace: namespace},
+		Spec:       kubeApiCore.ServiceSpec{Selector: map[string]string{"app": "istiod"}},
+	}
+	same := prev.DeepCopy()
+	changed := prev.DeepCopy()
+	changed.Spec.Selector = map[string]string{"app": "istiod-typo"}
+	other := changed.DeepCopy()
+	other.Name = "other"
+
+	c.onServiceUpdate(prev, same)
+	c.onServiceUpdate(prev, other)
+	g.Expect(c.queue.Len()).Should(Equal(0))
+
+	c.onServiceUpdate(prev, changed)
+	g.Expect(reporter.selector).Should(Equal(0), "no warning when the config is not installed")
+	g.Expect(c.queue.Len()).Should(Equal(1))
+
+	c.configStore.Add(webhookConfigWithCABundle0)
+	c.onServiceUpdate(changed, prev)
+	g.Expect(reporter.selector).Should(Equal(1))
+}
+
+func TestManagedByLabelValue(t *testing.T) {
+	g := NewGomegaWithT(t)
+	c := createTestController(t, func(o *Options) {
+		o.ManagedByLabelValue = "istio-operator"
+	})
+	c.endpointStore.Add(istiodEndpoint)
+
+	reconcileHelper(t, c)
+	installed, err := c.ValidatingWebhookConfigurations().Get(galleyWebhookName, kubeApisMeta.GetOptions{})
+	g.Expect(err).Should(Succeed())
+	g.Expect(installed.Labels).Should(Equal(map[string]string{managedByLabel: "istio-operator"}))
+
+	// configs without the label, e.g. from an older controller, are adopted.
+	legacy := webhookConfigWithCABundle0.DeepCopyObject().(*kubeApiAdmission.ValidatingWebhookConfiguration)
+	failurePolicyIgnore := kubeApiAdmission.Ignore
+	legacy.Webhooks[0].FailurePolicy = &failurePolicyIgnore
+	c.configStore.Add(legacy)
+	reconcileHelper(t, c)
+	g.Expect(c.Actions()[0].Matches("update", "validatingwebhookconfigurations")).Should(BeTrue())
+
+	other := legacy.DeepCopy()
+	other.Labels = map[string]string{managedByLabel: managedByValue}
+	c.configStore.Update(other)
+	reconcileHelper(t, c)
+	g.Expect(c.Actions()).Should(BeEmpty(), "configs of a different manager should not be modified")
+
+	o := c.o
+	o.ManagedByLabelValue = "not a label value"
+	g.Expect(o.Validate()).ShouldNot(Succeed())
+
+	// the label isn't enforced by default since e.g. Helm sets it on the
+	// configs it installs.
+	c = createTestController(t)
+	c.endpointStore.Add(istiodEndpoint)
+	helm := webhookConfigWithCABundle0.DeepCopyObject().(*kubeApiAdmission.ValidatingWebhookConfiguration)
+	helm.Labels = map[string]string{managedByLabel: "Helm"}
+	c.configStore.Add(helm)
+	c.injectedMu.Lock()
+	c.injectedCABundle = caBundle1
+	c.injectedMu.Unlock()
+	reconcileHelper(t, c)
+	g.Expect(c.Actions()[0].Matches("update", "validatingwebhookconfigurations")).Should(BeTrue())
+}
+
+func TestStartupGracePeriod(t *testing.T) {
+	g := NewGomegaWithT(t)
+	c := createTestController(t, func(o *Options) {
+		o.StartupGracePeriod = time.Minute
+	})
+	fakeClock := clock.NewFakeClock(testNow)
+	c.clock = fakeClock
+
+	c.endpointStore.Add(istiodEndpoint)
+	reconcileHelper(t, c)
+	g.Expect(c.Actions()).Should(BeEmpty(), "no write during the grace period")
+
+	// the endpoint must still be ready once the grace period elapses.
+	fakeClock.Step(time.Minute)
+	c.endpointStore.Delete(istiodEndpoint)
+	c.endpointReadyOnce = true
+	reconcileHelper(t, c)
+	g.Expect(c.Actions()).Should(BeEmpty())
+
+	c.endpointStore.Add(istiodEndpoint)
+	reconcileHelper(t, c)
+	g.Expect(c.Actions()[0].Matches("create", "validatingwebhookconfigurations")).Should(BeTrue())
+}
+
+func TestEndpointReadyTimeout(t *testing.T) {
+	g := NewGomegaWithT(t)
+	c := createTestController(t, func(o *Options) {
+		o.EndpointReadyTimeout = time.Minute
+	})
+	fakeClock := clock.NewFakeClock(testNow)
+	c.clock = fakeClock
+	reporter := newFakeMetricsReporter()
+	c.metrics = reporter
+
+	// the endpoint is never ready.
+	reconcileHelper(t, c)
+	g.Expect(reporter.readyTimeouts).Should(Equal(0))
+	g.Expect(c.recorder.Events).Should(BeEmpty())
+
+	fakeClock.Step(time.Minute)
+	reconcileHelper(t, c)
+	reconcileHelper(t, c)
+	g.Expect(reporter.readyTimeouts).Should(Equal(1))
+	g.Expect(c.recorder.Events).Should(HaveLen(1))
+	g.Expect(<-c.recorder.Events).Should(HavePrefix("Warning EndpointNotReady"))
+	g.Expect(c.Actions()).Should(BeEmpty(), "no write while the endpoint is not ready")
+	g.Expect(c.endpointReadyOnce).Should(BeFalse())
+}
+
+func TestReconcile(t *testing.T) {
+	g := NewGomegaWithT(t)
+	c := createTestController(t)
+	c.endpointStore.Add(istiodEndpoint)
+
+	go c.runWorker()
+	defer c.queue.ShutDown()
+
+	g.Expect(c.Reconcile("CA rotated")).Should(Succeed())
+	g.Expect(c.ValidatingWebhookConfigurations().Get(galleyWebhookName, kubeApisMeta.GetOptions{})).
+		Should(Equal(webhookConfigWithCABundle0))
+
+	c.PrependReactor("update", "validatingwebhookconfigurations",
+		func(action k8stesting.Action) (bool, runtime.Object, error) {
+			return true, nil, kubeErrors.NewServerTimeout(
+				kubeApiAdmission.Resource("validatingwebhookconfigurations"), "update", 1)
+		})
+	c.configStore.Add(webhookConfigWithCABundle0)
+	c.injectedMu.Lock()
+	c.injectedCABundle = caBundle1
+	c.injectedMu.Unlock()
+	g.Expect(c.Reconcile("CA rotated")).ShouldNot(Succeed())
+
+	c.queue.ShutDown()
+	g.Expect(c.Reconcile("after shutdown")).ShouldNot(Succeed())
+}
+
+func TestStop(t *testing.T) {
+	g := NewGomegaWithT(t)
+	c := createTestController(t)
+	before := goruntime.NumGoroutine()
+
+	stop := make(chan struct{})
+	defer close(stop)
+	c.Start(stop)
+
+	done := make(chan struct{})
+	go func() {
+		c.Stop()
+		close(done)
+	}()
+	select {
+	case <-done:
+	case <-time.After(10 * time.Second):
+		t.Fatal("Stop did not wait for the worker to exit")
+	}
+
+	g.Expect(c.queue.ShuttingDown()).Should(BeTrue())
+	g.Expect(c.processNextWorkItem()).Should(BeFalse())
+
+	// stopping again is a no-op.
+	c.Stop()
+
+	g.Eventually(goruntime.NumGoroutine, 10*time.Second, 10*time.Millisecond).Should(BeNumerically("<=", before),
+		"goroutines started by the controller should exit")
+}
+
+func TestCASecret(t *testing.T) {
+	g := NewGomegaWithT(t)
+	reporter := newFakeMetricsReporter()
+	c := createTestController(t, func(o *Options) {
+		o.CAPath = ""
+		o.CASecretName = "istio-ca-secret"
+		o.MetricsReporter = reporter
+	})
+	secretStore := c.sharedInformers.Core().V1().Secrets().Informer().GetStore()
+	c.injectedMu.Lock()
+	c.injectedCABundle = []byte("the file should not be read")
+	c.injectedMu.Unlock()
+
+	c.endpointStore.Add(istiodEndpoint)
+	reconcileHelper(t, c)
+	g.Expect(c.Actions()).Should(BeEmpty())
+	g.Expect(reporter.loadErrors[galleyWebhookName]).Should(Equal([]string{"could not read caBundle file"}))
+
+	secret := &kubeApiCore.Secret{
+		ObjectMeta: kubeApiMeta.ObjectMeta{Name: "istio-ca-secret", Namespace: namespace},
+		Data:       map[string][]byte{"cert-chain.pem": caBundle0},
+	}
+	secretStore.Add(secret)
+	reconcileHelper(t, c)
+	g.Expect(c.ValidatingWebhookConfigurations().Get(galleyWebhookName, kubeApisMeta.GetOptions{})).
+		Should(Equal(webhookConfigWithCABundle0))
+	c.configStore.Add(webhookConfigWithCABundle0)
+
+	// ca.crt is preferred.
+	secret = secret.DeepCopy()
+	secret.Data["ca.crt"] = caBundle1
+	secretStore.Update(secret)
+	reconcileHelper(t, c)
+	g.Expect(c.Actions()[0].Matches("update", "validatingwebhookconfigurations")).Should(BeTrue())
+	updated, err := c.ValidatingWebhookConfigurations().Get(galleyWebhookName, kubeApisMeta.GetOptions{})
+	g.Expect(err).Should(Succeed())
+	g.Expect(updated.Webhooks[0].ClientConfig.CABundle).Should(Equal(caBundle1))
+}
+
+func TestCAConfigMap(t *testing.T) {
+	g := NewGomegaWithT(t)
+	reporter := newFakeMetricsReporter()
+	c := createTestController(t, func(o *Options) {
+		o.CAConfigMapName = "istio-ca-root-cert"
+		o.MetricsReporter = reporter
+	})
+	configMapStore := c.sharedInformers.Core().V1().ConfigMaps().Informer().GetStore()
+	c.injectedMu.Lock()
+	c.injectedCABundle = []byte("the file should not be read")
+	c.injectedMu.Unlock()
+
+	c.endpointStore.Add(istiodEndpoint)
+	reconcileHelper(t, c)
+	g.Expect(c.Actions()).Should(BeEmpty())
+	g.Expect(reporter.loadErrors[galleyWebhookName]).Should(Equal([]string{"could not read caBundle file"}))
+
+	configMap := &kubeApiCore.ConfigMap{
+		ObjectMeta: kubeApiMeta.ObjectMeta{Name: "istio-ca-root-cert", Namespace: namespace},
+		Data:       map[string]string{"root-cert.pem": string(caBundle0)},
+	}
+	configMapStore.Add(configMap)
+	reconcileHelper(t, c)
+	g.Expect(c.ValidatingWebhookConfigurations().Get(galleyWebhookName, kubeApisMeta.GetOptions{})).
+		Should(Equal(webhookConfigWithCABundle0))
+	c.configStore.Add(webhookConfigWithCABundle0)
+
+	// rotated.
+	configMap = configMap.DeepCopy()
+	configMap.Data["root-cert.pem"] = string(caBundle1)
+	configMapStore.Update(configMap)
+	reconcileHelper(t, c)
+	g.Expect(c.Actions()[0].Matches("update", "validatingwebhookconfigurations")).Should(BeTrue())
+	updated, err := c.ValidatingWebhookConfigurations().Get(galleyWebhookName, kubeApisMeta.GetOptions{})
+	g.Expect(err).Should(Succeed())
+	g.Expect(updated.Webhooks[0].ClientConfig.CABundle).Should(Equal(caBundle1))
+
+	// changes to the configmap trigger a reconcile.
+	handler := makeHandler(c.queue, c.metrics, configMapGVK, "istio-ca-root-cert")
+	rotated := configMap.DeepCopy()
+	rotated.ResourceVersion = "2"
+	handler.OnUpdate(configMap, rotated)
+	g.Expect(c.queue.Len()).Should(Equal(1))
+
+	// the secret takes precedence.
+	c = createTestController(t, func(o *Options) {
+		o.CASecretName = "istio-ca-secret"
+		o.CAConfigMapName = "istio-ca-root-cert"
+	})
+	c.sharedInformers.Core().V1().ConfigMaps().Informer().GetStore().Add(configMap)
+	c.sharedInformers.Core().V1().Secrets().Informer().GetStore().Add(&kubeApiCore.Secret{
+		ObjectMeta: kubeApiMeta.ObjectMeta{Name: "istio-ca-secret", Namespace: namespace},
+		Data:       map[string][]byte{"ca.crt": caBundle0},
+	})
+	caBundle, err := c.readCABundle(c.options())
+	g.Expect(err).Should(Succeed())
+	g.Expect(caBundle).Should(Equal(caBundle0))
+}
+
+func TestCABundleBase64Encoded(t *testing.T) {
+	g := NewGomegaWithT(t)
+
+	build := func(caBundle []byte) error {
+		_, err := buildValidatingWebhookConfiguration(Options{}, caBundle, nil, []byte(istiodWebhookConfigEncoded), nil)
+		return err
+	}
+
+	// raw PEM.
+	g.Expect(build(caBundle0)).Should(Succeed())
+
+	// double-encoded, also when line-wrapped.
+	encoded := base64.StdEncoding.EncodeToString(caBundle0)
+	wrapped := encoded[:64] + "\n" + encoded[64:] + "\n"
+	for _, caBundle := range []string{encoded, wrapped} {
+		err := build([]byte(caBundle))
+		g.Expect(err).ShouldNot(Succeed())
+		g.Expect(err.(*configError).Reason()).Should(Equal("caBundle appears to be base64-encoded PEM; supply raw PEM"))
+	}
+
+	// garbage, including valid base64 which isn't PEM.
+	for _, caBundle := range []string{"junk", base64.StdEncoding.EncodeToString([]byte("junk"))} {
+		err := build([]byte(caBundle))
+		g.Expect(err).ShouldNot(Succeed())
+		g.Expect(err.(*configError).Reason()).Should(Equal("could not verify caBundle"))
+		g.Expect(err.Error()).Should(ContainSubstring("could not decode pem"))
+	}
+}
+
+func TestLeaderElection(t *testing.T) {
+	g := NewGomegaWithT(t)
+	client := fake.NewSimpleClientset(istiodEndpoint.DeepCopy())
+
+	const replicas = 3
+	var controllers []*fakeController
+	var reporters []*fakeMetricsReporter
+	for i := 0; i < replicas; i++ {
+		reporter := newFakeMetricsReporter()
+		c := createTestController(t, func(o *Options) {
+			o.Client = client
+			o.EnableLeaderElection = true
+			o.MetricsReporter = reporter
+		})
+		c.leaderIdentity = fmt.Sprintf("replica-%d", i)
+		c.leaderTimings = leaderTimings{
+			leaseDuration: 2 * time.Second,
+			renewDeadline: time.Second,
+			retryPeriod:   100 * time.Millisecond,
+		}
+		controllers = append(controllers, c)
+		reporters = append(reporters, reporter)
+	}
+
+	stop := make(chan struct{})
+	for _, c := range controllers {
+		c.Start(stop)
+	}
+	defer func() {
+		close(stop)
+		for _, c := range controllers {
+			c.Stop()
+		}
+	}()
+
+	writers := func() (n int) {
+		for _, reporter := range reporters {
+			reporter.mu.Lock()
+			if reporter.updates[galleyWebhookName] > 0 {
+				n++
+			}
+			reporter.mu.Unlock()
+		}
+		return n
+	}
+	g.Eventually(writers, 10*time.Second, 10*time.Millisecond).Should(Equal(1))
+	g.Expect(client.AdmissionregistrationV1beta1().ValidatingWebhookConfigurations().
+		Get(galleyWebhookName, kubeApisMeta.GetOptions{})).Should(Equal(webhookConfigWithCABundle0))
+
+	// the config is restored by the leader alone.
+	g.Expect(client.AdmissionregistrationV1beta1().ValidatingWebhookConfigurations().
+		Delete(galleyWebhookName, &kubeApisMeta.DeleteOptions{})).Should(Succeed())
+	g.Eventually(func() error {
+		_, err := client.AdmissionregistrationV1beta1().ValidatingWebhookConfigurations().
+			Get(galleyWebhookName, kubeApisMeta.GetOptions{})
+		return err
+	}, 10*time.Second, 10*time.Millisecond).Should(Succeed())
+	g.Consistently(writers, time.Second, 10*time.Millisecond).Should(Equal(1))
+
+	var leaders int
+	for _, c := range controllers {
+		if c.isLeader(c.options()) {
+			leaders++
+		}
+	}
+	g.Expect(leaders).Should(Equal(1))
+
+	// followers answer Reconcile rather than blocking.
+	for _, c := range controllers {
+		if c.isLeader(c.options()) {
+			continue
+		}
+		result := make(chan error, 1)
+		go func(c *fakeController) { result <- c.Reconcile("follower") }(c)
+		g.Eventually(result, 5*time.Second).Should(Receive(Equal(errNotLeader)))
+	}
+}
+
+func TestEvents(t *testing.T) {
+	g := NewGomegaWithT(t)
+	c := createTestController(t)
+	c.endpointStore.Add(istiodEndpoint)
+
+	reconcileHelper(t, c)
+	g.Expect(c.recorder.Events).Should(Receive(Equal("Normal Created Created by istio-validation-controller")))
+
+	c.configStore.Add(webhookConfigWithCABundle0)
+	c.injectedMu.Lock()
+	c.injectedCABundle = caBundle1
+	c.injectedMu.Unlock()
+	reconcileHelper(t, c)
+	g.Expect(c.recorder.Events).Should(Receive(Equal("Normal Updated Updated by istio-validation-controller")))
+
+	// no event when the config is unchanged.
+	updated, err := c.ValidatingWebhookConfigurations().Get(galleyWebhookName, kubeApisMeta.GetOptions{})
+	g.Expect(err).Should(Succeed())
+	c.configStore.Update(updated)
+	reconcileHelper(t, c)
+	g.Expect(c.recorder.Events).ShouldNot(Receive())
+
+	c.configStore.Update(webhookConfigWithCABundle0)
+	c.PrependReactor("update", "validatingwebhookconfigurations",
+		func(action k8stesting.Action) (bool, runtime.Object, error) {
+			return true, nil, kubeErrors.NewServerTimeout(
+				kubeApiAdmission.Resource("validatingwebhookconfigurations"), "update", 1)
+		})
+	reconcileHelper(t, c)
+	g.Expect(c.recorder.Events).Should(Receive(HavePrefix("Warning UpdateFailed Update failed: ")))
+
+	g.Expect(c.deleteWebhookConfiguration(context.Background(), c.options(), validatingConfigKind, galleyWebhookName)).Should(Succeed())
+	g.Expect(c.recorder.Events).Should(Receive(Equal("Normal Deleted Deleted by istio-validation-controller")))
+}
+
+func TestReconcileTimeout(t *testing.T) {
+	g := NewGomegaWithT(t)
+	reporter := newFakeMetricsReporter()
+	c := createTestController(t, func(o *Options) {
+		o.ReconcileTimeout = 50 * time.Millisecond
+		o.MetricsReporter = reporter
+	})
+	c.endpointStore.Add(istiodEndpoint)
+
+	// the kube-apiserver is stuck until the request is canceled.
+	canceled := make(chan struct{})
+	var cancelOnce sync.Once
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		// the client going away is only noticed once the body was read.
+		_, _ = ioutil.ReadAll(r.Body)
+		<-r.Context().Done()
+		cancelOnce.Do(func() { close(canceled) })
+	}))
+	defer server.Close()
+	client, err := NewClient(&rest.Config{Host: server.URL}, "")
+	g.Expect(err).Should(Succeed())
+	c.kube = newRESTKubeClient(client)
+
+	req := &reconcileRequest{description: "test"}
+	c.queue.Add(req)
+	done := make(chan struct{})
+	go func() {
+		c.processNextWorkItem()
+		close(done)
+	}()
+	select {
+	case <-done:
+	case <-time.After(10 * time.Second):
+		t.Fatal("reconcile did not time out")
+	}
+	select {
+	case <-canceled:
+	case <-time.After(10 * time.Second):
+		t.Fatal("the timed out request was not canceled")
+	}
+
+	g.Expect(c.queue.NumRequeues(req)).Should(Equal(1), "timed out reconcile should be retried")
+	reporter.mu.Lock()
+	defer reporter.mu.Unlock()
+	g.Expect(reporter.updateErrors[galleyWebhookName]).Should(HaveLen(1))
+}
+
+func TestReady(t *testing.T) {
+	g := NewGomegaWithT(t)
+	c := createTestController(t)
+
+	reconcileHelper(t, c)
+	g.Expect(c.Ready()).Should(BeFalse(), "not ready before the endpoint is ready")
+
+	c.endpointStore.Add(istiodEndpoint)
+	reconcileHelper(t, c)
+	g.Expect(c.Actions()[0].Matches("create", "validatingwebhookconfigurations")).Should(BeTrue())
+	g.Expect(c.Ready()).Should(BeTrue(), "ready once the config is created")
+
+	c.PrependReactor("create", "validatingwebhookconfigurations",
+		func(action k8stesting.Action) (bool, runtime.Object, error) {
+			return true, nil, kubeErrors.NewServerTimeout(
+				kubeApiAdmission.Resource("validatingwebhookconfigurations"), "create", 1)
+		})
+	for i := 0; i < readyFailureThreshold-1; i++ {
+		reconcileHelper(t, c)
+		g.Expect(c.Ready()).Should(BeTrue(), "transient failures don't affect readiness")
+	}
+	reconcileHelper(t, c)
+	g.Expect(c.Ready()).Should(BeFalse(), "not ready after persistent failures")
+
+	c.configStore.Add(webhookConfigWithCABundle0)
+	reconcileHelper(t, c)
+	g.Expect(c.Ready()).Should(BeTrue(), "ready once the config matches")
+}
+
+func TestReadySettled(t *testing.T) {
+	t.Run("deferred to galley", func(t *testing.T) {
+		g := NewGomegaWithT(t)
+		c := createTestController(t)
+		c.deploymentStore.Add(galleyDeployment)
+		c.endpointStore.Add(istiodEndpoint)
+
+		reconcileHelper(t, c)
+		g.Expect(c.Actions()).Should(BeEmpty())
+		g.Expect(c.summary.state()).Should(Equal("deferred to galley deployment"))
+		g.Expect(c.Ready()).Should(BeTrue())
+	})
+
+	t.Run("paused", func(t *testing.T) {
+		g := NewGomegaWithT(t)
+		c := createTestController(t)
+		paused := galleyWebhookConfigWithCABundle1.DeepCopy()
+		paused.Annotations = map[string]string{pauseAnnotation: "true"}
+		c.configStore.Add(paused)
+		c.endpointStore.Add(istiodEndpoint)
+
+		reconcileHelper(t, c)
+		g.Expect(c.Actions()).Should(BeEmpty())
+		g.Expect(c.summary.state()).Should(Equal("paused"))
+		g.Expect(c.Ready()).Should(BeTrue())
+	})
+
+	t.Run("unregistered", func(t *testing.T) {
+		g := NewGomegaWithT(t)
+		c := createTestController(t, func(o *Options) {
+			o.UnregisterValidationWebhook = true
+		})
+		c.endpointStore.Add(istiodEndpoint)
+
+		reconcileHelper(t, c)
+		g.Expect(c.summary.state()).Should(Equal("unregistered"))
+		g.Expect(c.Ready()).Should(BeTrue())
+		g.Expect(c.status().Ready).Should(BeTrue())
+	})
+}
+
+func TestReadyNotApplied(t *testing.T) {
+	t.Run("pre-apply rejected", func(t *testing.T) {
+		g := NewGomegaWithT(t)
+		c := createTestController(t, func(o *Options) {
+			o.PreApply = func(*kubeApiAdmission.ValidatingWebhookConfiguration) error {
+				return errors.New("vetoed")
+			}
+		})
+		c.endpointStore.Add(istiodEndpoint)
+
+		reconcileHelper(t, c)
+		g.Expect(c.Actions()).Should(BeEmpty())
+		g.Expect(c.Ready()).Should(BeFalse())
+		g.Expect(c.summary.state()).Should(Equal("not applied: " + galleyWebhookName))
+	})
+
+	t.Run("invalid", func(t *testing.T) {
+		g := NewGomegaWithT(t)
+		c := createTestController(t)
+		c.endpointStore.Add(istiodEndpoint)
+		c.PrependReactor("create", "validatingwebhookconfigurations",
+			func(action k8stesting.Action) (bool, runtime.Object, error) {
+				return true, nil, kubeErrors.NewInvalid(
+					kubeApiAdmission.SchemeGroupVersion.WithKind("ValidatingWebhookConfiguration").GroupKind(),
+					galleyWebhookName, nil)
+			})
+
+		reconcileHelper(t, c)
+		g.Expect(c.Ready()).Should(BeFalse())
+		g.Expect(c.summary.state()).Should(Equal("not applied: " + galleyWebhookName))
+	})
+}
+
+func TestPerWebhookCABundles(t *testing.T) {
+	g := NewGomegaWithT(t)
+	reporter := newFakeMetricsReporter()
+	c := createTestController(t, func(o *Options) {
+		o.PerWebhookCAPaths = map[string]string{"hook1": "hook1-ca.pem"}
+		o.MetricsReporter = reporter
+	})
+	c.endpointStore.Add(istiodEndpoint)
+
+	reconcileHelper(t, c)
+	g.Expect(reporter.loadErrors[galleyWebhookName]).Should(Equal([]string{"could not read caBundle file"}))
+	g.Expect(c.Actions()).Should(BeEmpty())
+
+	c.injectedMu.Lock()
+	c.injectedFiles = map[string][]byte{"hook1-ca.pem": []byte("bad cert")}
+	c.injectedMu.Unlock()
+	reconcileHelper(t, c)
+	g.Expect(reporter.loadErrors[galleyWebhookName]).Should(Equal([]string{
+		"could not read caBundle file", "could not verify caBundle"}))
+	g.Expect(c.Actions()).Should(BeEmpty())
+
+	c.injectedMu.Lock()
+	c.injectedFiles = map[string][]byte{"hook1-ca.pem": caBundle1}
+	c.injectedMu.Unlock()
+	reconcileHelper(t, c)
+	created, err := c.ValidatingWebhookConfigurations().Get(galleyWebhookName, kubeApisMeta.GetOptions{})
+	g.Expect(err).Should(Succeed())
+	g.Expect(created.Webhooks[0].ClientConfig.CABundle).Should(Equal(caBundle0))
+	g.Expect(created.Webhooks[1].ClientConfig.CABundle).Should(Equal(caBundle1), "mapped webhook gets its own caBundle")
+}
+
+func TestPreserveSelectors(t *testing.T) {
+	adminSelector := &kubeApiMeta.LabelSelector{
+		MatchExpressions: []kubeApiMeta.LabelSelectorRequirement{{
+			Key:      "kube-system",
+			Operator: kubeApiMeta.LabelSelectorOpDoesNotExist,
+		}},
+	}
+
+	for _, preserve := range []bool{true, false} {
+		t.Run(fmt.Sprintf("preserve=%v", preserve), func(t *testing.T) {
+			g := NewGomegaWithT(t)
+			c := createTestController(t, func(o *Options) {
+				o.PreserveSelectors = preserve
+			})
+			c.endpointStore.Add(istiodEndpoint)
+
+			edited := webhookConfigWithCABundle0.DeepCopyObject().(*kubeApiAdmission.ValidatingWebhookConfiguration)
+			edited.Webhooks[0].NamespaceSelector = adminSelector
+			_, err := c.ValidatingWebhookConfigurations().Create(edited)
+			g.Expect(err).Should(Succeed())
+			c.configStore.Add(edited)
+
+			// rotate the CA so the config is updated.
+			c.injectedMu.Lock()
+			c.injectedCABundle = caBundle1
+			c.injectedMu.Unlock()
+			reconcileHelper(t, c)
+			g.Expect(c.Actions()[0].Matches("update", "validatingwebhookconfigurations")).Should(BeTrue())
+
+			updated, err := c.ValidatingWebhookConfigurations().Get(galleyWebhookName, kubeApisMeta.GetOptions{})
+			g.Expect(err).Should(Succeed())
+			g.Expect(updated.Webhooks[0].ClientConfig.CABundle).Should(Equal(caBundle1))
+			if preserve {
+				g.Expect(updated.Webhooks[0].NamespaceSelector).Should(Equal(adminSelector))
+			} else {
+				g.Expect(updated.Webhooks[0].NamespaceSelector).
+					Should(Equal(webhookConfigWithCABundle0.Webhooks[0].NamespaceSelector))
+			}
+
+			// the preserved selector is not a pending change.
+			c.configStore.Update(updated)
+			reconcileHelper(t, c)
+			g.Expect(c.Actions()).Should(BeEmpty())
+		})
+	}
+}
+
+func TestPreserveWebhookOrder(t *testing.T) {
+	g := NewGomegaWithT(t)
+	c := createTestController(t)
+	c.endpointStore.Add(istiodEndpoint)
+
+	// the live webhooks are in the reverse order of the template.
+	reordered := webhookConfigWithCABundle0.DeepCopyObject().(*kubeApiAdmission.ValidatingWebhookConfiguration)
+	reordered.Webhooks[0], reordered.Webhooks[1] = reordered.Webhooks[1], reordered.Webhooks[0]
+	_, err := c.ValidatingWebhookConfigurations().Create(reordered)
+	g.Expect(err).Should(Succeed())
+	c.configStore.Add(reordered)
+	reconcileHelper(t, c)
+	g.Expect(c.Actions()).Should(BeEmpty(), "the order alone should not rewrite the config")
+
+	// an update keeps the live order.
+	c.injectedMu.Lock()
+	c.injectedCABundle = caBundle1
+	c.injectedMu.Unlock()
+	reconcileHelper(t, c)
+	updated, err := c.ValidatingWebhookConfigurations().Get(galleyWebhookName, kubeApisMeta.GetOptions{})
+	g.Expect(err).Should(Succeed())
+	g.Expect(updated.Webhooks).Should(HaveLen(2))
+	g.Expect(updated.Webhooks[0].Name).Should(Equal(reordered.Webhooks[0].Name))
+	g.Expect(updated.Webhooks[1].Name).Should(Equal(reordered.Webhooks[1].Name))
+	g.Expect(updated.Webhooks[0].ClientConfig.CABundle).Should(Equal(caBundle1))
+}
+
+func TestKeepWebhookOrder(t *testing.T) {
+	g := NewGomegaWithT(t)
+	webhooks := func(names ...string) []kubeApiAdmission.ValidatingWebhook {
+		var hooks []kubeApiAdmission.ValidatingWebhook
+		for _, name := range names {
+			hooks = append(hooks, kubeApiAdmission.ValidatingWebhook{Name: name})
+		}
+		return hooks
+	}
+	names := func(hooks []kubeApiAdmission.ValidatingWebhook) []string {
+		var out []string
+		for _, hook := range hooks {
+			out = append(out, hook.Name)
+		}
+		return out
+	}
+
+	g.Expect(names(keepWebhookOrder(webhooks("b", "a"), webhooks("a", "b")))).Should(Equal([]string{"b", "a"}))
+	// new webhooks follow in the template order and removed ones are dropped.
+	g.Expect(names(keepWebhookOrder(webhooks("c", "a"), webhooks("a", "d", "b", "c")))).
+		Should(Equal([]string{"c", "a", "d", "b"}))
+	// duplicate names are all kept.
+	g.Expect(names(keepWebhookOrder(webhooks("b", "a"), webhooks("a", "b", "a")))).Should(Equal([]string{"b", "a", "a"}))
+}
+
+func TestClusterRoleOwnerRefs(t *testing.T) {
+	g := NewGomegaWithT(t)
+	c := createTestController(t)
+	c.endpointStore.Add(istiodEndpoint)
+
+	clusterRole := func(uid string) *kubeApiRbac.ClusterRole {
+		return &kubeApiRbac.ClusterRole{
+			ObjectMeta: kubeApiMeta.ObjectMeta{Name: istiodClusterRole, UID: types.UID(uid)},
+		}
+	}
+	ownerUIDs := func() []types.UID {
+		config, err := c.ValidatingWebhookConfigurations().Get(galleyWebhookName, kubeApisMeta.GetOptions{})
+		g.Expect(err).Should(Succeed())
+		c.configStore.Update(config)
+		var uids []types.UID
+		for _, ref := range config.OwnerReferences {
+			uids = append(uids, ref.UID)
+		}
+		return uids
+	}
+
+	c.clusterRoleStore.Add(clusterRole("uid-1"))
+	reconcileHelper(t, c)
+	g.Expect(ownerUIDs()).Should(Equal([]types.UID{"uid-1"}))
+
+	// recreated with a new UID.
+	c.clusterRoleStore.Update(clusterRole("uid-2"))
+	reconcileHelper(t, c)
+	g.Expect(c.Actions()[0].Matches("update", "validatingwebhookconfigurations")).Should(BeTrue())
+	g.Expect(ownerUIDs()).Should(Equal([]types.UID{"uid-2"}))
+
+	c.clusterRoleStore.Delete(clusterRole("uid-2"))
+	reconcileHelper(t, c)
+	g.Expect(c.Actions()[0].Matches("update", "validatingwebhookconfigurations")).Should(BeTrue())
+	g.Expect(ownerUIDs()).Should(BeEmpty())
+}
+
+func TestCABundleExpiryMetric(t *testing.T) {
+	g := NewGomegaWithT(t)
+	reporter := newFakeMetricsReporter()
+	c := createTestController(t, func(o *Options) {
+		o.MetricsReporter = reporter
+	})
+	c.endpointStore.Add(istiodEndpoint)
+
+	notAfter := func(caBundle []byte) time.Time {
+		block, _ := pem.Decode(caBundle)
+		cert, err := x509.ParseCertificate(block.Bytes)
+		g.Expect(err).Should(Succeed())
+		return cert.NotAfter
+	}
+	expiry := func() time.Duration {
+		reporter.mu.Lock()
+		defer reporter.mu.Unlock()
+		return reporter.expiry[galleyWebhookName]
+	}
+
+	reconcileHelper(t, c)
+	g.Expect(expiry()).Should(Equal(notAfter(caBundle0).Sub(testNow)))
+
+	// left stale on load error.
+	c.injectedMu.Lock()
+	c.injectedCABundle = []byte("bad cert")
+	c.injectedMu.Unlock()
+	reconcileHelper(t, c)
+	g.Expect(expiry()).Should(Equal(notAfter(caBundle0).Sub(testNow)))
+
+	// the earliest expiring certificate of the bundle is reported.
+	c.injectedMu.Lock()
+	c.injectedCABundle = bytes.Join([][]byte{caBundle0, caBundle1}, []byte("\n"))
+	c.injectedMu.Unlock()
+	reconcileHelper(t, c)
+	g.Expect(expiry()).Should(Equal(notAfter(caBundle1).Sub(testNow)))
+}
+
+func TestSkippedReconcileMetrics(t *testing.T) {
+	g := NewGomegaWithT(t)
+	reporter := newFakeMetricsReporter()
+	c := createTestController(t, func(o *Options) {
+		o.MetricsReporter = reporter
+	})
+
+	reconcileHelper(t, c)
+	g.Expect(reporter.skipped).Should(Equal(map[string]int{"endpoint not ready": 1}))
+
+	c.endpointStore.Add(istiodEndpoint)
+	c.deploymentStore.Add(galleyDeployment)
+	reconcileHelper(t, c)
+	g.Expect(reporter.skipped).Should(Equal(map[string]int{"endpoint not ready": 1, "galley running": 1}))
+
+	c.deploymentStore.Delete(galleyDeployment)
+	reconcileHelper(t, c)
+	g.Expect(reporter.skipped).Should(Equal(map[string]int{"endpoint not ready": 1, "galley running": 1}))
+	g.Expect(reporter.updates).Should(Equal(map[string]int{galleyWebhookName: 1}), "existing counters are unchanged")
+}
+
+func TestMaxReconcileRetries(t *testing.T) {
+	g := NewGomegaWithT(t)
+	reporter := newFakeMetricsReporter()
+	const maxRetries = 3
+	c := createTestController(t, func(o *Options) {
+		o.MaxReconcileRetries = maxRetries
+		o.MetricsReporter = reporter
+	})
+	c.endpointStore.Add(istiodEndpoint)
+
+	var creates int
+	c.PrependReactor("create", "validatingwebhookconfigurations",
+		func(action k8stesting.Action) (bool, runtime.Object, error) {
+			creates++
+			return true, nil, kubeErrors.NewForbidden(
+				kubeApiAdmission.Resource("validatingwebhookconfigurations"), galleyWebhookName, errors.New("rbac"))
+		})
+
+	req := &reconcileRequest{description: "test"}
+	c.queue.Add(req)
+	for i := 0; i <= maxRetries; i++ {
+		g.Expect(c.processNextWorkItem()).Should(BeTrue())
+	}
+	g.Expect(creates).Should(Equal(maxRetries + 1))
+	g.Expect(c.queue.NumRequeues(req)).Should(Equal(0), "dropped request should be forgotten")
+	g.Expect(reporter.exhausted).Should(Equal(1))
+
+	// nothing is left to retry.
+	time.Sleep(100 * time.Millisecond)
+	g.Expect(c.queue.Len()).Should(Equal(0))
+}
+
+func TestLastDiff(t *testing.T) {
+	g := NewGomegaWithT(t)
+	c := createTestController(t)
+	c.endpointStore.Add(istiodEndpoint)
+	g.Expect(c.LastDiff()).Should(BeEmpty())
+
+	c.configStore.Add(webhookConfigWithCABundle0)
+	_, err := c.ValidatingWebhookConfigurations().Create(webhookConfigWithCABundle0)
+	g.Expect(err).Should(Succeed())
+	c.injectedMu.Lock()
+	c.injectedCABundle = caBundle1
+	c.injectedMu.Unlock()
+
+	reconcileHelper(t, c)
+	g.Expect(c.Actions()[0].Matches("update", "validatingwebhookconfigurations")).Should(BeTrue())
+	g.Expect(c.LastDiff()).Should(Equal("validatingwebhookconfiguration istio-galley: " +
+		"Webhooks[0].ClientConfig.CABundle, Webhooks[1].ClientConfig.CABundle"))
+}
+
+func TestDryRun(t *testing.T) {
+	g := NewGomegaWithT(t)
+	reporter := newFakeMetricsReporter()
+	c := createTestController(t, func(o *Options) {
+		o.DryRun = true
+		o.MetricsReporter = reporter
+	})
+	c.endpointStore.Add(istiodEndpoint)
+
+	isMutation := func(action k8stesting.Action) bool {
+		switch action.GetVerb() {
+		case "create", "update", "patch", "delete":
+			return true
+		}
+		return false
+	}
+	mutations := func() (n int) {
+		for _, action := range c.Actions() {
+			if isMutation(action) {
+				n++
+			}
+		}
+		return n
+	}
+
+	// would create.
+	reconcileHelper(t, c)
+	g.Expect(mutations()).Should(Equal(0))
+	g.Expect(reporter.updates[galleyWebhookName]).Should(Equal(1))
+
+	// would update.
+	c.configStore.Add(webhookConfigWithCABundle0)
+	c.injectedMu.Lock()
+	c.injectedCABundle = caBundle1
+	c.injectedMu.Unlock()
+	reconcileHelper(t, c)
+	g.Expect(mutations()).Should(Equal(0))
+	g.Expect(reporter.updates[galleyWebhookName]).Should(Equal(2))
+	g.Expect(c.LastDiff()).Should(ContainSubstring("Webhooks[0].ClientConfig.CABundle"))
+
+	// would delete.
+	c.o.UnregisterValidationWebhook = true
+	reconcileHelper(t, c)
+	g.Expect(mutations()).Should(Equal(0))
+
+	_, err := c.ValidatingWebhookConfigurations().Get(galleyWebhookName, kubeApisMeta.GetOptions{})
+	g.Expect(kubeErrors.IsNotFound(err)).Should(BeTrue())
+}
+
+func TestStartupJitter(t *testing.T) {
+	g := NewGomegaWithT(t)
+	const jitter = 300 * time.Millisecond
+	c := createTestController(t, func(o *Options) {
+		o.StartupJitter = jitter
+	})
+	const seed = 1
+	c.rand = rand.New(rand.NewSource(seed))
+	expected := time.Duration(rand.New(rand.NewSource(seed)).Int63n(int64(jitter)))
+
+	start := time.Now()
+	c.kickstart(c.options())
+	g.Expect(c.queue.Len()).Should(Equal(0), "initial request should be delayed")
+
+	obj, shutdown := c.queue.Get()
+	elapsed := time.Since(start)
+	g.Expect(shutdown).Should(BeFalse())
+	g.Expect(obj.(*reconcileRequest).description).Should(Equal("initial request to kickstart reconciliation"))
+	g.Expect(elapsed).Should(BeNumerically(">=", expected-10*time.Millisecond))
+	g.Expect(elapsed).Should(BeNumerically("<", jitter+time.Second))
+
+	// no delay by default.
+	c = createTestController(t)
+	c.kickstart(c.options())
+	g.Expect(c.queue.Len()).Should(Equal(1))
+}
+
+// failingWatcher fails to watch the given path.
+type failingWatcher struct {
+	filewatcher.FileWatcher
+	path string
+}
+
+func (w failingWatcher) Add(path string) error {
+	if path == w.path {
+		return os.ErrNotExist
+	}
+	return w.FileWatcher.Add(path)
+}
+
+func TestFileWatchError(t *testing.T) {
+	for _, tc := range []struct {
+		path        string
+		description string
+	}{
+		{configPath, "validatingwebhookconfiguration file"},
+		{caPath, "CA file"},
+	} {
+		t.Run(tc.description, func(t *testing.T) {
+			g := NewGomegaWithT(t)
+			o := Options{
+				WatchedNamespace:  namespace,
+				CAPath:            caPath,
+				WebhookConfigName: galleyWebhookName,
+				WebhookConfigPath: configPath,
+				ServiceName:       istiod,
+				Client:            fake.NewSimpleClientset(),
+			}
+			newFileWatcher, _ := filewatcher.NewFakeWatcher(func(string, bool) {})
+			failing := func() filewatcher.FileWatcher {
+				return failingWatcher{newFileWatcher(), tc.path}
+			}
+
+			_, err := newController(o, failing, ioutil.ReadFile, newSharedInformerFactory, newFakeKubeClient, nil)
+			g.Expect(err).ShouldNot(Succeed())
+			var watchErr *FileWatchError
+			g.Expect(errors.As(err, &watchErr)).Should(BeTrue())
+			g.Expect(watchErr.Path).Should(Equal(tc.path))
+			g.Expect(watchErr.Description).Should(Equal(tc.description))
+			g.Expect(errors.Is(err, os.ErrNotExist)).Should(BeTrue())
+		})
+	}
+}
+
+func TestAdditionalCAPaths(t *testing.T) {
+	g := NewGomegaWithT(t)
+	reporter := newFakeMetricsReporter()
+	c := createTestController(t, func(o *Options) {
+		o.AdditionalCAPaths = []string{"new-root.pem"}
+		o.MetricsReporter = reporter
+	})
+	c.endpointStore.Add(istiodEndpoint)
+
+	c.injectedMu.Lock()
+	c.injectedFiles = map[string][]byte{"new-root.pem": []byte("bad cert")}
+	c.injectedMu.Unlock()
+	reconcileHelper(t, c)
+	g.Expect(reporter.loadErrors[galleyWebhookName]).Should(Equal([]string{"could not verify caBundle"}))
+	g.Expect(c.Actions()).Should(BeEmpty())
+
+	c.injectedMu.Lock()
+	c.injectedFiles = map[string][]byte{"new-root.pem": caBundle1}
+	c.injectedMu.Unlock()
+	reconcileHelper(t, c)
+	created, err := c.ValidatingWebhookConfigurations().Get(galleyWebhookName, kubeApisMeta.GetOptions{})
+	g.Expect(err).Should(Succeed())
+
+	merged := created.Webhooks[0].ClientConfig.CABundle
+	var certs []*x509.Certificate
+	for block, rest := pem.Decode(merged); block != nil; block, rest = pem.Decode(rest) {
+		cert, err := x509.ParseCertificate(block.Bytes)
+		g.Expect(err).Should(Succeed())
+		certs = append(certs, cert)
+	}
+	parse := func(caBundle []byte) *x509.Certificate {
+		block, _ := pem.Decode(caBundle)
+		cert, err := x509.ParseCertificate(block.Bytes)
+		g.Expect(err).Should(Succeed())
+		return cert
+	}
+	g.Expect(certs).Should(Equal([]*x509.Certificate{parse(caBundle0), parse(caBundle1)}))
+}
+
+func TestFileEventsCoalesced(t *testing.T) {
+	g := NewGomegaWithT(t)
+	c := createTestController(t)
+	c.endpointStore.Add(istiodEndpoint)
+
+	ev := fsnotify.Event{Name: c.o.CAPath}
+	for _, op := range []fsnotify.Op{fsnotify.Rename, fsnotify.Create, fsnotify.Write} {
+		ev.Op = op
+		c.onFileChanged(c.o.CAPath, caFileDescription, ev)
+	}
+	g.Expect(c.queue.Len()).Should(Equal(1))
+
+	g.Expect(c.processNextWorkItem()).Should(BeTrue())
+	g.Expect(c.reconcileDoneCh).Should(HaveLen(1))
+	g.Expect(c.summary.reconciles).Should(Equal(1))
+	g.Expect(c.queue.Len()).Should(Equal(0))
+	g.Expect(c.keyedRequest(fileReconcileKey(c.o.CAPath)).String()).Should(ContainSubstring("WRITE"))
+}
+
+func TestDesiredWebhooks(t *testing.T) {
+	g := NewGomegaWithT(t)
+	c := createTestController(t)
+	c.ClearActions()
+
+	webhooks, err := c.DesiredWebhooks()
+	g.Expect(err).Should(Succeed())
+	g.Expect(webhooks).Should(Equal(webhookConfigWithCABundle0.Webhooks))
+	for _, webhook := range webhooks {
+		g.Expect(webhook.ClientConfig.CABundle).Should(Equal(caBundle0))
+	}
+	g.Expect(c.Actions()).Should(BeEmpty(), "nothing should be written")
+
+	c.injectedMu.Lock()
+	c.injectedCABundle = []byte("bad caBundle")
+	c.injectedMu.Unlock()
+	_, err = c.DesiredWebhooks()
+	g.Expect(err).Should(BeAssignableToTypeOf(&configError{}))
+	g.Expect(err.(*configError).Reason()).Should(Equal("could not verify caBundle"))
+}
+
+func TestFileEventAfterDesiredWebhooks(t *testing.T) {
+	g := NewGomegaWithT(t)
+	c := createTestController(t)
+	c.endpointStore.Add(istiodEndpoint)
+	reconcileHelper(t, c)
+
+	// the rotated CA is read outside a reconcile before its event arrives.
+	c.injectedMu.Lock()
+	c.injectedCABundle = caBundle1
+	c.injectedMu.Unlock()
+	_, err := c.DesiredWebhooks()
+	g.Expect(err).Should(Succeed())
+
+	// the filewatcher only sends events for content changes.
+	c.onFileChanged(c.o.CAPath, caFileDescription, fsnotify.Event{Name: c.o.CAPath, Op: fsnotify.Write})
+	g.Expect(c.queue.Len()).Should(Equal(1), "the CA change must still be reconciled")
+}
+
+func TestFailurePolicyOverride(t *testing.T) {
+	g := NewGomegaWithT(t)
+
+	ignore := kubeApiAdmission.Ignore
+	template := unpatchedIstiodWebhookConfig.DeepCopyObject().(*kubeApiAdmission.ValidatingWebhookConfiguration)
+	template.Webhooks[0].FailurePolicy = &ignore
+	template.Webhooks[1].FailurePolicy = nil
+	encoded := []byte(runtime.EncodeOrDie(codec, template))
+
+	policies := func(o Options) []kubeApiAdmission.FailurePolicyType {
+		t.Helper()
+		config, err := buildValidatingWebhookConfiguration(o, caBundle0, nil, encoded, nil)
+		g.Expect(err).Should(Succeed())
+		var policies []kubeApiAdmission.FailurePolicyType
+		for _, webhook := range config.Webhooks {
+			policies = append(policies, *webhook.FailurePolicy)
+		}
+		return policies
+	}
+
+	// the template's policy is kept and a missing policy defaults to Fail.
+	g.Expect(policies(Options{})).Should(Equal([]kubeApiAdmission.FailurePolicyType{
+		kubeApiAdmission.Ignore, kubeApiAdmission.Fail}))
+
+	o := Options{FailurePolicyOverride: &ignore}
+	g.Expect(policies(o)).Should(Equal([]kubeApiAdmission.FailurePolicyType{
+		kubeApiAdmission.Ignore, kubeApiAdmission.Ignore}))
+
+	fail := kubeApiAdmission.Fail
+	o.FailurePolicyOverride = &fail
+	g.Expect(policies(o)).Should(Equal([]kubeApiAdmission.FailurePolicyType{
+		kubeApiAdmission.Fail, kubeApiAdmission.Fail}))
+
+	o = createTestController(t).o
+	o.FailurePolicyOverride = &ignore
+	g.Expect(o.Validate()).Should(Succeed())
+
+	invalid := kubeApiAdmission.FailurePolicyType("Sometimes")
+	o.FailurePolicyOverride = &invalid
+	g.Expect(o.Validate()).ShouldNot(Succeed())
+}
+
+func TestWebhookConfigSelector(t *testing.T) {
+	ownerLabels := map[string]string{"tenant-owner": "istio"}
+	selector := kubeLabels.SelectorFromSet(ownerLabels)
+
+	newConfig := func(name string, labels map[string]string) *kubeApiAdmission.ValidatingWebhookConfiguration {
+		config := unpatchedIstiodWebhookConfig.DeepCopyObject().(*kubeApiAdmission.ValidatingWebhookConfiguration)
+		config.Name = name
+		config.Labels = labels
+		return config
+	}
+	tenantA := newConfig("tenant-a-x7k2p", ownerLabels)
+	tenantB := newConfig("tenant-b-9qz4m", ownerLabels)
+	other := newConfig("other", map[string]string{"tenant-owner": "someone-else"})
+
+	t.Run("handler", func(t *testing.T) {
+		g := NewGomegaWithT(t)
+		cases := []struct {
+			name       string
+			newHandler func(queue workqueue.Interface) *cache.ResourceEventHandlerFuncs
+			want       int
+		}{
+			{
+				name: "by name",
+				newHandler: func(queue workqueue.Interface) *cache.ResourceEventHandlerFuncs {
+					return makeHandler(queue, newFakeMetricsReporter(), configGVK, galleyWebhookName, other.Name)
+				},
+				want: 1,
+			},
+			{
+				name: "by selector",
+				newHandler: func(queue workqueue.Interface) *cache.ResourceEventHandlerFuncs {
+					return makeMatchingHandler(queue, newFakeMetricsReporter(), configGVK, matchSelector(selector))
+				},
+				want: 2,
+			},
+		}
+		for _, tc := range cases {
+			queue := workqueue.New()
+			handler := tc.newHandler(queue)
+			for _, config := range []*kubeApiAdmission.ValidatingWebhookConfiguration{tenantA, tenantB, other} {
+				handler.OnAdd(config)
+			}
+			g.Expect(queue.Len()).Should(Equal(tc.want), tc.name)
+		}
+	})
+
+	t.Run("reconcile", func(t *testing.T) {
+		g := NewGomegaWithT(t)
+		c := createTestController(t, func(o *Options) {
+			o.WebhookConfigName = ""
+			o.WebhookConfigSelector = selector
+		})
+		g.Expect(c.o.Validate()).Should(Succeed())
+		c.endpointStore.Add(istiodEndpoint)
+		for _, config := range []*kubeApiAdmission.ValidatingWebhookConfiguration{tenantA, tenantB, other} {
+			_, err := c.ValidatingWebhookConfigurations().Create(config)
+			g.Expect(err).Should(Succeed())
+			_ = c.configStore.Add(config)
+		}
+
+		reconcileHelper(t, c)
+		for _, name := range []string{tenantA.Name, tenantB.Name} {
+			patched, err := c.ValidatingWebhookConfigurations().Get(name, kubeApisMeta.GetOptions{})
+			g.Expect(err).Should(Succeed())
+			g.Expect(patched.Labels).Should(HaveKeyWithValue("tenant-owner", "istio"))
+			for _, webhook := range patched.Webhooks {
+				g.Expect(webhook.ClientConfig.CABundle).Should(Equal(caBundle0), name)
+			}
+		}
+		unmatched, err := c.ValidatingWebhookConfigurations().Get(other.Name, kubeApisMeta.GetOptions{})
+		g.Expect(err).Should(Succeed())
+		g.Expect(unmatched).Should(Equal(other))
+		_, err = c.ValidatingWebhookConfigurations().Get(galleyWebhookName, kubeApisMeta.GetOptions{})
+		g.Expect(kubeErrors.IsNotFound(err)).Should(BeTrue(), "the template's config should not be created")
+	})
+
+	t.Run("endpoint ready timeout", func(t *testing.T) {
+		g := NewGomegaWithT(t)
+		c := createTestController(t, func(o *Options) {
+			o.WebhookConfigName = ""
+			o.WebhookConfigSelector = selector
+			o.EndpointReadyTimeout = time.Minute
+		})
+		fakeClock := clock.NewFakeClock(testNow)
+		c.clock = fakeClock
+		for _, config := range []*kubeApiAdmission.ValidatingWebhookConfiguration{tenantA, tenantB, other} {
+			_ = c.configStore.Add(config)
+		}
+
+		reconcileHelper(t, c)
+		fakeClock.Step(time.Minute)
+		reconcileHelper(t, c)
+		// an event on each matching config.
+		g.Expect(c.recorder.Events).Should(HaveLen(2))
+	})
+
+	t.Run("validate", func(t *testing.T) {
+		g := NewGomegaWithT(t)
+		o := createTestController(t).o
+		o.WebhookConfigSelector = kubeLabels.Everything()
+		g.Expect(o.Validate()).ShouldNot(Succeed())
+		o.WebhookConfigSelector = selector
+		o.WebhookConfigNames = []string{galleyWebhookName}
+		g.Expect(o.Validate()).ShouldNot(Succeed())
+	})
+}
+
+func TestEndpointNotReadyReasons(t *testing.T) {
+	g := NewGomegaWithT(t)
+	reporter := newFakeMetricsReporter()
+	c := createTestController(t, func(o *Options) {
+		o.MetricsReporter = reporter
+	})
+
+	reconcileHelper(t, c)
+
+	endpoint := istiodEndpoint.DeepCopy()
+	endpoint.Subsets = nil
+	c.endpointStore.Add(endpoint)
+	reconcileHelper(t, c)
+
+	endpoint = istiodEndpoint.DeepCopy()
+	endpoint.Subsets = []kubeApiCore.EndpointSubset{{NotReadyAddresses: []kubeApiCore.EndpointAddress{{IP: "192.168.1.1"}}}}
+	c.endpointStore.Update(endpoint)
+	reconcileHelper(t, c)
+
+	g.Expect(reporter.notReady).Should(Equal([]string{endpointNotFound, endpointNoSubsets, endpointNoAddressesReady}))
+	g.Expect(c.Actions()).Should(BeEmpty())
+
+	c.endpointStore.Update(istiodEndpoint)
+	reconcileHelper(t, c)
+	g.Expect(reporter.notReady).Should(HaveLen(3))
+}
+
+func TestResync(t *testing.T) {
+	g := NewGomegaWithT(t)
+	c := createTestController(t)
+	fakeClock := c.clock.(*clock.FakeClock)
+	c.endpointStore.Add(istiodEndpoint)
+
+	stop := make(chan struct{})
+	defer close(stop)
+	go c.runResync(c.o.ResyncPeriod, stop)
+
+	g.Eventually(fakeClock.HasWaiters).Should(BeTrue())
+	g.Expect(c.queue.Len()).Should(Equal(0))
+	fakeClock.Step(c.o.ResyncPeriod - time.Second)
+	g.Consistently(c.queue.Len, 100*time.Millisecond).Should(Equal(0))
+	fakeClock.Step(time.Second)
+	g.Eventually(c.queue.Len).Should(Equal(1))
+
+	c.ClearActions()
+	g.Expect(c.processNextWorkItem()).Should(BeTrue())
+	g.Expect(c.reconcileDoneCh).Should(HaveLen(1))
+	_, err := c.ValidatingWebhookConfigurations().Get(galleyWebhookName, kubeApisMeta.GetOptions{})
+	g.Expect(err).Should(Succeed())
+
+	// the next resync is scheduled once the previous one is enqueued.
+	g.Eventually(fakeClock.HasWaiters).Should(BeTrue())
+	fakeClock.Step(c.o.ResyncPeriod)
+	g.Eventually(c.queue.Len).Should(Equal(1))
+}
+
+func TestSecondsSinceLastSuccess(t *testing.T) {
+	g := NewGomegaWithT(t)
+	reporter := newFakeMetricsReporter()
+	c := createTestController(t, func(o *Options) {
+		o.MetricsReporter = reporter
+	})
+	fakeClock := c.clock.(*clock.FakeClock)
+	c.endpointStore.Add(istiodEndpoint)
+	sinceSuccess := func() time.Duration {
+		reporter.mu.Lock()
+		defer reporter.mu.Unlock()
+		return reporter.sinceSuccess[galleyWebhookName]
+	}
+
+	stop := make(chan struct{})
+	defer close(stop)
+	go c.runLastSuccessReporter(stop)
+
+	reconcileHelper(t, c)
+	g.Expect(reporter.sinceSuccess).Should(HaveKeyWithValue(galleyWebhookName, time.Duration(0)))
+	c.configStore.Add(webhookConfigWithCABundle0)
+
+	// increases while reconciles don't succeed.
+	for i := 1; i <= 3; i++ {
+		g.Eventually(fakeClock.HasWaiters).Should(BeTrue())
+		fakeClock.Step(lastSuccessReportInterval)
+		g.Eventually(sinceSuccess).Should(Equal(time.Duration(i) * lastSuccessReportInterval))
+	}
+
+	// reset by the next success.
+	reconcileHelper(t, c)
+	g.Expect(sinceSuccess()).Should(Equal(time.Duration(0)))
+	g.Eventually(fakeClock.HasWaiters).Should(BeTrue())
+	fakeClock.Step(lastSuccessReportInterval)
+	g.Eventually(sinceSuccess).Should(Equal(lastSuccessReportInterval))
+}
+
+func TestWorkers(t *testing.T) {
+	g := NewGomegaWithT(t)
+	const workers = 3
+	c := createTestController(t, func(o *Options) {
+		o.Workers = workers
+	})
+	defer c.Stop()
+
+	// each of the first reconciles waits until all workers are reconciling.
+	var entered int32
+	allEntered := make(chan struct{})
+	c.reconcileDone = func() {
+		n := atomic.AddInt32(&entered, 1)
+		if n == workers {
+			close(allEntered)
+		}
+		if n <= workers {
+			select {
+			case <-allEntered:
+			case <-time.After(10 * time.Second):
+				t.Error("reconciles of distinct keys did not run concurrently")
+			}
+		}
+	}
+
+	c.startWorkers(c.options())
+	c.enqueueKeyed(fileReconcileKey(caPath), "ca changed")
+	c.enqueueKeyed(fileReconcileKey(configPath), "config changed")
+	c.enqueueKeyed(resyncReconcileKey, "periodic resync")
+
+	g.Eventually(func() int32 { return atomic.LoadInt32(&entered) }, 15*time.Second).Should(Equal(int32(workers + 1)))
+	g.Eventually(c.queue.Len).Should(Equal(0))
+	g.Expect(Options{}.workers()).Should(Equal(1))
+}
+
+func TestValidateCheckFilesExist(t *testing.T) {
+	g := NewGomegaWithT(t)
+
+	dir, err := ioutil.TempDir("", "validate-check-files")
+	g.Expect(err).Should(Succeed())
+	defer func() { _ = os.RemoveAll(dir) }()
+
+	write := func(name string, mode os.FileMode) string {
+		path := filepath.Join(dir, name)
+		g.Expect(ioutil.WriteFile(path, []byte("contents"), mode)).Should(Succeed())
+		return path
+	}
+	good := createTestController(t).o
+	good.CAPath = write("ca.pem", 0644)
+	good.WebhookConfigPath = write("config.yaml", 0644)
+	good.CheckFilesExist = true
+	g.Expect(good.Validate()).Should(Succeed())
+
+	missing := good
+	missing.CAPath = filepath.Join(dir, "missing.pem")
+	err = missing.Validate()
+	g.Expect(err).Should(HaveOccurred())
+	g.Expect(err.Error()).Should(ContainSubstring(missing.CAPath))
+
+	directory := good
+	directory.WebhookConfigPath = dir
+	err = directory.Validate()
+	g.Expect(err).Should(HaveOccurred())
+	g.Expect(err.Error()).Should(ContainSubstring(dir))
+
+	// the files aren't checked unless requested.
+	missing.CheckFilesExist = false
+	g.Expect(missing.Validate()).Should(Succeed())
+
+	t.Run("unreadable", func(t *testing.T) {
+		if os.Geteuid() == 0 {
+			t.Skip("file permissions are not enforced for root")
+		}
+		g := NewGomegaWithT(t)
+		unreadable := good
+		unreadable.CAPath = write("unreadable.pem", 0)
+		err := unreadable.Validate()
+		g.Expect(err).Should(HaveOccurred())
+		g.Expect(err.Error()).Should(ContainSubstring(unreadable.CAPath))
+	})
+}
+
+func TestPauseAnnotation(t *testing.T) {
+	g := NewGomegaWithT(t)
+	reporter := newFakeMetricsReporter()
+	c := createTestController(t, func(o *Options) {
+		o.MetricsReporter = reporter
+	})
+	c.endpointStore.Add(istiodEndpoint)
+
+	reconcileHelper(t, c)
+	installed, err := c.ValidatingWebhookConfigurations().Get(galleyWebhookName, kubeApisMeta.GetOptions{})
+	g.Expect(err).Should(Succeed())
+
+	paused := installed.DeepCopy()
+	paused.Annotations = map[string]string{pauseAnnotation: "true"}
+	_, err = c.ValidatingWebhookConfigurations().Update(paused)
+	g.Expect(err).Should(Succeed())
+	_ = c.configStore.Add(paused)
+
+	// the template changes while paused.
+	template := unpatchedIstiodWebhookConfig.DeepCopyObject().(*kubeApiAdmission.ValidatingWebhookConfiguration)
+	template.Webhooks[0].Rules[0].Operations = []kubeApiAdmission.OperationType{kubeApiAdmission.Create}
+	c.injectedMu.Lock()
+	c.injectedConfig = []byte(runtime.EncodeOrDie(codec, template))
+	c.injectedMu.Unlock()
+	c.cache.reset()
+
+	reconcileHelper(t, c)
+	g.Expect(c.Actions()).Should(BeEmpty())
+	g.Expect(reporter.skipped).Should(Equal(map[string]int{"paused " + galleyWebhookName: 1}))
+
+	resumed := paused.DeepCopy()
+	resumed.Annotations[pauseAnnotation] = "false"
+	_ = c.configStore.Update(resumed)
+	reconcileHelper(t, c)
+	g.Expect(c.Actions()).Should(HaveLen(1))
+	g.Expect(c.Actions()[0].Matches("update", "validatingwebhookconfigurations")).Should(BeTrue())
+}
+
+func TestStartWithErrorCacheSyncFailure(t *testing.T) {
+	g := NewGomegaWithT(t)
+	c := createTestController(t)
+	c.PrependReactor("list", "validatingwebhookconfigurations",
+		func(action k8stesting.Action) (bool, runtime.Object, error) {
+			return true, nil, errors.New("unavailable")
+		})
+
+	stop := make(chan struct{})
+	time.AfterFunc(200*time.Millisecond, func() { close(stop) })
+	err := c.StartWithError(stop)
+	g.Expect(err).Should(HaveOccurred())
+	g.Expect(err.Error()).Should(ContainSubstring("ValidatingWebhookConfiguration"))
+	g.Expect(c.queue.ShuttingDown()).Should(BeTrue(), "the controller should be stopped")
+}
+
+func TestEmptyWebhooks(t *testing.T) {
+	g := NewGomegaWithT(t)
+
+	empty := unpatchedIstiodWebhookConfig.DeepCopyObject().(*kubeApiAdmission.ValidatingWebhookConfiguration)
+	empty.Webhooks = nil
+	encoded := []byte(runtime.EncodeOrDie(codec, empty))
+
+	_, err := buildValidatingWebhookConfiguration(Options{}, caBundle0, nil, encoded, nil)
+	g.Expect(err).Should(HaveOccurred())
+	g.Expect(err.(*configError).Reason()).Should(Equal("no webhooks in config"))
+
+	config, err := buildValidatingWebhookConfiguration(Options{AllowEmptyWebhooks: true}, caBundle0, nil, encoded, nil)
+	g.Expect(err).Should(Succeed())
+	g.Expect(config.Webhooks).Should(BeEmpty())
+
+	config, err = buildValidatingWebhookConfiguration(Options{}, caBundle0, nil, []byte(istiodWebhookConfigEncoded), nil)
+	g.Expect(err).Should(Succeed())
+	g.Expect(config.Webhooks).Should(HaveLen(len(unpatchedIstiodWebhookConfig.Webhooks)))
+}
+
+func TestCABundleShrink(t *testing.T) {
+	// the new bundle looks like a truncated rotation bundle which still
+	// holds a valid certificate.
+	rotating := bytes.Join([][]byte{caBundle0, caBundle1}, []byte("\n"))
+
+	for _, allow := range []bool{false, true} {
+		t.Run(fmt.Sprintf("allow=%v", allow), func(t *testing.T) {
+			g := NewGomegaWithT(t)
+			reporter := newFakeMetricsReporter()
+			c := createTestController(t, func(o *Options) {
+				o.AllowCABundleShrink = allow
+				o.MetricsReporter = reporter
+			})
+			c.endpointStore.Add(istiodEndpoint)
+
+			c.injectedMu.Lock()
+			c.injectedCABundle = rotating
+			c.injectedMu.Unlock()
+			reconcileHelper(t, c)
+			installed, err := c.ValidatingWebhookConfigurations().Get(galleyWebhookName, kubeApisMeta.GetOptions{})
+			g.Expect(err).Should(Succeed())
+			_ = c.configStore.Add(installed)
+
+			c.injectedMu.Lock()
+			c.injectedCABundle = caBundle1
+			c.injectedMu.Unlock()
+			reconcileHelper(t, c)
+			writes := len(c.Actions())
+
+			current, err := c.ValidatingWebhookConfigurations().Get(galleyWebhookName, kubeApisMeta.GetOptions{})
+			g.Expect(err).Should(Succeed())
+			if allow {
+				g.Expect(current.Webhooks[0].ClientConfig.CABundle).Should(Equal(caBundle1))
+				g.Expect(reporter.shrinkRefused).Should(BeEmpty())
+			} else {
+				g.Expect(writes).Should(Equal(0))
+				g.Expect(current.Webhooks[0].ClientConfig.CABundle).Should(Equal(rotating))
+				g.Expect(reporter.shrinkRefused).Should(Equal(map[string]int{galleyWebhookName: 1}))
+			}
+		})
+	}
+}
+
+func TestInformerEventMetrics(t *testing.T) {
+	g := NewGomegaWithT(t)
+	reporter := newFakeMetricsReporter()
+	queue := workqueue.New()
+
+	configHandler := makeHandler(queue, reporter, configGVK, galleyWebhookName)
+	endpointHandler := makeHandler(queue, reporter, endpointGVK, istiod)
+
+	other := webhookConfigWithCABundle0.DeepCopy()
+	other.Name = "other"
+	configHandler.OnAdd(webhookConfigWithCABundle0)
+	configHandler.OnAdd(other)
+	configHandler.OnUpdate(other, other)
+	configHandler.OnDelete(other)
+
+	otherEndpoint := istiodEndpoint.DeepCopy()
+	otherEndpoint.Name = "other"
+	endpointHandler.OnAdd(istiodEndpoint)
+	endpointHandler.OnUpdate(istiodEndpoint, istiodEndpoint)
+	endpointHandler.OnAdd(otherEndpoint)
+
+	g.Expect(reporter.informerEvents).Should(Equal(map[string]int{
+		configGVK.Kind:   4,
+		endpointGVK.Kind: 3,
+	}))
+	g.Expect(reporter.informerSkipped).Should(Equal(map[string]int{
+		configGVK.Kind:   3,
+		endpointGVK.Kind: 1,
+	}))
+	// only the add of each watched object is queued since the update is unchanged.
+	g.Expect(queue.Len()).Should(Equal(2))
+}
+
+func TestInformerTombstones(t *testing.T) {
+	g := NewGomegaWithT(t)
+	reporter := newFakeMetricsReporter()
+	queue := workqueue.New()
+	handler := makeHandler(queue, reporter, configGVK, galleyWebhookName)
+
+	handler.OnDelete(cache.DeletedFinalStateUnknown{
+		Key: galleyWebhookName,
+		Obj: webhookConfigWithCABundle0,
+	})
+	g.Expect(queue.Len()).Should(Equal(1), "the tombstone of a watched object should be reconciled")
+	g.Expect(reporter.decodeErrors).Should(BeEmpty())
+
+	malformed := cache.DeletedFinalStateUnknown{Key: galleyWebhookName, Obj: "not an object"}
+	handler.OnDelete(malformed)
+	handler.OnAdd(malformed)
+	handler.OnUpdate(webhookConfigWithCABundle0, "not an object")
+	g.Expect(reporter.decodeErrors).Should(Equal(map[string]int{configGVK.Kind: 3}))
+	g.Expect(reporter.informerEvents).Should(Equal(map[string]int{configGVK.Kind: 1}))
+	g.Expect(queue.Len()).Should(Equal(1))
+}
+
+func TestServicePortChanged(t *testing.T) {
+	g := NewGomegaWithT(t)
+	c := createTestController(t)
+
+	prev := &kubeApiCore.Service{
+		ObjectMeta: kubeApiMeta.ObjectMeta{Name: istiod, Namespace: namespace},
+		Spec: kubeApiCore.ServiceSpec{
+			Selector: map[string]string{"app": "istiod"},
+			Ports:    []kubeApiCore.ServicePort{{Name: "https-webhook", Port: 443}},
+		},
+	}
+	changed := prev.DeepCopy()
+	changed.Spec.Ports[0].Port = 8443
+
+	c.onServiceUpdate(prev, prev.DeepCopy())
+	g.Expect(c.queue.Len()).Should(Equal(0))
+	c.onServiceUpdate(prev, changed)
+	g.Expect(c.queue.Len()).Should(Equal(1))
+
+	config := webhookConfigWithCABundle0.DeepCopy()
+	g.Expect(servicePortMismatches(config, prev)).Should(BeEmpty())
+	g.Expect(servicePortMismatches(config, changed)).Should(Equal([]string{"hook0 (port 443)", "hook1 (port 443)"}))
+	port := int32(8443)
+	for i := range config.Webhooks {
+		config.Webhooks[i].ClientConfig.Service.Port = &port
+	}
+	g.Expect(servicePortMismatches(config, changed)).Should(BeEmpty())
+}
+
+func TestReconcileCallbacks(t *testing.T) {
+	g := NewGomegaWithT(t)
+	var failed, succeeded []string
+	var errs []error
+	c := createTestController(t, func(o *Options) {
+		o.OnReconcileError = func(req string, err error) {
+			failed = append(failed, req)
+			errs = append(errs, err)
+		}
+		o.OnReconcileSuccess = func(req string) {
+			succeeded = append(succeeded, req)
+		}
+	})
+	c.endpointStore.Add(istiodEndpoint)
+	c.configStore.Add(galleyWebhookConfigWithCABundle1)
+	_, err := c.ValidatingWebhookConfigurations().Create(galleyWebhookConfigWithCABundle1)
+	g.Expect(err).Should(Succeed())
+
+	updateErr := kubeErrors.NewInternalError(errors.New("fake update error"))
+	c.PrependReactor("update", "validatingwebhookconfigurations",
+		func(action k8stesting.Action) (bool, runtime.Object, error) {
+			return true, nil, updateErr
+		})
+
+	c.queue.Add(&reconcileRequest{description: "failing"})
+	g.Expect(c.processNextWorkItem()).Should(BeTrue())
+	g.Expect(failed).Should(Equal([]string{"failing"}))
+	g.Expect(errs).Should(HaveLen(1))
+	g.Expect(kubeErrors.ReasonForError(errs[0])).Should(Equal(kubeApiMeta.StatusReasonInternalError))
+	g.Expect(succeeded).Should(BeEmpty())
+
+	// the failed request is retried.
+	c.ReactionChain = c.ReactionChain[1:]
+	g.Expect(c.processNextWorkItem()).Should(BeTrue())
+	g.Expect(succeeded).Should(Equal([]string{"failing"}))
+	g.Expect(failed).Should(HaveLen(1))
+}
+
+func TestFileWatcherReleased(t *testing.T) {
+	g := NewGomegaWithT(t)
+
+	for i := 0; i < 5; i++ {
+		c := createTestController(t)
+		stop := make(chan struct{})
+		g.Expect(c.StartWithError(stop)).Should(Succeed())
+		g.Expect(c.fakeWatcher.Events(caPath)).ShouldNot(BeNil())
+
+		close(stop)
+		g.Eventually(func() chan fsnotify.Event { return c.fakeWatcher.Events(caPath) }).Should(BeNil())
+		g.Expect(c.fakeWatcher.Events(configPath)).Should(BeNil())
+		// stopping again is a no-op.
+		c.Stop()
+	}
+
+	// the paths added before a watch fails are released.
+	newFileWatcher, fakeWatcher := filewatcher.NewFakeWatcher(nil)
+	o := createTestController(t).o
+	o.AdditionalCAPaths = []string{o.WebhookConfigPath}
+	_, err := newController(o, newFileWatcher, ioutil.ReadFile, newSharedInformerFactory, newFakeKubeClient, nil)
+	g.Expect(err).Should(HaveOccurred())
+	g.Expect(fakeWatcher.Events(o.WebhookConfigPath)).Should(BeNil())
+	g.Expect(fakeWatcher.Events(o.CAPath)).Should(BeNil())
+}
+
+func TestObjectSelector(t *testing.T) {
+	g := NewGomegaWithT(t)
+
+	fromTemplate := &kubeApiMeta.LabelSelector{MatchLabels: map[string]string{"from": "template"}}
+	template := unpatchedIstiodWebhookConfig.DeepCopyObject().(*kubeApiAdmission.ValidatingWebhookConfiguration)
+	template.Webhooks[0].ObjectSelector = fromTemplate
+	encoded := []byte(runtime.EncodeOrDie(codec, template))
+
+	// the template's selector is kept.
+	config, err := buildValidatingWebhookConfiguration(Options{}, caBundle0, nil, encoded, nil)
+	g.Expect(err).Should(Succeed())
+	g.Expect(config.Webhooks[0].ObjectSelector).Should(Equal(fromTemplate))
+	g.Expect(config.Webhooks[1].ObjectSelector).Should(BeNil())
+
+	injected := &kubeApiMeta.LabelSelector{MatchLabels: map[string]string{"validate": "true"}}
+	config, err = buildValidatingWebhookConfiguration(Options{ObjectSelector: injected}, caBundle0, nil, encoded, nil)
+	g.Expect(err).Should(Succeed())
+	for _, webhook := range config.Webhooks {
+		g.Expect(webhook.ObjectSelector).Should(Equal(injected))
+	}
+	config.Webhooks[0].ObjectSelector.MatchLabels["validate"] = "false"
+	g.Expect(injected.MatchLabels["validate"]).Should(Equal("true"), "the option should not be shared")
+}
+
+func TestClusterRoleLookupRetried(t *testing.T) {
+	defer func(backoff time.Duration) { clusterRoleLookupBackoff = backoff }(clusterRoleLookupBackoff)
+	clusterRoleLookupBackoff = time.Millisecond
+
+	clusterRole := &kubeApiRbac.ClusterRole{
+		ObjectMeta: kubeApiMeta.ObjectMeta{Name: istiodClusterRole, UID: "uid-1"},
+	}
+	newClient := func(failures int32) (*fake.Clientset, *int32) {
+		client := fake.NewSimpleClientset(clusterRole)
+		var gets int32
+		client.PrependReactor("get", "clusterroles", func(action k8stesting.Action) (bool, runtime.Object, error) {
+			if atomic.AddInt32(&gets, 1) <= failures {
+				return true, nil, kubeErrors.NewServiceUnavailable("apiserver starting")
+			}
+			return false, nil, nil
+		})
+		return client, &gets
+	}
+
+	cases := []struct {
+		name          string
+		failures      int32
+		retries       int
+		wantGets      int32
+		wantOwnerRefs bool
+	}{
+		{"succeeds after failures", 3, 0, 4, true},
+		{"budget exhausted", 3, 2, 3, false},
+		{"budget sufficient", 2, 2, 3, true},
+	}
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			g := NewGomegaWithT(t)
+			client, gets := newClient(tc.failures)
+			c := createTestController(t, func(o *Options) {
+				o.Client = client
+				o.ClusterRoleLookupRetries = tc.retries
+			})
+			g.Expect(atomic.LoadInt32(gets)).Should(Equal(tc.wantGets))
+			if tc.wantOwnerRefs {
+				g.Expect(c.currentOwnerRefs()).Should(Equal(clusterRoleOwnerRefs(clusterRole)))
+			} else {
+				g.Expect(c.currentOwnerRefs()).Should(BeEmpty())
+			}
+		})
+	}
+
+	g := NewGomegaWithT(t)
+	o := createTestController(t).o
+	g.Expect(o.Validate()).Should(Succeed())
+	o.ClusterRoleLookupRetries = -1
+	g.Expect(o.Validate()).ShouldNot(Succeed())
+}
+
+func TestBuildValidatingWebhookConfiguration(t *testing.T) {
+	g := NewGomegaWithT(t)
+
+	ownerRefs := clusterRoleOwnerRefs(&kubeApiRbac.ClusterRole{
+		ObjectMeta: kubeApiMeta.ObjectMeta{Name: istiodClusterRole, UID: "uid-1"},
+	})
+	config, err := BuildValidatingWebhookConfiguration(caBundle0, []byte(istiodWebhookConfigEncoded), ownerRefs)
+	g.Expect(err).Should(Succeed())
+	g.Expect(config.Name).Should(Equal(galleyWebhookName))
+	g.Expect(config.OwnerReferences).Should(Equal(ownerRefs))
+	for _, webhook := range config.Webhooks {
+		g.Expect(webhook.ClientConfig.CABundle).Should(Equal(caBundle0))
+	}
+
+	want, err := buildValidatingWebhookConfiguration(Options{}, caBundle0, nil, []byte(istiodWebhookConfigEncoded), ownerRefs)
+	g.Expect(err).Should(Succeed())
+	g.Expect(config).Should(Equal(want))
+
+	_, err = BuildValidatingWebhookConfiguration([]byte("not a caBundle"), []byte(istiodWebhookConfigEncoded), nil)
+	g.Expect(err).ShouldNot(Succeed())
+	_, err = BuildValidatingWebhookConfiguration(caBundle0, []byte("not a config"), nil)
+	g.Expect(err).ShouldNot(Succeed())
+}
+
+func TestLogLevel(t *testing.T) {
+	g := NewGomegaWithT(t)
+
+	dir, err := ioutil.TempDir("", "log-level")
+	g.Expect(err).Should(Succeed())
+	defer func() { _ = os.RemoveAll(dir) }()
+
+	logFile := filepath.Join(dir, "controller.log")
+	options := log.DefaultOptions()
+	options.OutputPaths = []string{logFile}
+	g.Expect(log.Configure(options)).Should(Succeed())
+	defer func(level log.Level) {
+		scope.SetOutputLevel(level)
+		_ = log.Configure(log.DefaultOptions())
+	}(scope.GetOutputLevel())
+
+	scope.SetOutputLevel(log.InfoLevel)
+	c := createTestController(t)
+	g.Expect(scope.GetOutputLevel()).Should(Equal(log.InfoLevel), "the level should be left unchanged by default")
+
+	debug := log.DebugLevel
+	c = createTestController(t, func(o *Options) {
+		o.LogLevel = &debug
+	})
+	g.Expect(scope.DebugEnabled()).Should(BeTrue())
+	makeHandler(c.queue, c.metrics, endpointGVK, istiod).OnAdd(istiodEndpoint)
+	_ = log.Sync()
+
+	contents, err := ioutil.ReadFile(logFile)
+	g.Expect(err).Should(Succeed())
+	g.Expect(string(contents)).Should(ContainSubstring("debug\tvalidationController\tHandlerAdd: "))
+}
+
+func TestRefuseUnowned(t *testing.T) {
+	clusterRole := &kubeApiRbac.ClusterRole{
+		ObjectMeta: kubeApiMeta.ObjectMeta{Name: istiodClusterRole, UID: "uid-1"},
+	}
+	// left over from an older install, without owner references or labels.
+	legacy := webhookConfigWithCABundle0.DeepCopyObject().(*kubeApiAdmission.ValidatingWebhookConfiguration)
+	legacy.Labels = nil
+
+	setup := func(t *testing.T, refuse bool) (*fakeController, *fakeMetricsReporter) {
+		reporter := newFakeMetricsReporter()
+		c := createTestController(t, func(o *Options) {
+			o.MetricsReporter = reporter
+			o.RefuseUnowned = refuse
+		})
+		c.endpointStore.Add(istiodEndpoint)
+		c.clusterRoleStore.Add(clusterRole)
+		_, err := c.ValidatingWebhookConfigurations().Create(legacy)
+		if err != nil {
+			t.Fatal(err)
+		}
+		c.configStore.Add(legacy)
+		c.injectedMu.Lock()
+		c.injectedCABundle = caBundle1
+		c.injectedMu.Unlock()
+		return c, reporter
+	}
+
+	t.Run("refuse", func(t *testing.T) {
+		g := NewGomegaWithT(t)
+		c, reporter := setup(t, true)
+
+		reconcileHelper(t, c)
+		g.Expect(c.Actions()).Should(BeEmpty())
+		g.Expect(reporter.updateErrors[galleyWebhookName]).Should(Equal([]kubeApiMeta.StatusReason{reasonNotOwned}))
+		g.Expect(c.recorder.Events).Should(Receive(HavePrefix("Warning UpdateFailed Not owned by clusterrole")))
+
+		// owned configs are updated, even if the clusterrole was recreated since.
+		owned := legacy.DeepCopy()
+		owned.OwnerReferences = clusterRoleOwnerRefs(&kubeApiRbac.ClusterRole{
+			ObjectMeta: kubeApiMeta.ObjectMeta{Name: istiodClusterRole, UID: "uid-0"},
+		})
+		c.configStore.Update(owned)
+		reconcileHelper(t, c)
+		g.Expect(c.Actions()[0].Matches("update", "validatingwebhookconfigurations")).Should(BeTrue())
+	})
+
+	t.Run("adopt by default", func(t *testing.T) {
+		g := NewGomegaWithT(t)
+		c, reporter := setup(t, false)
+
+		reconcileHelper(t, c)
+		g.Expect(c.Actions()[0].Matches("update", "validatingwebhookconfigurations")).Should(BeTrue())
+		g.Expect(reporter.updateErrors).Should(BeEmpty())
+		adopted, err := c.ValidatingWebhookConfigurations().Get(galleyWebhookName, kubeApisMeta.GetOptions{})
+		g.Expect(err).Should(Succeed())
+		g.Expect(adopted.OwnerReferences).Should(Equal(clusterRoleOwnerRefs(clusterRole)))
+		for _, webhook := range adopted.Webhooks {
+			g.Expect(webhook.ClientConfig.CABundle).Should(Equal(caBundle1))
+		}
+	})
+}
+
+func TestOwnerRefDrift(t *testing.T) {
+	clusterRole := &kubeApiRbac.ClusterRole{
+		ObjectMeta: kubeApiMeta.ObjectMeta{Name: istiodClusterRole, UID: "uid-1"},
+	}
+	ownerRefs := clusterRoleOwnerRefs(clusterRole)
+	notController := false
+	other := kubeApiMeta.OwnerReference{
+		APIVersion: "apps/v1",
+		Kind:       "Deployment",
+		Name:       "someone-else",
+		UID:        "uid-2",
+		Controller: &[]bool{true}[0],
+	}
+
+	cases := []struct {
+		name      string
+		labels    map[string]string
+		drift     func(config *kubeApiAdmission.ValidatingWebhookConfiguration)
+		wantDrift []string
+	}{
+		{
+			name: "extra owner",
+			drift: func(config *kubeApiAdmission.ValidatingWebhookConfiguration) {
+				config.OwnerReferences[0].Controller = &notController
+				config.OwnerReferences = append(config.OwnerReferences, other)
+			},
+			wantDrift: []string{
+				"owner reference to ClusterRole istiod-istio-system is not the controller reference",
+				"unexpected owner reference to Deployment someone-else",
+			},
+		},
+		{
+			name:   "stripped",
+			labels: map[string]string{managedByLabel: managedByValue},
+			drift: func(config *kubeApiAdmission.ValidatingWebhookConfiguration) {
+				config.OwnerReferences = nil
+			},
+			wantDrift: []string{"missing owner reference to ClusterRole istiod-istio-system"},
+		},
+	}
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			g := NewGomegaWithT(t)
+			c := createTestController(t, func(o *Options) {
+				o.ManagedLabels = tc.labels
+			})
+			c.endpointStore.Add(istiodEndpoint)
+			c.clusterRoleStore.Add(clusterRole)
+
+			reconcileHelper(t, c)
+			config, err := c.ValidatingWebhookConfigurations().Get(galleyWebhookName, kubeApisMeta.GetOptions{})
+			g.Expect(err).Should(Succeed())
+			g.Expect(config.OwnerReferences).Should(Equal(ownerRefs))
+			g.Expect(ownerRefDrift(config, ownerRefs)).Should(BeEmpty())
+
+			// the owner references drift out-of-band.
+			tc.drift(config)
+			g.Expect(ownerRefDrift(config, ownerRefs)).Should(Equal(tc.wantDrift))
+			_, err = c.ValidatingWebhookConfigurations().Update(config)
+			g.Expect(err).Should(Succeed())
+			c.configStore.Add(config)
+
+			reconcileHelper(t, c)
+			g.Expect(c.Actions()).Should(HaveLen(1))
+			g.Expect(c.Actions()[0].Matches("update", "validatingwebhookconfigurations")).Should(BeTrue())
+			restored, err := c.ValidatingWebhookConfigurations().Get(galleyWebhookName, kubeApisMeta.GetOptions{})
+			g.Expect(err).Should(Succeed())
+			g.Expect(restored.OwnerReferences).Should(Equal(ownerRefs))
+		})
+	}
+}
+
+func TestEndpointUnready(t *testing.T) {
+	policies := func(g *GomegaWithT, c *fakeController) []kubeApiAdmission.FailurePolicyType {
+		config, err := c.ValidatingWebhookConfigurations().Get(galleyWebhookName, kubeApisMeta.GetOptions{})
+		g.Expect(err).Should(Succeed())
+		c.configStore.Update(config)
+		var policies []kubeApiAdmission.FailurePolicyType
+		for _, webhook := range config.Webhooks {
+			policies = append(policies, *webhook.FailurePolicy)
+		}
+		return policies
+	}
+	fail := []kubeApiAdmission.FailurePolicyType{kubeApiAdmission.Fail, kubeApiAdmission.Fail}
+	ignore := []kubeApiAdmission.FailurePolicyType{kubeApiAdmission.Ignore, kubeApiAdmission.Ignore}
+
+	t.Run("not patched", func(t *testing.T) {
+		g := NewGomegaWithT(t)
+		reporter := newFakeMetricsReporter()
+		c := createTestController(t, func(o *Options) {
+			o.MetricsReporter = reporter
+		})
+		c.endpointStore.Add(istiodEndpoint)
+		reconcileHelper(t, c)
+		g.Expect(policies(g, c)).Should(Equal(fail))
+
+		c.endpointStore.Delete(istiodEndpoint)
+		c.injectedMu.Lock()
+		c.injectedCABundle = caBundle1
+		c.injectedMu.Unlock()
+		reconcileHelper(t, c)
+		g.Expect(c.Actions()).Should(BeEmpty(), "no write while the endpoint is no longer ready")
+		g.Expect(c.summary.state()).Should(Equal("endpoint not ready"))
+		g.Expect(reporter.notReady).Should(HaveLen(1))
+
+		c.endpointStore.Add(istiodEndpoint)
+		reconcileHelper(t, c)
+		g.Expect(c.Actions()[0].Matches("update", "validatingwebhookconfigurations")).Should(BeTrue())
+		config, err := c.ValidatingWebhookConfigurations().Get(galleyWebhookName, kubeApisMeta.GetOptions{})
+		g.Expect(err).Should(Succeed())
+		g.Expect(config.Webhooks[0].ClientConfig.CABundle).Should(Equal(caBundle1))
+		g.Expect(policies(g, c)).Should(Equal(fail))
+	})
+
+	t.Run("not unregistered", func(t *testing.T) {
+		g := NewGomegaWithT(t)
+		c := createTestController(t)
+		c.endpointStore.Add(istiodEndpoint)
+		reconcileHelper(t, c)
+		policies(g, c)
+
+		c.endpointStore.Delete(istiodEndpoint)
+		c.o.UnregisterValidationWebhook = true
+		reconcileHelper(t, c)
+		g.Expect(c.Actions()).Should(BeEmpty(), "the endpoint gate comes first")
+
+		c.endpointStore.Add(istiodEndpoint)
+		reconcileHelper(t, c)
+		g.Expect(c.Actions()[0].Matches("delete", "validatingwebhookconfigurations")).Should(BeTrue())
+	})
+
+	t.Run("mutating fail open", func(t *testing.T) {
+		g := NewGomegaWithT(t)
+		c := createTestController(t, func(o *Options) {
+			o.FailOpenWhenUnready = true
+			o.ManageMutatingWebhook = true
+			o.MutatingWebhookConfigName = mutatingWebhookName
+			o.MutatingWebhookConfigPath = mutatingConfigPath
+		})
+		c.injectedFiles = map[string][]byte{
+			mutatingConfigPath: []byte(runtime.EncodeOrDie(codec, unpatchedMutatingWebhookConfig)),
+		}
+		c.endpointStore.Add(istiodEndpoint)
+		reconcileHelper(t, c)
+		policies(g, c)
+		mutating, err := c.MutatingWebhookConfigurations().Get(mutatingWebhookName, kubeApisMeta.GetOptions{})
+		g.Expect(err).Should(Succeed())
+		c.sharedInformers.Admissionregistration().V1beta1().MutatingWebhookConfigurations().Informer().GetStore().Add(mutating)
+
+		c.endpointStore.Delete(istiodEndpoint)
+		reconcileHelper(t, c)
+		mutating, err = c.MutatingWebhookConfigurations().Get(mutatingWebhookName, kubeApisMeta.GetOptions{})
+		g.Expect(err).Should(Succeed())
+		g.Expect(*mutating.Webhooks[0].FailurePolicy).Should(Equal(kubeApiAdmission.Ignore))
+	})
+
+	t.Run("fail open", func(t *testing.T) {
+		g := NewGomegaWithT(t)
+		c := createTestController(t, func(o *Options) {
+			o.FailOpenWhenUnready = true
+		})
+
+		// not created before the endpoint is first ready.
+		reconcileHelper(t, c)
+		g.Expect(c.Actions()).Should(BeEmpty())
+
+		c.endpointStore.Add(istiodEndpoint)
+		reconcileHelper(t, c)
+		g.Expect(policies(g, c)).Should(Equal(fail))
+
+		c.endpointStore.Delete(istiodEndpoint)
+		reconcileHelper(t, c)
+		g.Expect(c.Actions()[0].Matches("update", "validatingwebhookconfigurations")).Should(BeTrue())
+		g.Expect(policies(g, c)).Should(Equal(ignore))
+
+		c.endpointStore.Add(istiodEndpoint)
+		reconcileHelper(t, c)
+		g.Expect(c.Actions()[0].Matches("update", "validatingwebhookconfigurations")).Should(BeTrue())
+		g.Expect(policies(g, c)).Should(Equal(fail))
+	})
+}
+
+func TestReconcileOnce(t *testing.T) {
+	g := NewGomegaWithT(t)
+
+	c := createTestController(t, func(o *Options) {
+		o.Client = fake.NewSimpleClientset(istiodEndpoint.DeepCopy())
+	})
+	g.Expect(c.ReconcileOnce(context.Background())).Should(Succeed())
+	g.Expect(c.ValidatingWebhookConfigurations().Get(galleyWebhookName, kubeApisMeta.GetOptions{})).
+		Should(Equal(webhookConfigWithCABundle0))
+	g.Consistently(c.reconcileDoneCh, 100*time.Millisecond).Should(HaveLen(1), "the workers should not be started")
+
+	// nothing is installed until the endpoint is ready.
+	c = createTestController(t)
+	err := c.ReconcileOnce(context.Background())
+	g.Expect(err).Should(MatchError("webhook configs not reconciled: endpoint not ready"))
+	_, err = c.ValidatingWebhookConfigurations().Get(galleyWebhookName, kubeApisMeta.GetOptions{})
+	g.Expect(kubeErrors.IsNotFound(err)).Should(BeTrue())
+
+	// a config rejected as invalid isn't retried but isn't installed either.
+	c = createTestController(t, func(o *Options) {
+		o.Client = fake.NewSimpleClientset(istiodEndpoint.DeepCopy())
+	})
+	c.PrependReactor("create", "validatingwebhookconfigurations",
+		func(action k8stesting.Action) (bool, runtime.Object, error) {
+			return true, nil, kubeErrors.NewInvalid(
+				kubeApiAdmission.SchemeGroupVersion.WithKind("ValidatingWebhookConfiguration").GroupKind(),
+				galleyWebhookName, nil)
+		})
+	err = c.ReconcileOnce(context.Background())
+	g.Expect(err).Should(MatchError("webhook configs not reconciled: not applied: " + galleyWebhookName))
+
+	// the queue and the event broadcaster are released.
+	before := goruntime.NumGoroutine()
+	o := c.options()
+	newFileWatcher, _ := filewatcher.NewFakeWatcher(nil)
+	c2, err := newController(o, newFileWatcher, c.readFile, newSharedInformerFactory, newFakeKubeClient, nil)
+	g.Expect(err).Should(Succeed())
+	g.Expect(c2.ReconcileOnce(context.Background())).ShouldNot(Succeed())
+	g.Expect(c2.queue.ShuttingDown()).Should(BeTrue())
+	g.Expect(c2.eventBroadcaster).Should(BeNil())
+	g.Eventually(goruntime.NumGoroutine, 10*time.Second, 10*time.Millisecond).Should(BeNumerically("<=", before),
+		"goroutines started by the controller should exit")
+}
+
+// fakeInformerFactory serves the stores of its informers without starting
+// them, so tests fill the stores directly.
+type fakeInformerFactory struct {
+	informers.SharedInformerFactory
+	started  int
+	unsynced []reflect.Type
+}
+
+func (f *fakeInformerFactory) Start(<-chan struct{}) {
+	f.started++
+}
+
+func (f *fakeInformerFactory) WaitForCacheSync(<-chan struct{}) map[reflect.Type]bool {
+	synced := make(map[reflect.Type]bool)
+	for _, informerType := range f.unsynced {
+		synced[informerType] = false
+	}
+	return synced
+}
+
+func TestInjectedInformerFactory(t *testing.T) {
+	g := NewGomegaWithT(t)
+
+	client := fake.NewSimpleClientset()
+	o := Options{
+		WatchedNamespace:  namespace,
+		CAPath:            caPath,
+		WebhookConfigName: galleyWebhookName,
+		WebhookConfigPath: configPath,
+		ServiceName:       istiod,
+		Client:            client,
+	}
+	readFile := func(filename string) ([]byte, error) {
+		switch filename {
+		case caPath:
+			return caBundle0, nil
+		case configPath:
+			return []byte(istiodWebhookConfigEncoded), nil
+		}
+		return nil, os.ErrNotExist
+	}
+	newFileWatcher, _ := filewatcher.NewFakeWatcher(func(string, bool) {})
+	factories := make(map[string]*fakeInformerFactory)
+	newInformers := func(o Options, namespace string) informerFactory {
+		factory := &fakeInformerFactory{
+			SharedInformerFactory: informers.NewSharedInformerFactoryWithOptions(nil, 0, informers.WithNamespace(namespace)),
+		}
+		factories[namespace] = factory
+		return factory
+	}
+
+	c, err := newController(o, newFileWatcher, readFile, newInformers, newFakeKubeClient, nil)
+	g.Expect(err).Should(Succeed())
+	c.eventBroadcaster.Shutdown()
+	c.eventBroadcaster = nil
+	c.recorder = record.NewFakeRecorder(100)
+	defer c.Stop()
+	g.Expect(factories).Should(HaveLen(1))
+
+	factories[namespace].Core().V1().Endpoints().Informer().GetStore().Add(istiodEndpoint)
+	g.Expect(c.ReconcileOnce(context.Background())).Should(Succeed())
+	g.Expect(factories[namespace].started).Should(Equal(1))
+	g.Expect(client.AdmissionregistrationV1beta1().ValidatingWebhookConfigurations().Get(galleyWebhookName, kubeApisMeta.GetOptions{})).
+		Should(Equal(webhookConfigWithCABundle0))
+
+	factories[namespace].unsynced = []reflect.Type{reflect.TypeOf(&kubeApiCore.Endpoints{})}
+	g.Expect(c.ReconcileOnce(context.Background())).
+		Should(MatchError("informer caches not synced: *v1.Endpoints"))
+}
+
+func TestPreApply(t *testing.T) {
+	g := NewGomegaWithT(t)
+	reporter := newFakeMetricsReporter()
+	matchesKubeSystem := func(config *kubeApiAdmission.ValidatingWebhookConfiguration) error {
+		for _, webhook := range config.Webhooks {
+			selector := webhook.NamespaceSelector
+			if selector == nil || len(selector.MatchLabels)+len(selector.MatchExpressions) == 0 {
+				return fmt.Errorf("webhook %v matches kube-system", webhook.Name)
+			}
+		}
+		return nil
+	}
+	var hooked []string
+	c := createTestController(t, func(o *Options) {
+		o.MetricsReporter = reporter
+		o.PreApply = func(config *kubeApiAdmission.ValidatingWebhookConfiguration) error {
+			hooked = append(hooked, config.Name)
+			return matchesKubeSystem(config)
+		}
+	})
+	c.endpointStore.Add(istiodEndpoint)
+
+	reconcileHelper(t, c)
+	g.Expect(hooked).Should(Equal([]string{galleyWebhookName}))
+	g.Expect(c.Actions()).Should(BeEmpty(), "a rejected config should not be written")
+	g.Expect(reporter.updateErrors[galleyWebhookName]).Should(Equal([]kubeApiMeta.StatusReason{reasonPreApplyRejected}))
+	g.Expect(c.recorder.Events).Should(Receive(ContainSubstring("webhook hook0 matches kube-system")))
+
+	// written once the config excludes kube-system.
+	template := unpatchedIstiodWebhookConfig.DeepCopyObject().(*kubeApiAdmission.ValidatingWebhookConfiguration)
+	for i := range template.Webhooks {
+		template.Webhooks[i].NamespaceSelector = &kubeApiMeta.LabelSelector{MatchLabels: map[string]string{"istio-validation": "enabled"}}
+	}
+	c.injectedMu.Lock()
+	c.injectedConfig = []byte(runtime.EncodeOrDie(codec, template))
+	c.injectedMu.Unlock()
+	reconcileHelper(t, c)
+	g.Expect(c.Actions()[0].Matches("create", "validatingwebhookconfigurations")).Should(BeTrue())
+}
@@ -0,0 +1,147 @@
+// Copyright 2019 Istio Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package controller
+
+import (
+	"encoding/json"
+	"net/http"
+	"time"
+
+	kubeApiAdmission "k8s.io/api/admissionregistration/v1beta1"
+	kubeErrors "k8s.io/apimachinery/pkg/api/errors"
+)
+
+// debugStatus is the status served by the debug handler.
+type debugStatus struct {
+	LastReconcile time.Time `json:"lastReconcile,omitempty"`
+	LastError     string    `json:"lastError,omitempty"`
+	State         string    `json:"state"`
+	InSync        bool      `json:"inSync"`
+	Ready         bool      `json:"ready"`
+}
+
+// DebugHandler returns a handler to introspect and nudge the controller:
+//
+//	POST /reconcile  reconcile and wait for the result
+//	GET  /status     last reconcile time, error and whether the configs are in sync
+//	GET  /desired    the validatingwebhookconfigurations the controller would write
+//	GET  /live       the installed validatingwebhookconfigurations
+//	GET  /trace      the last reconcile trace. POST traces the next reconcile.
+//
+// The paths are relative so the handler can be mounted under a prefix with
+// http.StripPrefix. Reconciles require the controller to be started.
+func (c *Controller) DebugHandler() http.Handler {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/reconcile", c.debugReconcile)
+	mux.HandleFunc("/status", c.debugStatus)
+	mux.HandleFunc("/desired", c.debugDesired)
+	mux.HandleFunc("/live", c.debugLive)
+	mux.HandleFunc("/trace", c.debugTrace)
+	return mux
+}
+
+func (c *Controller) debugReconcile(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "reconcile requires POST", http.StatusMethodNotAllowed)
+		return
+	}
+	result := make(chan error, 1)
+	go func() { result <- c.Reconcile("debug request") }()
+	select {
+	case err := <-result:
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		writeDebugJSON(w, c.status())
+	case <-r.Context().Done():
+		// the reconcile still completes in the background.
+		http.Error(w, r.Context().Err().Error(), http.StatusServiceUnavailable)
+	}
+}
+
+func (c *Controller) debugStatus(w http.ResponseWriter, _ *http.Request) {
+	writeDebugJSON(w, c.status())
+}
+
+func (c *Controller) debugDesired(w http.ResponseWriter, _ *http.Request) {
+	configs, err := c.desiredConfigs(c.options())
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	writeDebugJSON(w, configs)
+}
+
+func (c *Controller) debugLive(w http.ResponseWriter, _ *http.Request) {
+	managed, err := c.managedConfigs(c.options())
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	lister := c.sharedInformers.Admissionregistration().V1beta1().ValidatingWebhookConfigurations().Lister()
+	configs := []*kubeApiAdmission.ValidatingWebhookConfiguration{}
+	for _, config := range managed {
+		current, err := lister.Get(config.name)
+		if kubeErrors.IsNotFound(err) {
+			continue
+		}
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		configs = append(configs, current)
+	}
+	writeDebugJSON(w, configs)
+}
+
+func (c *Controller) debugTrace(w http.ResponseWriter, r *http.Request) {
+	if r.Method == http.MethodPost {
+		c.TraceNextReconcile()
+		w.WriteHeader(http.StatusAccepted)
+		return
+	}
+	trace := c.LastReconcileTrace()
+	if trace == nil {
+		http.Error(w, "no reconcile has been traced", http.StatusNotFound)
+		return
+	}
+	writeDebugJSON(w, trace)
+}
+
+// status returns the outcome of the last reconcile. The configs are in sync
+// if it installed them without error.
+func (c *Controller) status() debugStatus {
+	s := c.summary
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return debugStatus{
+		LastReconcile: s.lastReconcile,
+		LastError:     s.lastFailure,
+		State:         s.lastState,
+		InSync:        s.lastState == "installed" && s.lastFailure == "",
+		Ready:         s.readyLocked(),
+	}
+}
+
+func writeDebugJSON(w http.ResponseWriter, v interface{}) {
+	encoded, err := json.MarshalIndent(v, "", "  ")
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	_, _ = w.Write(encoded)
+}
@@ -0,0 +1,95 @@
+// Copyright 2019 Istio Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package controller
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	. "github.com/onsi/gomega"
+	kubeApiAdmission "k8s.io/api/admissionregistration/v1beta1"
+	kubeApisMeta "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+func TestDebugHandler(t *testing.T) {
+	g := NewGomegaWithT(t)
+	c := createTestController(t)
+	c.endpointStore.Add(istiodEndpoint)
+
+	go c.runWorker()
+	defer c.queue.ShutDown()
+
+	server := httptest.NewServer(c.DebugHandler())
+	defer server.Close()
+
+	get := func(path string, into interface{}) int {
+		t.Helper()
+		resp, err := http.Get(server.URL + path)
+		g.Expect(err).Should(Succeed())
+		defer resp.Body.Close()
+		if into != nil && resp.StatusCode == http.StatusOK {
+			g.Expect(json.NewDecoder(resp.Body).Decode(into)).Should(Succeed())
+		}
+		return resp.StatusCode
+	}
+	post := func(path string, into interface{}) int {
+		t.Helper()
+		resp, err := http.Post(server.URL+path, "", nil)
+		g.Expect(err).Should(Succeed())
+		defer resp.Body.Close()
+		if into != nil && resp.StatusCode == http.StatusOK {
+			g.Expect(json.NewDecoder(resp.Body).Decode(into)).Should(Succeed())
+		}
+		return resp.StatusCode
+	}
+
+	var status debugStatus
+	g.Expect(get("/status", &status)).Should(Equal(http.StatusOK))
+	g.Expect(status.LastReconcile.IsZero()).Should(BeTrue())
+	g.Expect(status.InSync).Should(BeFalse())
+
+	var live []*kubeApiAdmission.ValidatingWebhookConfiguration
+	g.Expect(get("/live", &live)).Should(Equal(http.StatusOK))
+	g.Expect(live).Should(BeEmpty())
+
+	var desired []*kubeApiAdmission.ValidatingWebhookConfiguration
+	g.Expect(get("/desired", &desired)).Should(Equal(http.StatusOK))
+	g.Expect(desired).Should(HaveLen(1))
+	g.Expect(desired[0].Webhooks).Should(Equal(webhookConfigWithCABundle0.Webhooks))
+
+	g.Expect(get("/reconcile", nil)).Should(Equal(http.StatusMethodNotAllowed))
+	g.Expect(get("/trace", nil)).Should(Equal(http.StatusNotFound))
+
+	g.Expect(post("/reconcile", &status)).Should(Equal(http.StatusOK))
+	g.Expect(status.LastReconcile.IsZero()).Should(BeFalse())
+	g.Expect(status.LastError).Should(BeEmpty())
+	g.Expect(status.InSync).Should(BeTrue())
+
+	created, err := c.ValidatingWebhookConfigurations().Get(galleyWebhookName, kubeApisMeta.GetOptions{})
+	g.Expect(err).Should(Succeed())
+	c.configStore.Add(created)
+	g.Expect(get("/live", &live)).Should(Equal(http.StatusOK))
+	g.Expect(live).Should(HaveLen(1))
+	g.Expect(live[0].Name).Should(Equal(galleyWebhookName))
+
+	// the traced reconcile is queued ahead of the requested one.
+	g.Expect(post("/trace", nil)).Should(Equal(http.StatusAccepted))
+	g.Expect(post("/reconcile", nil)).Should(Equal(http.StatusOK))
+	var trace ReconcileTrace
+	g.Expect(get("/trace", &trace)).Should(Equal(http.StatusOK))
+	g.Expect(trace.Decisions).ShouldNot(BeEmpty())
+}
@@ -0,0 +1,93 @@
+// Copyright 2019 Istio Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package controller
+
+import (
+	"bytes"
+	"fmt"
+	"strings"
+
+	"github.com/google/go-cmp/cmp"
+)
+
+// diffReporter collects the paths of the fields which differ.
+type diffReporter struct {
+	path  cmp.Path
+	diffs []string
+}
+
+func (r *diffReporter) PushStep(ps cmp.PathStep) {
+	r.path = append(r.path, ps)
+}
+
+func (r *diffReporter) Report(rs cmp.Result) {
+	if rs.Equal() {
+		return
+	}
+	var path strings.Builder
+	for _, step := range r.path {
+		switch step := step.(type) {
+		case cmp.StructField:
+			if path.Len() > 0 {
+				path.WriteString(".")
+			}
+			path.WriteString(step.Name())
+		case cmp.SliceIndex:
+			if key := step.Key(); key >= 0 {
+				fmt.Fprintf(&path, "[%d]", key)
+			} else {
+				path.WriteString("[]")
+			}
+		case cmp.MapIndex:
+			fmt.Fprintf(&path, "[%v]", step.Key())
+		}
+	}
+	if n := len(r.diffs); n == 0 || r.diffs[n-1] != path.String() {
+		r.diffs = append(r.diffs, path.String())
+	}
+}
+
+func (r *diffReporter) PopStep() {
+	r.path = r.path[:len(r.path)-1]
+}
+
+// configDiff returns the paths of the fields which differ between the
+// current and updated config, e.g. Webhooks[0].ClientConfig.CABundle.
+func configDiff(current, updated interface{}) string {
+	var r diffReporter
+	// caBundles are compared as a whole rather than byte by byte.
+	cmp.Equal(current, updated, cmp.Reporter(&r), cmp.Comparer(bytes.Equal))
+	return strings.Join(r.diffs, ", ")
+}
+
+// recordDiff logs the diff of the named config and keeps it for LastDiff.
+func (c *Controller) recordDiff(resource, name string, current, updated interface{}) string {
+	diff := configDiff(current, updated)
+	scope.Debugf("%v %v changed: %v", resource, name, diff)
+
+	c.diffMu.Lock()
+	defer c.diffMu.Unlock()
+	c.lastDiff = fmt.Sprintf("%v %v: %v", resource, name, diff)
+	return diff
+}
+
+// LastDiff returns the fields which differed between the installed and the
+// desired webhook config the last time the controller updated it. It is
+// intended for debugging flapping updates, e.g. via an admin endpoint.
+func (c *Controller) LastDiff() string {
+	c.diffMu.Lock()
+	defer c.diffMu.Unlock()
+	return c.lastDiff
+}
@@ -0,0 +1,91 @@
+// Copyright 2019 Istio Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package controller
+
+import (
+	kubeApiCore "k8s.io/api/core/v1"
+	kubeApiMeta "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	kubeScheme "k8s.io/client-go/kubernetes/scheme"
+	kubeTypedCore "k8s.io/client-go/kubernetes/typed/core/v1"
+	"k8s.io/client-go/tools/record"
+)
+
+// Reasons of the events recorded on the webhook config.
+const (
+	eventReasonCreated          = "Created"
+	eventReasonUpdated          = "Updated"
+	eventReasonApplied          = "Applied"
+	eventReasonDeleted          = "Deleted"
+	eventReasonUpdateFailed     = "UpdateFailed"
+	eventReasonTemplateMissing  = "TemplateMissing"
+	eventReasonEndpointNotReady = "EndpointNotReady"
+)
+
+func newEventBroadcaster() (record.EventBroadcaster, record.EventRecorder) {
+	broadcaster := record.NewBroadcaster()
+	recorder := broadcaster.NewRecorder(kubeScheme.Scheme, kubeApiCore.EventSource{Component: managedByValue})
+	return broadcaster, recorder
+}
+
+// startRecordingEvents sends the recorded events to the kube-apiserver until Stop is called.
+func (c *Controller) startRecordingEvents(o Options) {
+	if c.eventBroadcaster == nil {
+		return
+	}
+	c.eventBroadcaster.StartRecordingToSink(&kubeTypedCore.EventSinkImpl{
+		Interface: o.Client.CoreV1().Events(""),
+	})
+}
+
+// discardEvents shuts down the event broadcaster, if any, and drops the
+// events recorded from then on.
+func (c *Controller) discardEvents() {
+	if c.eventBroadcaster == nil {
+		return
+	}
+	c.eventBroadcaster.Shutdown()
+	c.eventBroadcaster = nil
+	c.recorder = discardRecorder{}
+}
+
+// discardRecorder is an EventRecorder which drops every event.
+type discardRecorder struct{}
+
+func (discardRecorder) Event(runtime.Object, string, string, string) {}
+
+func (discardRecorder) Eventf(runtime.Object, string, string, string, ...interface{}) {}
+
+func (discardRecorder) PastEventf(runtime.Object, kubeApiMeta.Time, string, string, string, ...interface{}) {
+}
+
+func (discardRecorder) AnnotatedEventf(runtime.Object, map[string]string, string, string, string, ...interface{}) {
+}
+
+// recordConfigEvent records an event on the named validatingwebhookconfiguration.
+func (c *Controller) recordConfigEvent(name, eventType, reason, messageFmt string, args ...interface{}) {
+	c.recordEvent(configGVK, name, eventType, reason, messageFmt, args...)
+}
+
+// recordEvent records an event on the named webhook config of the kind.
+func (c *Controller) recordEvent(gvk schema.GroupVersionKind, name, eventType, reason, messageFmt string, args ...interface{}) {
+	ref := &kubeApiCore.ObjectReference{
+		APIVersion: gvk.GroupVersion().String(),
+		Kind:       gvk.Kind,
+		Name:       name,
+	}
+	c.recorder.Eventf(ref, eventType, reason, messageFmt, args...)
+}
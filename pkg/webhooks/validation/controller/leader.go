@@ -0,0 +1,134 @@
+// Copyright 2019 Istio Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package controller
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"sync/atomic"
+	"time"
+
+	kubeApiMeta "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/tools/leaderelection"
+	"k8s.io/client-go/tools/leaderelection/resourcelock"
+)
+
+type leaderTimings struct {
+	leaseDuration time.Duration
+	renewDeadline time.Duration
+	retryPeriod   time.Duration
+}
+
+var defaultLeaderTimings = leaderTimings{
+	leaseDuration: 30 * time.Second,
+	renewDeadline: 15 * time.Second,
+	retryPeriod:   5 * time.Second,
+}
+
+func defaultLeaderIdentity() string {
+	if name := os.Getenv("POD_NAME"); name != "" {
+		return name
+	}
+	hostname, err := os.Hostname()
+	if err != nil {
+		scope.Warnf("Could not determine hostname for leader election: %v", err)
+	}
+	return fmt.Sprintf("%v-%d", hostname, os.Getpid())
+}
+
+// leaderElectionLockName is the name of the configmap used as the leader
+// election lock. Controllers managing different webhook configs elect
+// their leaders independently.
+func (o Options) leaderElectionLockName() string {
+	if o.WebhookConfigName == "" {
+		// only possible with a WebhookConfigSelector.
+		return managedByValue + "-leader"
+	}
+	return o.WebhookConfigName + "-controller-leader"
+}
+
+// leaderState tracks whether this replica holds the leader election lock.
+// Only accessed atomically.
+type leaderState struct {
+	leading int32
+}
+
+var errNotLeader = errors.New("not the validation controller leader")
+
+func (c *Controller) isLeader(o Options) bool {
+	if !o.EnableLeaderElection {
+		return true
+	}
+	return atomic.LoadInt32(&c.leader.leading) == 1
+}
+
+// runLeaderElection campaigns for leadership until stop is closed. The
+// workers run whether or not this replica is leading, so requests dequeued
+// while not leading are dropped and Reconcile returns errNotLeader. A
+// reconcile is enqueued each time this replica is elected.
+func (c *Controller) runLeaderElection(o Options, stop <-chan struct{}) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go func() {
+		select {
+		case <-stop:
+			cancel()
+		case <-ctx.Done():
+		}
+	}()
+
+	lock := &resourcelock.ConfigMapLock{
+		ConfigMapMeta: kubeApiMeta.ObjectMeta{
+			Namespace: o.leaderElectionNamespace(),
+			Name:      o.leaderElectionLockName(),
+		},
+		Client: o.Client.CoreV1(),
+		LockConfig: resourcelock.ResourceLockConfig{
+			Identity: c.leaderIdentity,
+		},
+	}
+	le, err := leaderelection.NewLeaderElector(leaderelection.LeaderElectionConfig{
+		Lock:            lock,
+		LeaseDuration:   c.leaderTimings.leaseDuration,
+		RenewDeadline:   c.leaderTimings.renewDeadline,
+		RetryPeriod:     c.leaderTimings.retryPeriod,
+		ReleaseOnCancel: true,
+		Callbacks: leaderelection.LeaderCallbacks{
+			OnStartedLeading: func(ctx context.Context) {
+				scope.Infof("%v is the new validation controller leader", c.leaderIdentity)
+				atomic.StoreInt32(&c.leader.leading, 1)
+				c.queue.Add(&reconcileRequest{description: "elected leader"})
+			},
+			OnStoppedLeading: func() {
+				atomic.StoreInt32(&c.leader.leading, 0)
+				scope.Infof("%v is no longer the validation controller leader", c.leaderIdentity)
+			},
+			OnNewLeader: func(identity string) {
+				scope.Infof("New validation controller leader elected: %v", identity)
+			},
+		},
+	})
+	if err != nil {
+		scope.Errorf("Could not start leader election: %v", err)
+		return
+	}
+
+	// keep campaigning if leadership is lost without being stopped.
+	for ctx.Err() == nil {
+		le.Run(ctx)
+	}
+}
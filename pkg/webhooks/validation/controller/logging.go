@@ -0,0 +1,56 @@
+// Copyright 2019 Istio Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package controller
+
+import (
+	"go.uber.org/zap"
+	"go.uber.org/zap/zapcore"
+	kubeErrors "k8s.io/apimachinery/pkg/api/errors"
+)
+
+// Keys of the structured fields of the reconcile and write log messages.
+const (
+	logFieldRequest       = "request"
+	logFieldNamespace     = "namespace"
+	logFieldWebhookConfig = "webhook_config"
+	logFieldReason        = "reason"
+	logFieldOutcome       = "outcome"
+)
+
+// logFields returns the fields of the log messages of reconciling the request.
+func (rr *reconcileRequest) logFields() []zapcore.Field {
+	fields := []zapcore.Field{zap.String(logFieldRequest, rr.description)}
+	if rr.namespace != "" {
+		fields = append(fields, zap.String(logFieldNamespace, rr.namespace))
+	}
+	return fields
+}
+
+// writeLogFields returns the fields of the log message of a write of the
+// named config with the outcome.
+func writeLogFields(name, outcome string) []zapcore.Field {
+	return []zapcore.Field{
+		zap.String(logFieldWebhookConfig, name),
+		zap.String(logFieldOutcome, outcome),
+	}
+}
+
+// writeErrorLogFields returns the fields of the log message of a failed
+// write of the named config.
+func writeErrorLogFields(name string, err error) []zapcore.Field {
+	return append(writeLogFields(name, "error"),
+		zap.String(logFieldReason, string(kubeErrors.ReasonForError(err))),
+		zap.Error(err))
+}
@@ -0,0 +1,106 @@
+// Copyright 2019 Istio Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package controller
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	. "github.com/onsi/gomega"
+	kubeErrors "k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	k8stesting "k8s.io/client-go/testing"
+
+	"istio.io/pkg/log"
+)
+
+func TestStructuredLogFields(t *testing.T) {
+	g := NewGomegaWithT(t)
+
+	dir, err := ioutil.TempDir("", "structured-log")
+	g.Expect(err).Should(Succeed())
+	defer func() { _ = os.RemoveAll(dir) }()
+
+	logFile := filepath.Join(dir, "controller.log")
+	options := log.DefaultOptions()
+	options.OutputPaths = []string{logFile}
+	options.JSONEncoding = true
+	g.Expect(log.Configure(options)).Should(Succeed())
+	defer func(level log.Level) {
+		scope.SetOutputLevel(level)
+		_ = log.Configure(log.DefaultOptions())
+	}(scope.GetOutputLevel())
+	scope.SetOutputLevel(log.InfoLevel)
+
+	// the log messages by message.
+	messages := func() map[string][]map[string]interface{} {
+		_ = log.Sync()
+		contents, err := ioutil.ReadFile(logFile)
+		g.Expect(err).Should(Succeed())
+		messages := make(map[string][]map[string]interface{})
+		for _, line := range strings.Split(strings.TrimSpace(string(contents)), "\n") {
+			var entry map[string]interface{}
+			g.Expect(json.Unmarshal([]byte(line), &entry)).Should(Succeed(), line)
+			msg, _ := entry["msg"].(string)
+			messages[msg] = append(messages[msg], entry)
+		}
+		return messages
+	}
+
+	c := createTestController(t)
+	c.endpointStore.Add(istiodEndpoint)
+	req := &reconcileRequest{description: "endpoint ready", namespace: namespace}
+	g.Expect(c.reconcileRequest(context.Background(), c.options(), req)).Should(Succeed())
+
+	logged := messages()
+	g.Expect(logged["Reconcile(enter)"]).Should(ConsistOf(SatisfyAll(
+		HaveKeyWithValue(logFieldRequest, "endpoint ready"),
+		HaveKeyWithValue(logFieldNamespace, namespace),
+	)))
+	g.Expect(logged["Reconcile(exit)"]).Should(ConsistOf(SatisfyAll(
+		HaveKeyWithValue(logFieldRequest, "endpoint ready"),
+		HaveKeyWithValue(logFieldOutcome, "installed"),
+	)))
+	g.Expect(logged["Successfully created validatingwebhookconfiguration"]).Should(ConsistOf(SatisfyAll(
+		HaveKeyWithValue(logFieldWebhookConfig, galleyWebhookName),
+		HaveKeyWithValue(logFieldOutcome, "created"),
+	)))
+
+	// a failed delete is logged with the reason.
+	c.PrependReactor("delete", "validatingwebhookconfigurations", func(k8stesting.Action) (bool, runtime.Object, error) {
+		return true, nil, kubeErrors.NewForbidden(schema.GroupResource{}, galleyWebhookName, errors.New("denied"))
+	})
+	c.o.UnregisterValidationWebhook = true
+	g.Expect(c.reconcileRequest(context.Background(), c.options(), &reconcileRequest{description: "unregister"})).ShouldNot(Succeed())
+
+	logged = messages()
+	g.Expect(logged["Failed to delete validatingwebhookconfiguration"]).Should(ConsistOf(SatisfyAll(
+		HaveKeyWithValue(logFieldWebhookConfig, galleyWebhookName),
+		HaveKeyWithValue(logFieldOutcome, "error"),
+		HaveKeyWithValue(logFieldReason, "Forbidden"),
+	)))
+	g.Expect(logged["Reconcile(exit)"]).Should(ContainElement(SatisfyAll(
+		HaveKeyWithValue(logFieldRequest, "unregister"),
+		HaveKeyWithValue(logFieldOutcome, "error"),
+		Not(HaveKey(logFieldNamespace)),
+	)))
+}
@@ -16,21 +16,36 @@ package controller
 
 import (
 	"context"
+	"strconv"
+	"time"
 
 	"go.opencensus.io/stats"
 	"go.opencensus.io/stats/view"
 	"go.opencensus.io/tag"
 
 	kubeMeta "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime/schema"
 )
 
 const (
-	reason = "reason"
+	reason     = "reason"
+	configName = "config_name"
+	dryRun     = "dry_run"
+	gvk        = "gvk"
 )
 
 var (
 	// reasonTag holds the error reason for the context.
 	reasonTag tag.Key
+
+	// configNameTag holds the name of the webhook config for the context.
+	configNameTag tag.Key
+
+	// dryRunTag holds whether the controller runs in dry-run mode for the context.
+	dryRunTag tag.Key
+
+	// gvkTag holds the group, version, and kind of a watched object for the context.
+	gvkTag tag.Key
 )
 
 var (
@@ -54,6 +69,82 @@ var (
 		"galley/validation/config_load",
 		"k8s webhook configuration (re)loads",
 		stats.UnitDimensionless)
+	metricWebhookConfigurationSkippedEndpointNotReady = stats.Int64(
+		"galley/validation/config_skipped_endpoint_not_ready",
+		"k8s webhook configuration reconciles skipped because the webhook endpoint is not ready",
+		stats.UnitDimensionless)
+	metricWebhookConfigurationSkippedGalleyRunning = stats.Int64(
+		"galley/validation/config_skipped_galley_running",
+		"k8s webhook configuration reconciles skipped because the galley deployment is running",
+		stats.UnitDimensionless)
+	metricWebhookConfigurationSkippedPaused = stats.Int64(
+		"galley/validation/config_skipped_paused",
+		"k8s webhook configuration reconciles skipped because reconciliation is paused by annotation",
+		stats.UnitDimensionless)
+	metricWebhookConfigurationRetriesExhausted = stats.Int64(
+		"galley/validation/config_retries_exhausted",
+		"k8s webhook configuration reconciles dropped after exhausting their retries",
+		stats.UnitDimensionless)
+	metricMutatingConfigUpdateError = stats.Int64(
+		"galley/mutating/config_update_error",
+		"k8s mutating webhook configuration update error",
+		stats.UnitDimensionless)
+	metricMutatingConfigUpdates = stats.Int64(
+		"galley/mutating_config_updates",
+		"k8s mutating webhook configuration updates",
+		stats.UnitDimensionless)
+	metricMutatingConfigDeleteError = stats.Int64(
+		"galley/mutating/config_delete_error",
+		"k8s mutating webhook configuration delete error",
+		stats.UnitDimensionless)
+	metricCABundleValidityError = stats.Int64(
+		"galley/validation/ca_bundle_validity_error",
+		"webhook configuration caBundle certificate outside of its validity window",
+		stats.UnitDimensionless)
+	metricCABundleExpirySeconds = stats.Float64(
+		"galley/validation/ca_bundle_expiry_seconds",
+		"seconds until the earliest expiring certificate of the webhook configuration caBundle expires",
+		"s")
+	metricServiceSelectorChanged = stats.Int64(
+		"galley/validation/service_selector_changed",
+		"webhook service selector changed while the webhook configuration is installed",
+		stats.UnitDimensionless)
+	metricRiskySideEffects = stats.Int64(
+		"galley/validation/risky_side_effects",
+		"webhook configuration with webhooks that fail closed without declaring their side effects",
+		stats.UnitDimensionless)
+	metricCABundleShrinkRefused = stats.Int64(
+		"galley/validation/ca_bundle_shrink_refused",
+		"webhook configuration updates refused because the caBundle would lose certificates",
+		stats.UnitDimensionless)
+	metricInformerEvents = stats.Int64(
+		"galley/validation/informer_events",
+		"informer events received for watched objects",
+		stats.UnitDimensionless)
+	metricInformerEventsSkipped = stats.Int64(
+		"galley/validation/informer_events_skipped",
+		"informer events skipped because the object isn't watched",
+		stats.UnitDimensionless)
+	metricServingCertMismatch = stats.Int64(
+		"galley/validation/serving_cert_mismatch",
+		"webhook serving certificate does not chain to the webhook configuration caBundle",
+		stats.UnitDimensionless)
+	metricInformerDecodeErrors = stats.Int64(
+		"galley/validation/informer_decode_errors",
+		"informer events dropped because the object could not be decoded",
+		stats.UnitDimensionless)
+	metricSecondsSinceLastSuccess = stats.Float64(
+		"galley/validation/config_seconds_since_last_success",
+		"seconds since the webhook configuration was last successfully reconciled",
+		"s")
+	metricTemplateMissing = stats.Int64(
+		"galley/validation/template_missing",
+		"webhook configuration reconciles which found the template file missing",
+		stats.UnitDimensionless)
+	metricEndpointReadyTimeout = stats.Int64(
+		"galley/validation/endpoint_ready_timeout",
+		"times the webhook endpoint wasn't ready within the endpoint ready timeout",
+		stats.UnitDimensionless)
 )
 
 func newView(measure stats.Measure, keys []tag.Key, aggregation *view.Aggregation) *view.View {
@@ -71,16 +162,47 @@ func init() {
 	if reasonTag, err = tag.NewKey(reason); err != nil {
 		panic(err)
 	}
+	if configNameTag, err = tag.NewKey(configName); err != nil {
+		panic(err)
+	}
+	if dryRunTag, err = tag.NewKey(dryRun); err != nil {
+		panic(err)
+	}
+	if gvkTag, err = tag.NewKey(gvk); err != nil {
+		panic(err)
+	}
 
 	var noKeys []tag.Key
-	reasonKey := []tag.Key{reasonTag}
+	configNameKey := []tag.Key{configNameTag}
+	reasonAndConfigNameKeys := []tag.Key{reasonTag, configNameTag}
+	configNameAndDryRunKeys := []tag.Key{configNameTag, dryRunTag}
+	reasonConfigNameAndDryRunKeys := []tag.Key{reasonTag, configNameTag, dryRunTag}
 
 	err = view.Register(
-		newView(metricWebhookConfigurationUpdateError, reasonKey, view.Count()),
-		newView(metricWebhookConfigurationUpdates, noKeys, view.Count()),
-		newView(metricWebhookConfigurationDeleteError, reasonKey, view.Count()),
-		newView(metricWebhookConfigurationLoadError, reasonKey, view.Count()),
+		newView(metricWebhookConfigurationUpdateError, reasonConfigNameAndDryRunKeys, view.Count()),
+		newView(metricWebhookConfigurationUpdates, configNameAndDryRunKeys, view.Count()),
+		newView(metricWebhookConfigurationDeleteError, reasonConfigNameAndDryRunKeys, view.Count()),
+		newView(metricWebhookConfigurationLoadError, reasonAndConfigNameKeys, view.Count()),
 		newView(metricWebhookConfigurationLoad, noKeys, view.Count()),
+		newView(metricWebhookConfigurationSkippedEndpointNotReady, []tag.Key{reasonTag}, view.Count()),
+		newView(metricWebhookConfigurationSkippedGalleyRunning, noKeys, view.Count()),
+		newView(metricWebhookConfigurationSkippedPaused, configNameKey, view.Count()),
+		newView(metricWebhookConfigurationRetriesExhausted, noKeys, view.Count()),
+		newView(metricMutatingConfigUpdateError, reasonAndConfigNameKeys, view.Count()),
+		newView(metricMutatingConfigUpdates, configNameKey, view.Count()),
+		newView(metricMutatingConfigDeleteError, reasonAndConfigNameKeys, view.Count()),
+		newView(metricCABundleValidityError, []tag.Key{reasonTag}, view.Count()),
+		newView(metricCABundleExpirySeconds, configNameKey, view.LastValue()),
+		newView(metricServiceSelectorChanged, noKeys, view.Count()),
+		newView(metricRiskySideEffects, configNameKey, view.Count()),
+		newView(metricCABundleShrinkRefused, configNameKey, view.Count()),
+		newView(metricInformerEvents, []tag.Key{gvkTag}, view.Count()),
+		newView(metricInformerEventsSkipped, []tag.Key{gvkTag}, view.Count()),
+		newView(metricInformerDecodeErrors, []tag.Key{gvkTag}, view.Count()),
+		newView(metricServingCertMismatch, noKeys, view.Count()),
+		newView(metricSecondsSinceLastSuccess, configNameKey, view.LastValue()),
+		newView(metricTemplateMissing, configNameKey, view.Count()),
+		newView(metricEndpointReadyTimeout, noKeys, view.Count()),
 	)
 
 	if err != nil {
@@ -88,33 +210,301 @@ func init() {
 	}
 }
 
-func reportValidationConfigUpdateError(reason kubeMeta.StatusReason) {
-	ctx, err := tag.New(context.Background(), tag.Insert(reasonTag, string(reason)))
+// MetricsReporter reports the outcome of the controller's reconcile
+// operations. Embedders can provide their own implementation via
+// Options.MetricsReporter to route the controller's metrics through a
+// different telemetry pipeline. Embedding NopMetricsReporter leaves the
+// methods not of interest as no-ops.
+type MetricsReporter interface {
+	// ReportValidationConfigUpdateError is called when creating or updating the webhook config fails.
+	ReportValidationConfigUpdateError(configName string, reason kubeMeta.StatusReason)
+	// ReportValidationConfigDeleteError is called when deleting the webhook config fails.
+	ReportValidationConfigDeleteError(configName string, reason kubeMeta.StatusReason)
+	// ReportValidationConfigLoadError is called when the desired webhook config cannot be built.
+	ReportValidationConfigLoadError(configName string, reason string)
+	// ReportValidationConfigUpdate is called when the webhook config is successfully created or updated.
+	ReportValidationConfigUpdate(configName string)
+	// ReportValidationConfigSkippedEndpointNotReady is called when a reconcile is skipped until the endpoint is ready.
+	ReportValidationConfigSkippedEndpointNotReady(reason string)
+	// ReportValidationConfigSkippedGalleyRunning is called when a reconcile is skipped because galley is running.
+	ReportValidationConfigSkippedGalleyRunning()
+	// ReportValidationConfigSkippedPaused is called when a reconcile is skipped because the config is paused.
+	ReportValidationConfigSkippedPaused(configName string)
+	// ReportValidationConfigRetriesExhausted is called when a failed reconcile is dropped after MaxReconcileRetries.
+	ReportValidationConfigRetriesExhausted()
+	// ReportMutatingConfigUpdateError is called when creating or updating the mutating webhook config fails.
+	ReportMutatingConfigUpdateError(configName string, reason kubeMeta.StatusReason)
+	// ReportMutatingConfigDeleteError is called when deleting the mutating webhook config fails.
+	ReportMutatingConfigDeleteError(configName string, reason kubeMeta.StatusReason)
+	// ReportMutatingConfigUpdate is called when the mutating webhook config is successfully created or updated.
+	ReportMutatingConfigUpdate(configName string)
+	// ReportCABundleValidityError is called when the CA bundle certificate is not yet valid or has expired.
+	ReportCABundleValidityError(reason string)
+	// ReportCABundleExpiry is called with the time until the earliest expiring CA bundle
+	// certificate expires each time the webhook config is successfully loaded.
+	ReportCABundleExpiry(configName string, untilExpiry time.Duration)
+	// ReportServiceSelectorChanged is called when the webhook service selector changes while the config is installed.
+	ReportServiceSelectorChanged()
+	// ReportRiskySideEffects is called when webhooks fail closed with sideEffects Unknown or Some.
+	ReportRiskySideEffects(configName string)
+	// ReportServingCertMismatch is called when the serving certificate does not chain to the CA bundle.
+	ReportServingCertMismatch()
+	// ReportCABundleShrinkRefused is called when an update which would shrink the caBundle is refused.
+	ReportCABundleShrinkRefused(configName string)
+	// ReportInformerEvent is called for each informer event, and whether it was skipped since the object isn't
+	// watched.
+	ReportInformerEvent(gvk schema.GroupVersionKind, skipped bool)
+	// ReportInformerDecodeError is called when an informer event is dropped because its object can't be decoded.
+	ReportInformerDecodeError(gvk schema.GroupVersionKind)
+	// ReportSecondsSinceLastSuccess is called periodically with the time since the webhook config was last
+	// successfully reconciled, and with zero on each success.
+	ReportSecondsSinceLastSuccess(configName string, sinceLastSuccess time.Duration)
+	// ReportValidationTemplateMissing is called when the template file of the webhook config is missing.
+	ReportValidationTemplateMissing(configName string)
+	// ReportEndpointReadyTimeout is called when the endpoint isn't ready within the EndpointReadyTimeout.
+	ReportEndpointReadyTimeout()
+}
+
+// NopMetricsReporter is a MetricsReporter which reports nothing. Embed it
+// in a MetricsReporter to implement only the methods of interest.
+type NopMetricsReporter struct{}
+
+var _ MetricsReporter = NopMetricsReporter{}
+
+func (NopMetricsReporter) ReportValidationConfigUpdateError(string, kubeMeta.StatusReason) {}
+
+func (NopMetricsReporter) ReportValidationConfigDeleteError(string, kubeMeta.StatusReason) {}
+
+func (NopMetricsReporter) ReportValidationConfigLoadError(string, string) {}
+
+func (NopMetricsReporter) ReportValidationConfigUpdate(string) {}
+
+func (NopMetricsReporter) ReportValidationConfigSkippedEndpointNotReady(string) {}
+
+func (NopMetricsReporter) ReportValidationConfigSkippedGalleyRunning() {}
+
+func (NopMetricsReporter) ReportValidationConfigSkippedPaused(string) {}
+
+func (NopMetricsReporter) ReportValidationConfigRetriesExhausted() {}
+
+func (NopMetricsReporter) ReportMutatingConfigUpdateError(string, kubeMeta.StatusReason) {}
+
+func (NopMetricsReporter) ReportMutatingConfigDeleteError(string, kubeMeta.StatusReason) {}
+
+func (NopMetricsReporter) ReportMutatingConfigUpdate(string) {}
+
+func (NopMetricsReporter) ReportCABundleValidityError(string) {}
+
+func (NopMetricsReporter) ReportCABundleExpiry(string, time.Duration) {}
+
+func (NopMetricsReporter) ReportServiceSelectorChanged() {}
+
+func (NopMetricsReporter) ReportRiskySideEffects(string) {}
+
+func (NopMetricsReporter) ReportServingCertMismatch() {}
+
+func (NopMetricsReporter) ReportCABundleShrinkRefused(string) {}
+
+func (NopMetricsReporter) ReportInformerEvent(schema.GroupVersionKind, bool) {}
+
+func (NopMetricsReporter) ReportInformerDecodeError(schema.GroupVersionKind) {}
+
+func (NopMetricsReporter) ReportSecondsSinceLastSuccess(string, time.Duration) {}
+
+func (NopMetricsReporter) ReportValidationTemplateMissing(string) {}
+
+func (NopMetricsReporter) ReportEndpointReadyTimeout() {}
+
+// opencensusReporter is the default MetricsReporter which records the
+// metrics registered by this package. The webhook config update and delete
+// metrics are labeled with whether the controller runs in dry-run mode.
+type opencensusReporter struct {
+	dryRun bool
+}
+
+var _ MetricsReporter = opencensusReporter{}
+
+func (r opencensusReporter) dryRunTag() tag.Mutator {
+	return tag.Insert(dryRunTag, strconv.FormatBool(r.dryRun))
+}
+
+func (r opencensusReporter) ReportValidationConfigUpdateError(configName string, reason kubeMeta.StatusReason) {
+	ctx, err := tag.New(context.Background(),
+		tag.Insert(reasonTag, string(reason)), tag.Insert(configNameTag, configName), r.dryRunTag())
 	if err != nil {
-		scope.Errorf("Error creating monitoring context for reportValidationConfigUpdateError: %v", err)
+		scope.Errorf("Error creating monitoring context for ReportValidationConfigUpdateError: %v", err)
 	} else {
 		stats.Record(ctx, metricWebhookConfigurationUpdateError.M(1))
 	}
 }
 
-func reportValidationConfigDeleteError(reason kubeMeta.StatusReason) {
-	ctx, err := tag.New(context.Background(), tag.Insert(reasonTag, string(reason)))
+func (r opencensusReporter) ReportValidationConfigDeleteError(configName string, reason kubeMeta.StatusReason) {
+	ctx, err := tag.New(context.Background(),
+		tag.Insert(reasonTag, string(reason)), tag.Insert(configNameTag, configName), r.dryRunTag())
 	if err != nil {
-		scope.Errorf("Error creating monitoring context for reportValidationConfigDeleteError: %v", err)
+		scope.Errorf("Error creating monitoring context for ReportValidationConfigDeleteError: %v", err)
 	} else {
 		stats.Record(ctx, metricWebhookConfigurationDeleteError.M(1))
 	}
 }
 
-func reportValidationConfigLoadError(reason string) {
-	ctx, err := tag.New(context.Background(), tag.Insert(reasonTag, reason))
+func (opencensusReporter) ReportValidationConfigLoadError(configName string, reason string) {
+	ctx, err := tag.New(context.Background(),
+		tag.Insert(reasonTag, reason), tag.Insert(configNameTag, configName))
 	if err != nil {
-		scope.Errorf("Error creating monitoring context for reportValidationConfigLoadError: %v", err)
+		scope.Errorf("Error creating monitoring context for ReportValidationConfigLoadError: %v", err)
 	} else {
 		stats.Record(ctx, metricWebhookConfigurationLoadError.M(1))
 	}
 }
 
-func reportValidationConfigUpdate() {
-	stats.Record(context.Background(), metricWebhookConfigurationUpdates.M(1))
+func (r opencensusReporter) ReportValidationConfigUpdate(configName string) {
+	ctx, err := tag.New(context.Background(), tag.Insert(configNameTag, configName), r.dryRunTag())
+	if err != nil {
+		scope.Errorf("Error creating monitoring context for ReportValidationConfigUpdate: %v", err)
+	} else {
+		stats.Record(ctx, metricWebhookConfigurationUpdates.M(1))
+	}
+}
+
+func (opencensusReporter) ReportValidationConfigSkippedEndpointNotReady(reason string) {
+	ctx, err := tag.New(context.Background(), tag.Insert(reasonTag, reason))
+	if err != nil {
+		scope.Errorf("Error creating monitoring context for ReportValidationConfigSkippedEndpointNotReady: %v", err)
+	} else {
+		stats.Record(ctx, metricWebhookConfigurationSkippedEndpointNotReady.M(1))
+	}
+}
+
+func (opencensusReporter) ReportValidationConfigSkippedGalleyRunning() {
+	stats.Record(context.Background(), metricWebhookConfigurationSkippedGalleyRunning.M(1))
+}
+
+func (opencensusReporter) ReportValidationConfigSkippedPaused(configName string) {
+	ctx, err := tag.New(context.Background(), tag.Insert(configNameTag, configName))
+	if err != nil {
+		scope.Errorf("Error creating monitoring context for ReportValidationConfigSkippedPaused: %v", err)
+	} else {
+		stats.Record(ctx, metricWebhookConfigurationSkippedPaused.M(1))
+	}
+}
+
+func (opencensusReporter) ReportValidationConfigRetriesExhausted() {
+	stats.Record(context.Background(), metricWebhookConfigurationRetriesExhausted.M(1))
+}
+
+func (opencensusReporter) ReportMutatingConfigUpdateError(configName string, reason kubeMeta.StatusReason) {
+	ctx, err := tag.New(context.Background(),
+		tag.Insert(reasonTag, string(reason)), tag.Insert(configNameTag, configName))
+	if err != nil {
+		scope.Errorf("Error creating monitoring context for ReportMutatingConfigUpdateError: %v", err)
+	} else {
+		stats.Record(ctx, metricMutatingConfigUpdateError.M(1))
+	}
+}
+
+func (opencensusReporter) ReportMutatingConfigDeleteError(configName string, reason kubeMeta.StatusReason) {
+	ctx, err := tag.New(context.Background(),
+		tag.Insert(reasonTag, string(reason)), tag.Insert(configNameTag, configName))
+	if err != nil {
+		scope.Errorf("Error creating monitoring context for ReportMutatingConfigDeleteError: %v", err)
+	} else {
+		stats.Record(ctx, metricMutatingConfigDeleteError.M(1))
+	}
+}
+
+func (opencensusReporter) ReportMutatingConfigUpdate(configName string) {
+	ctx, err := tag.New(context.Background(), tag.Insert(configNameTag, configName))
+	if err != nil {
+		scope.Errorf("Error creating monitoring context for ReportMutatingConfigUpdate: %v", err)
+	} else {
+		stats.Record(ctx, metricMutatingConfigUpdates.M(1))
+	}
+}
+
+func (opencensusReporter) ReportCABundleValidityError(reason string) {
+	ctx, err := tag.New(context.Background(), tag.Insert(reasonTag, reason))
+	if err != nil {
+		scope.Errorf("Error creating monitoring context for ReportCABundleValidityError: %v", err)
+	} else {
+		stats.Record(ctx, metricCABundleValidityError.M(1))
+	}
+}
+
+func (opencensusReporter) ReportCABundleExpiry(configName string, untilExpiry time.Duration) {
+	ctx, err := tag.New(context.Background(), tag.Insert(configNameTag, configName))
+	if err != nil {
+		scope.Errorf("Error creating monitoring context for ReportCABundleExpiry: %v", err)
+	} else {
+		stats.Record(ctx, metricCABundleExpirySeconds.M(untilExpiry.Seconds()))
+	}
+}
+
+func (opencensusReporter) ReportServiceSelectorChanged() {
+	stats.Record(context.Background(), metricServiceSelectorChanged.M(1))
+}
+
+func (opencensusReporter) ReportRiskySideEffects(configName string) {
+	ctx, err := tag.New(context.Background(), tag.Insert(configNameTag, configName))
+	if err != nil {
+		scope.Errorf("Error creating monitoring context for ReportRiskySideEffects: %v", err)
+	} else {
+		stats.Record(ctx, metricRiskySideEffects.M(1))
+	}
+}
+
+func (opencensusReporter) ReportServingCertMismatch() {
+	stats.Record(context.Background(), metricServingCertMismatch.M(1))
+}
+
+func (opencensusReporter) ReportInformerEvent(gvk schema.GroupVersionKind, skipped bool) {
+	ctx, err := tag.New(context.Background(), tag.Insert(gvkTag, gvk.String()))
+	if err != nil {
+		scope.Errorf("Error creating monitoring context for ReportInformerEvent: %v", err)
+		return
+	}
+	stats.Record(ctx, metricInformerEvents.M(1))
+	if skipped {
+		stats.Record(ctx, metricInformerEventsSkipped.M(1))
+	}
+}
+
+func (opencensusReporter) ReportInformerDecodeError(gvk schema.GroupVersionKind) {
+	ctx, err := tag.New(context.Background(), tag.Insert(gvkTag, gvk.String()))
+	if err != nil {
+		scope.Errorf("Error creating monitoring context for ReportInformerDecodeError: %v", err)
+	} else {
+		stats.Record(ctx, metricInformerDecodeErrors.M(1))
+	}
+}
+
+func (opencensusReporter) ReportCABundleShrinkRefused(configName string) {
+	ctx, err := tag.New(context.Background(), tag.Insert(configNameTag, configName))
+	if err != nil {
+		scope.Errorf("Error creating monitoring context for ReportCABundleShrinkRefused: %v", err)
+	} else {
+		stats.Record(ctx, metricCABundleShrinkRefused.M(1))
+	}
+}
+
+func (opencensusReporter) ReportSecondsSinceLastSuccess(configName string, sinceLastSuccess time.Duration) {
+	ctx, err := tag.New(context.Background(), tag.Insert(configNameTag, configName))
+	if err != nil {
+		scope.Errorf("Error creating monitoring context for ReportSecondsSinceLastSuccess: %v", err)
+	} else {
+		stats.Record(ctx, metricSecondsSinceLastSuccess.M(sinceLastSuccess.Seconds()))
+	}
+}
+
+func (opencensusReporter) ReportValidationTemplateMissing(configName string) {
+	ctx, err := tag.New(context.Background(), tag.Insert(configNameTag, configName))
+	if err != nil {
+		scope.Errorf("Error creating monitoring context for ReportValidationTemplateMissing: %v", err)
+	} else {
+		stats.Record(ctx, metricTemplateMissing.M(1))
+	}
+}
+
+func (opencensusReporter) ReportEndpointReadyTimeout() {
+	stats.Record(context.Background(), metricEndpointReadyTimeout.M(1))
 }
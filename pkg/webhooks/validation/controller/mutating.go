@@ -0,0 +1,194 @@
+// Copyright 2019 Istio Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package controller
+
+import (
+	"reflect"
+
+	kubeApiAdmission "k8s.io/api/admissionregistration/v1beta1"
+	kubeApiMeta "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+)
+
+var mutatingConfigGVK = kubeApiAdmission.SchemeGroupVersion.WithKind(reflect.TypeOf(kubeApiAdmission.MutatingWebhookConfiguration{}).Name()) // nolint: lll
+
+var mutatingConfigResource = kubeApiAdmission.SchemeGroupVersion.WithResource("mutatingwebhookconfigurations")
+
+var mutatingConfigKind = configKind{
+	gvk: mutatingConfigGVK,
+	gvr: mutatingConfigResource,
+	newConfig: func() runtime.Object {
+		return &kubeApiAdmission.MutatingWebhookConfiguration{}
+	},
+	cached: func(c *Controller, name string) (runtime.Object, error) {
+		return c.sharedInformers.Admissionregistration().V1beta1().
+			MutatingWebhookConfigurations().Lister().Get(name)
+	},
+	view: func(config runtime.Object) *kubeApiAdmission.ValidatingWebhookConfiguration {
+		return validatingView(config.(*kubeApiAdmission.MutatingWebhookConfiguration))
+	},
+	fromView: func(view *kubeApiAdmission.ValidatingWebhookConfiguration, config runtime.Object) runtime.Object {
+		return fromValidatingView(view, config.(*kubeApiAdmission.MutatingWebhookConfiguration))
+	},
+	preApply: func(o Options, config runtime.Object) error {
+		if o.PreApplyMutating == nil {
+			return nil
+		}
+		return o.PreApplyMutating(config.(*kubeApiAdmission.MutatingWebhookConfiguration))
+	},
+	updateOnly: func(Options) bool {
+		return false
+	},
+	reportUpdate: func(c *Controller, configName string) {
+		c.metrics.ReportMutatingConfigUpdate(configName)
+	},
+	reportUpdateError: MetricsReporter.ReportMutatingConfigUpdateError,
+	reportDeleteError: MetricsReporter.ReportMutatingConfigDeleteError,
+}
+
+func (c *Controller) buildMutatingWebhookConfiguration(o Options) (*kubeApiAdmission.MutatingWebhookConfiguration, error) {
+	webhook, err := c.readCachedFile(o, o.MutatingWebhookConfigPath)
+	if err != nil {
+		return nil, &configError{err, "could not read mutatingwebhookconfiguration file"}
+	}
+	caBundle, webhookCABundles, err := c.readVerifiedCABundles(o)
+	if err != nil {
+		return nil, err
+	}
+	return buildMutatingWebhookConfiguration(o, caBundle, webhookCABundles, webhook, c.currentOwnerRefs())
+}
+
+// buildMutatingWebhookConfiguration decodes the mutating config template,
+// stamps the same runtime fields and runs the same config checks as the
+// validating configs. FailurePolicyOverride, ObjectSelector and
+// WebhookTimeoutSeconds only apply to the validating webhooks.
+func buildMutatingWebhookConfiguration(
+	o Options,
+	caBundle []byte,
+	webhookCABundles map[string][]byte,
+	webhook []byte,
+	ownerRefs []kubeApiMeta.OwnerReference,
+) (*kubeApiAdmission.MutatingWebhookConfiguration, error) {
+	if o.RenderTemplate {
+		rendered, err := renderTemplate(o, webhook)
+		if err != nil {
+			return nil, &configError{err, "could not render mutatingwebhookconfiguration template"}
+		}
+		webhook = rendered
+	}
+	config, err := decodeMutatingConfig(o.Codec, webhook)
+	if err != nil {
+		return nil, &configError{err, "could not decode mutatingwebhookconfiguration file"}
+	}
+	o.FailurePolicyOverride, o.ObjectSelector, o.WebhookTimeoutSeconds = nil, nil, nil
+	view, errs := stampAndCheckConfig(o, validatingView(config), caBundle, webhookCABundles, ownerRefs, true)
+	if len(errs) > 0 {
+		return nil, errs[0]
+	}
+	return fromValidatingView(view, config), nil
+}
+
+// validatingView returns a copy of the mutating config as a
+// validatingwebhookconfiguration, so the config checks, update guards and
+// merge of the validating configs cover it too. Its type meta is kept as
+// is. The webhooks of both kinds only differ in the reinvocationPolicy,
+// which is dropped and restored by fromValidatingView.
+func validatingView(config *kubeApiAdmission.MutatingWebhookConfiguration) *kubeApiAdmission.ValidatingWebhookConfiguration {
+	view := &kubeApiAdmission.ValidatingWebhookConfiguration{
+		TypeMeta:   config.TypeMeta,
+		ObjectMeta: *config.ObjectMeta.DeepCopy(),
+	}
+	if config.Webhooks != nil {
+		view.Webhooks = make([]kubeApiAdmission.ValidatingWebhook, 0, len(config.Webhooks))
+	}
+	for i := range config.Webhooks {
+		webhook := config.Webhooks[i].DeepCopy()
+		view.Webhooks = append(view.Webhooks, kubeApiAdmission.ValidatingWebhook{
+			Name:                    webhook.Name,
+			ClientConfig:            webhook.ClientConfig,
+			Rules:                   webhook.Rules,
+			FailurePolicy:           webhook.FailurePolicy,
+			MatchPolicy:             webhook.MatchPolicy,
+			NamespaceSelector:       webhook.NamespaceSelector,
+			ObjectSelector:          webhook.ObjectSelector,
+			SideEffects:             webhook.SideEffects,
+			TimeoutSeconds:          webhook.TimeoutSeconds,
+			AdmissionReviewVersions: webhook.AdmissionReviewVersions,
+		})
+	}
+	return view
+}
+
+// fromValidatingView returns the mutating config with the type meta,
+// metadata and webhooks of the view. Each webhook keeps the
+// reinvocationPolicy of the webhook of the same name in config.
+func fromValidatingView(
+	view *kubeApiAdmission.ValidatingWebhookConfiguration,
+	config *kubeApiAdmission.MutatingWebhookConfiguration,
+) *kubeApiAdmission.MutatingWebhookConfiguration {
+	reinvocation := make(map[string]*kubeApiAdmission.ReinvocationPolicyType, len(config.Webhooks))
+	for _, webhook := range config.Webhooks {
+		reinvocation[webhook.Name] = webhook.ReinvocationPolicy
+	}
+	out := &kubeApiAdmission.MutatingWebhookConfiguration{
+		TypeMeta:   view.TypeMeta,
+		ObjectMeta: *view.ObjectMeta.DeepCopy(),
+	}
+	if view.Webhooks != nil {
+		out.Webhooks = make([]kubeApiAdmission.MutatingWebhook, 0, len(view.Webhooks))
+	}
+	for i := range view.Webhooks {
+		webhook := view.Webhooks[i].DeepCopy()
+		out.Webhooks = append(out.Webhooks, kubeApiAdmission.MutatingWebhook{
+			Name:                    webhook.Name,
+			ClientConfig:            webhook.ClientConfig,
+			Rules:                   webhook.Rules,
+			FailurePolicy:           webhook.FailurePolicy,
+			MatchPolicy:             webhook.MatchPolicy,
+			NamespaceSelector:       webhook.NamespaceSelector,
+			ObjectSelector:          webhook.ObjectSelector,
+			SideEffects:             webhook.SideEffects,
+			TimeoutSeconds:          webhook.TimeoutSeconds,
+			AdmissionReviewVersions: webhook.AdmissionReviewVersions,
+			ReinvocationPolicy:      reinvocation[webhook.Name],
+		})
+	}
+	return out
+}
+
+func decodeMutatingConfig(decoder runtime.Decoder, encoded []byte) (*kubeApiAdmission.MutatingWebhookConfiguration, error) {
+	if decoder == nil {
+		decoder = codec
+	}
+	var config kubeApiAdmission.MutatingWebhookConfiguration
+	if _, _, err := decoder.Decode(encoded, nil, &config); err != nil {
+		return nil, err
+	}
+
+	// fill in missing defaults to minimize desired vs. actual diffs later.
+	for i := 0; i < len(config.Webhooks); i++ {
+		if config.Webhooks[i].FailurePolicy == nil {
+			config.Webhooks[i].FailurePolicy = &failurePolicyFail
+		}
+		if config.Webhooks[i].NamespaceSelector == nil {
+			config.Webhooks[i].NamespaceSelector = &kubeApiMeta.LabelSelector{}
+		}
+		if config.Webhooks[i].SideEffects == nil {
+			config.Webhooks[i].SideEffects = &sideEffectsUnknown
+		}
+	}
+
+	return &config, nil
+}
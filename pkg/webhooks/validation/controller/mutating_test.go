@@ -0,0 +1,329 @@
+// Copyright 2019 Istio Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package controller
+
+import (
+	"bytes"
+	"errors"
+	"testing"
+
+	. "github.com/onsi/gomega"
+	kubeApiAdmission "k8s.io/api/admissionregistration/v1beta1"
+	kubeApiRbac "k8s.io/api/rbac/v1"
+	kubeErrors "k8s.io/apimachinery/pkg/api/errors"
+	kubeApiMeta "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	k8stesting "k8s.io/client-go/testing"
+)
+
+const (
+	mutatingWebhookName = "istio-sidecar-injector"
+	mutatingConfigPath  = "fake-mutating-config-path"
+)
+
+var unpatchedMutatingWebhookConfig = &kubeApiAdmission.MutatingWebhookConfiguration{
+	TypeMeta: kubeApiMeta.TypeMeta{
+		APIVersion: kubeApiAdmission.SchemeGroupVersion.String(),
+		Kind:       "MutatingWebhookConfiguration",
+	},
+	ObjectMeta: kubeApiMeta.ObjectMeta{
+		Name: mutatingWebhookName,
+	},
+	Webhooks: []kubeApiAdmission.MutatingWebhook{{
+		Name: "sidecar-injector.istio.io",
+		ClientConfig: kubeApiAdmission.WebhookClientConfig{Service: &kubeApiAdmission.ServiceReference{
+			Namespace: namespace,
+			Name:      istiod,
+			Path:      &[]string{"/inject"}[0],
+		}},
+		Rules: []kubeApiAdmission.RuleWithOperations{{
+			Operations: []kubeApiAdmission.OperationType{kubeApiAdmission.Create},
+			Rule: kubeApiAdmission.Rule{
+				APIGroups:   []string{""},
+				APIVersions: []string{"v1"},
+				Resources:   []string{"pods"},
+			},
+		}},
+	}},
+}
+
+func TestManageMutatingWebhook(t *testing.T) {
+	g := NewGomegaWithT(t)
+	reporter := newFakeMetricsReporter()
+	c := createTestController(t, func(o *Options) {
+		o.ManageMutatingWebhook = true
+		o.MutatingWebhookConfigName = mutatingWebhookName
+		o.MutatingWebhookConfigPath = mutatingConfigPath
+		o.MetricsReporter = reporter
+	})
+	c.injectedFiles = map[string][]byte{
+		mutatingConfigPath: []byte(runtime.EncodeOrDie(codec, unpatchedMutatingWebhookConfig)),
+	}
+	c.endpointStore.Add(istiodEndpoint)
+
+	reconcileHelper(t, c)
+	g.Expect(c.Actions()).Should(HaveLen(2))
+	g.Expect(c.Actions()[0].Matches("create", "validatingwebhookconfigurations")).Should(BeTrue())
+	g.Expect(c.Actions()[1].Matches("create", "mutatingwebhookconfigurations")).Should(BeTrue())
+	mutating, err := c.MutatingWebhookConfigurations().Get(mutatingWebhookName, kubeApiMeta.GetOptions{})
+	g.Expect(err).Should(Succeed())
+	g.Expect(mutating.Webhooks[0].ClientConfig.CABundle).Should(Equal(caBundle0))
+	g.Expect(reporter.updates[galleyWebhookName]).Should(Equal(1))
+	g.Expect(reporter.mutatingUpdates[mutatingWebhookName]).Should(Equal(1))
+
+	mutatingStore := c.sharedInformers.Admissionregistration().V1beta1().MutatingWebhookConfigurations().Informer().GetStore()
+	c.configStore.Add(webhookConfigWithCABundle0)
+	mutatingStore.Add(mutating)
+	reconcileHelper(t, c)
+	g.Expect(c.Actions()).Should(BeEmpty())
+
+	// the mutating config is removed first.
+	c.o.UnregisterValidationWebhook = true
+	reconcileHelper(t, c)
+	g.Expect(c.Actions()).Should(HaveLen(2))
+	g.Expect(c.Actions()[0].Matches("delete", "mutatingwebhookconfigurations")).Should(BeTrue())
+	g.Expect(c.Actions()[0].(k8stesting.DeleteAction).GetName()).Should(Equal(mutatingWebhookName))
+	g.Expect(c.Actions()[1].Matches("delete", "validatingwebhookconfigurations")).Should(BeTrue())
+}
+
+func createMutatingTestController(t *testing.T, opts ...func(*Options)) (*fakeController, *fakeMetricsReporter) {
+	t.Helper()
+	reporter := newFakeMetricsReporter()
+	c := createTestController(t, append([]func(*Options){func(o *Options) {
+		o.ManageMutatingWebhook = true
+		o.MutatingWebhookConfigName = mutatingWebhookName
+		o.MutatingWebhookConfigPath = mutatingConfigPath
+		o.MetricsReporter = reporter
+	}}, opts...)...)
+	c.injectedFiles = map[string][]byte{
+		mutatingConfigPath: []byte(runtime.EncodeOrDie(codec, unpatchedMutatingWebhookConfig)),
+	}
+	c.endpointStore.Add(istiodEndpoint)
+	return c, reporter
+}
+
+// addMutatingConfig adds the config to the fake clientset and the informer.
+func addMutatingConfig(t *testing.T, c *fakeController, config *kubeApiAdmission.MutatingWebhookConfiguration) {
+	t.Helper()
+	if _, err := c.MutatingWebhookConfigurations().Create(config); err != nil {
+		t.Fatal(err)
+	}
+	mutatingStore := c.sharedInformers.Admissionregistration().V1beta1().MutatingWebhookConfigurations().Informer().GetStore()
+	if err := mutatingStore.Add(config); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func mutatingActions(c *fakeController) []k8stesting.Action {
+	var actions []k8stesting.Action
+	for _, action := range c.Actions() {
+		if action.GetResource().Resource == "mutatingwebhookconfigurations" {
+			actions = append(actions, action)
+		}
+	}
+	return actions
+}
+
+// The mutating config shares the guards of the validating configs.
+func TestMutatingWebhookGuards(t *testing.T) {
+	installed := unpatchedMutatingWebhookConfig.DeepCopy()
+	installed.Webhooks[0].ClientConfig.CABundle = caBundle0
+
+	t.Run("managed by other", func(t *testing.T) {
+		g := NewGomegaWithT(t)
+		c, _ := createMutatingTestController(t, func(o *Options) {
+			o.ManagedByLabelValue = "istiod-canary"
+		})
+		other := installed.DeepCopy()
+		other.Labels = map[string]string{managedByLabel: "Helm"}
+		addMutatingConfig(t, c, other)
+
+		c.ClearActions()
+		reconcileHelper(t, c)
+		g.Expect(mutatingActions(c)).Should(BeEmpty())
+	})
+
+	t.Run("not owned", func(t *testing.T) {
+		g := NewGomegaWithT(t)
+		c, reporter := createMutatingTestController(t, func(o *Options) {
+			o.RefuseUnowned = true
+		})
+		c.clusterRoleStore.Add(&kubeApiRbac.ClusterRole{
+			ObjectMeta: kubeApiMeta.ObjectMeta{Name: istiodClusterRole, UID: "uid-1"},
+		})
+		addMutatingConfig(t, c, installed.DeepCopy())
+
+		c.ClearActions()
+		reconcileHelper(t, c)
+		g.Expect(mutatingActions(c)).Should(BeEmpty())
+		g.Expect(reporter.mutatingUpdateErrors[mutatingWebhookName]).Should(Equal([]kubeApiMeta.StatusReason{reasonNotOwned}))
+		// after the event of the created validating config.
+		g.Expect(c.recorder.Events).Should(Receive(HavePrefix("Normal Created")))
+		g.Expect(c.recorder.Events).Should(Receive(HavePrefix("Warning UpdateFailed Not owned by clusterrole")))
+	})
+
+	t.Run("caBundle shrink", func(t *testing.T) {
+		g := NewGomegaWithT(t)
+		c, reporter := createMutatingTestController(t)
+		rotating := installed.DeepCopy()
+		rotating.Webhooks[0].ClientConfig.CABundle = bytes.Join([][]byte{caBundle0, caBundle1}, []byte("\n"))
+		addMutatingConfig(t, c, rotating)
+
+		c.ClearActions()
+		reconcileHelper(t, c)
+		g.Expect(mutatingActions(c)).Should(BeEmpty())
+		g.Expect(reporter.shrinkRefused[mutatingWebhookName]).Should(Equal(1))
+	})
+
+	t.Run("pre-apply", func(t *testing.T) {
+		g := NewGomegaWithT(t)
+		var validating []string
+		var reinvocation []*kubeApiAdmission.ReinvocationPolicyType
+		ifNeeded := kubeApiAdmission.IfNeededReinvocationPolicy
+		c, reporter := createMutatingTestController(t, func(o *Options) {
+			o.PreApply = func(config *kubeApiAdmission.ValidatingWebhookConfiguration) error {
+				validating = append(validating, config.Name)
+				return nil
+			}
+			o.PreApplyMutating = func(config *kubeApiAdmission.MutatingWebhookConfiguration) error {
+				reinvocation = append(reinvocation, config.Webhooks[0].ReinvocationPolicy)
+				return errors.New("no injection")
+			}
+		})
+		template := unpatchedMutatingWebhookConfig.DeepCopy()
+		template.Webhooks[0].ReinvocationPolicy = &ifNeeded
+		c.injectedFiles[mutatingConfigPath] = []byte(runtime.EncodeOrDie(codec, template))
+
+		reconcileHelper(t, c)
+		g.Expect(validating).Should(Equal([]string{galleyWebhookName}))
+		// the hook gets the whole mutating config.
+		g.Expect(reinvocation).Should(Equal([]*kubeApiAdmission.ReinvocationPolicyType{&ifNeeded}))
+		g.Expect(mutatingActions(c)).Should(BeEmpty())
+		g.Expect(reporter.mutatingUpdateErrors[mutatingWebhookName]).Should(Equal(
+			[]kubeApiMeta.StatusReason{reasonPreApplyRejected}))
+	})
+
+	t.Run("config checks", func(t *testing.T) {
+		g := NewGomegaWithT(t)
+		c, reporter := createMutatingTestController(t, func(o *Options) {
+			o.StrictServiceCheck = true
+		})
+		template := unpatchedMutatingWebhookConfig.DeepCopy()
+		template.Webhooks[0].ClientConfig.Service.Name = "other"
+		c.injectedFiles[mutatingConfigPath] = []byte(runtime.EncodeOrDie(codec, template))
+
+		reconcileHelper(t, c)
+		g.Expect(mutatingActions(c)).Should(BeEmpty())
+		g.Expect(reporter.loadErrors[mutatingWebhookName]).Should(Equal([]string{"service mismatch"}))
+	})
+}
+
+func TestMutatingWebhookConfigBuild(t *testing.T) {
+	g := NewGomegaWithT(t)
+	c, _ := createMutatingTestController(t, func(o *Options) {
+		o.PerWebhookCAPaths = map[string]string{"sidecar-injector.istio.io": "injector-ca.pem"}
+		o.ObjectSelector = &kubeApiMeta.LabelSelector{MatchLabels: map[string]string{"validate": "true"}}
+	})
+	template := unpatchedMutatingWebhookConfig.DeepCopy()
+	ifNeeded := kubeApiAdmission.IfNeededReinvocationPolicy
+	template.Webhooks[0].ReinvocationPolicy = &ifNeeded
+	c.injectedFiles = map[string][]byte{
+		mutatingConfigPath: []byte(runtime.EncodeOrDie(codec, template)),
+		"injector-ca.pem":  caBundle1,
+	}
+
+	reconcileHelper(t, c)
+	mutating, err := c.MutatingWebhookConfigurations().Get(mutatingWebhookName, kubeApiMeta.GetOptions{})
+	g.Expect(err).Should(Succeed())
+	g.Expect(mutating.Webhooks[0].ClientConfig.CABundle).Should(Equal(caBundle1))
+	g.Expect(mutating.Webhooks[0].ReinvocationPolicy).Should(Equal(&ifNeeded))
+	// the overrides of the validating webhooks aren't applied.
+	g.Expect(mutating.Webhooks[0].ObjectSelector).Should(BeNil())
+	// one event for each created config.
+	g.Expect(c.recorder.Events).Should(Receive(Equal("Normal Created Created by istio-validation-controller")))
+	g.Expect(c.recorder.Events).Should(Receive(Equal("Normal Created Created by istio-validation-controller")))
+}
+
+func TestMutatingWebhookServerSideApply(t *testing.T) {
+	g := NewGomegaWithT(t)
+	c, _ := createMutatingTestController(t, func(o *Options) {
+		o.UseServerSideApply = true
+	})
+	calls := fakeApply(c)
+	ifNeeded := kubeApiAdmission.IfNeededReinvocationPolicy
+	template := unpatchedMutatingWebhookConfig.DeepCopy()
+	template.Webhooks[0].ReinvocationPolicy = &ifNeeded
+	c.injectedFiles[mutatingConfigPath] = []byte(runtime.EncodeOrDie(codec, template))
+
+	reconcileHelper(t, c)
+	g.Expect(*calls).Should(HaveLen(2))
+	g.Expect((*calls)[0].name).Should(Equal(galleyWebhookName))
+	g.Expect((*calls)[1].resource).Should(Equal(mutatingConfigResource))
+	g.Expect((*calls)[1].name).Should(Equal(mutatingWebhookName))
+	applied := (*calls)[1].applied.(*kubeApiAdmission.MutatingWebhookConfiguration)
+	g.Expect(applied.Kind).Should(Equal(mutatingConfigGVK.Kind))
+	g.Expect(applied.Webhooks[0].ReinvocationPolicy).Should(Equal(&ifNeeded))
+	actions := mutatingActions(c)
+	g.Expect(actions).Should(HaveLen(1))
+	g.Expect(actions[0].Matches("patch", "mutatingwebhookconfigurations")).Should(BeTrue())
+}
+
+// The mutating config is updated through the same path as the validating
+// configs, including PreserveSelectors and the retry of a conflicting update.
+func TestMutatingWebhookUpdate(t *testing.T) {
+	g := NewGomegaWithT(t)
+	c, _ := createMutatingTestController(t, func(o *Options) {
+		o.PreserveSelectors = true
+	})
+	adminSelector := &kubeApiMeta.LabelSelector{MatchLabels: map[string]string{"inject": "true"}}
+	ifNeeded := kubeApiAdmission.IfNeededReinvocationPolicy
+	template := unpatchedMutatingWebhookConfig.DeepCopy()
+	template.Webhooks[0].ReinvocationPolicy = &ifNeeded
+	c.injectedFiles[mutatingConfigPath] = []byte(runtime.EncodeOrDie(codec, template))
+
+	cached := template.DeepCopy()
+	cached.ResourceVersion = "1"
+	cached.Webhooks[0].ClientConfig.CABundle = caBundle1
+	cached.Webhooks[0].NamespaceSelector = adminSelector
+	addMutatingConfig(t, c, cached)
+	// written concurrently and not yet observed by the informer.
+	live := cached.DeepCopy()
+	live.ResourceVersion = "2"
+	live.Labels = map[string]string{"concurrent": "write"}
+	_, err := c.MutatingWebhookConfigurations().Update(live)
+	g.Expect(err).Should(Succeed())
+
+	var resourceVersions []string
+	c.PrependReactor("update", "mutatingwebhookconfigurations",
+		func(action k8stesting.Action) (bool, runtime.Object, error) {
+			config := action.(k8stesting.UpdateAction).GetObject().(*kubeApiAdmission.MutatingWebhookConfiguration)
+			resourceVersions = append(resourceVersions, config.ResourceVersion)
+			if config.ResourceVersion != live.ResourceVersion {
+				return true, nil, kubeErrors.NewConflict(
+					kubeApiAdmission.Resource("mutatingwebhookconfigurations"), config.Name, errors.New("stale"))
+			}
+			return false, nil, nil
+		})
+
+	c.ClearActions()
+	reconcileHelper(t, c)
+	g.Expect(resourceVersions).Should(Equal([]string{"1", "2"}))
+	updated, err := c.MutatingWebhookConfigurations().Get(mutatingWebhookName, kubeApiMeta.GetOptions{})
+	g.Expect(err).Should(Succeed())
+	g.Expect(updated.Labels).Should(HaveKeyWithValue("concurrent", "write"))
+	g.Expect(updated.Webhooks[0].ClientConfig.CABundle).Should(Equal(caBundle0))
+	g.Expect(updated.Webhooks[0].NamespaceSelector).Should(Equal(adminSelector))
+	g.Expect(updated.Webhooks[0].ReinvocationPolicy).Should(Equal(&ifNeeded))
+}
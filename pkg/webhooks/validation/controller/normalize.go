@@ -0,0 +1,154 @@
+// Copyright 2019 Istio Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package controller
+
+import (
+	"encoding/json"
+	"reflect"
+
+	kubeApiAdmission "k8s.io/api/admissionregistration/v1beta1"
+	kubeApiMeta "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"sigs.k8s.io/yaml"
+)
+
+const lastAppliedAnnotation = "kubectl.kubernetes.io/last-applied-configuration"
+
+// NormalizedConfig returns the named webhook config as installed in the
+// cluster, normalized to the canonical form of its template. Server-set
+// metadata, fields stamped by the controller, and fields equal to their
+// server-side defaults are removed. The result is serialized as YAML with
+// sorted keys so it can be diffed against the template by GitOps tooling.
+func (c *Controller) NormalizedConfig(name string) ([]byte, error) {
+	current, err := c.sharedInformers.Admissionregistration().V1beta1().
+		ValidatingWebhookConfigurations().Lister().Get(name)
+	if err != nil {
+		return nil, err
+	}
+	return marshalNormalized(normalizeConfig(c.options(), current))
+}
+
+// marshalNormalized serializes the normalized config as YAML with sorted
+// keys. The null creationTimestamp every ObjectMeta is serialized with is
+// left out, since no template sets it.
+func marshalNormalized(config *kubeApiAdmission.ValidatingWebhookConfiguration) ([]byte, error) {
+	encoded, err := json.Marshal(config)
+	if err != nil {
+		return nil, err
+	}
+	var object map[string]interface{}
+	if err := json.Unmarshal(encoded, &object); err != nil {
+		return nil, err
+	}
+	if metadata, ok := object["metadata"].(map[string]interface{}); ok {
+		delete(metadata, "creationTimestamp")
+	}
+	return yaml.Marshal(object)
+}
+
+// normalizeConfig returns a copy of the config with server-set metadata,
+// controller-stamped fields, and server-side defaults removed. The stamped
+// fields are the caBundle, the revision, managed-by and ManagedLabels
+// labels, and the fields overridden by the options: the failurePolicy,
+// objectSelector and timeoutSeconds of every webhook, and the port of the
+// webhook service once ServicePortName is resolved. Overridden fields are
+// removed whatever their value, since the value of the template is lost.
+func normalizeConfig(o Options, in *kubeApiAdmission.ValidatingWebhookConfiguration) *kubeApiAdmission.ValidatingWebhookConfiguration { // nolint: lll
+	stampedLabels := append([]string{revisionLabel, managedByLabel}, sortedKeys(o.ManagedLabels)...)
+	config := &kubeApiAdmission.ValidatingWebhookConfiguration{
+		TypeMeta: kubeApiMeta.TypeMeta{
+			APIVersion: kubeApiAdmission.SchemeGroupVersion.String(),
+			Kind:       configGVK.Kind,
+		},
+		ObjectMeta: kubeApiMeta.ObjectMeta{
+			Name:        in.Name,
+			Labels:      normalizeMap(in.Labels, stampedLabels...),
+			Annotations: normalizeMap(in.Annotations, lastAppliedAnnotation),
+		},
+	}
+	for _, in := range in.Webhooks {
+		webhook := *in.DeepCopy()
+		if !containsName(o.SkipCAInjectionWebhooks, webhook.Name) {
+			webhook.ClientConfig.CABundle = nil
+		}
+		if o.FailurePolicyOverride != nil {
+			webhook.FailurePolicy = nil
+		}
+		if o.ObjectSelector != nil {
+			webhook.ObjectSelector = nil
+		}
+		if o.WebhookTimeoutSeconds != nil {
+			webhook.TimeoutSeconds = nil
+		}
+		if service := webhook.ClientConfig.Service; o.ServicePortName != "" && service != nil &&
+			service.Name == o.ServiceName && service.Namespace == o.serviceNamespace() {
+			service.Port = nil
+		}
+		normalizeWebhookDefaults(&webhook)
+		config.Webhooks = append(config.Webhooks, webhook)
+	}
+	return config
+}
+
+// normalizeMap returns a copy of in without the given keys, or nil if nothing remains.
+func normalizeMap(in map[string]string, drop ...string) map[string]string {
+	var out map[string]string
+	for k, v := range in {
+		if containsName(drop, k) {
+			continue
+		}
+		if out == nil {
+			out = make(map[string]string)
+		}
+		out[k] = v
+	}
+	return out
+}
+
+// normalizeWebhookDefaults clears fields which are equal to their defaults:
+// the failurePolicy, sideEffects and namespaceSelector filled in when the
+// template is decoded, and the other fields defaulted by the kube-apiserver
+// for admissionregistration.k8s.io/v1beta1. The failurePolicy is always
+// written, so the Ignore default of the kube-apiserver never applies.
+func normalizeWebhookDefaults(webhook *kubeApiAdmission.ValidatingWebhook) {
+	if webhook.FailurePolicy != nil && *webhook.FailurePolicy == failurePolicyFail {
+		webhook.FailurePolicy = nil
+	}
+	if webhook.MatchPolicy != nil && *webhook.MatchPolicy == kubeApiAdmission.Exact {
+		webhook.MatchPolicy = nil
+	}
+	if webhook.SideEffects != nil && *webhook.SideEffects == sideEffectsUnknown {
+		webhook.SideEffects = nil
+	}
+	if webhook.TimeoutSeconds != nil && *webhook.TimeoutSeconds == 30 {
+		webhook.TimeoutSeconds = nil
+	}
+	if reflect.DeepEqual(webhook.NamespaceSelector, &kubeApiMeta.LabelSelector{}) {
+		webhook.NamespaceSelector = nil
+	}
+	if reflect.DeepEqual(webhook.ObjectSelector, &kubeApiMeta.LabelSelector{}) {
+		webhook.ObjectSelector = nil
+	}
+	if reflect.DeepEqual(webhook.AdmissionReviewVersions, []string{"v1beta1"}) {
+		webhook.AdmissionReviewVersions = nil
+	}
+	if service := webhook.ClientConfig.Service; service != nil && service.Port != nil && *service.Port == 443 {
+		service.Port = nil
+	}
+	for i := range webhook.Rules {
+		if scope := webhook.Rules[i].Scope; scope != nil && *scope == kubeApiAdmission.AllScopes {
+			webhook.Rules[i].Scope = nil
+		}
+	}
+}
@@ -0,0 +1,173 @@
+// Copyright 2019 Istio Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package controller
+
+import (
+	"testing"
+
+	. "github.com/onsi/gomega"
+	kubeApiAdmission "k8s.io/api/admissionregistration/v1beta1"
+	kubeErrors "k8s.io/apimachinery/pkg/api/errors"
+	kubeApiMeta "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+func TestNormalizedConfig(t *testing.T) {
+	g := NewGomegaWithT(t)
+	c := createTestController(t, func(o *Options) {
+		o.Revision = "canary"
+	})
+
+	// simulate the server-set metadata and defaults of the installed config.
+	live := webhookConfigWithCABundle0.DeepCopyObject().(*kubeApiAdmission.ValidatingWebhookConfiguration)
+	live.TypeMeta = kubeApiMeta.TypeMeta{}
+	live.UID = "uid"
+	live.ResourceVersion = "12"
+	live.Generation = 3
+	live.OwnerReferences = []kubeApiMeta.OwnerReference{{Name: istiodClusterRole}}
+	live.Labels = map[string]string{revisionLabel: "canary", managedByLabel: managedByValue}
+	matchPolicy := kubeApiAdmission.Exact
+	timeout := int32(30)
+	port := int32(443)
+	allScopes := kubeApiAdmission.AllScopes
+	for i := range live.Webhooks {
+		webhook := &live.Webhooks[i]
+		webhook.MatchPolicy = &matchPolicy
+		webhook.TimeoutSeconds = &timeout
+		webhook.ObjectSelector = &kubeApiMeta.LabelSelector{}
+		webhook.AdmissionReviewVersions = []string{"v1beta1"}
+		webhook.ClientConfig.Service.Port = &port
+		webhook.Rules[0].Scope = &allScopes
+	}
+	c.configStore.Add(live)
+
+	normalized, err := c.NormalizedConfig(galleyWebhookName)
+	g.Expect(err).Should(Succeed())
+	template, err := marshalNormalized(normalizeConfig(c.o, unpatchedIstiodWebhookConfig))
+	g.Expect(err).Should(Succeed())
+	g.Expect(string(normalized)).Should(Equal(string(template)))
+	g.Expect(string(normalized)).ShouldNot(ContainSubstring("caBundle"))
+	g.Expect(string(normalized)).ShouldNot(ContainSubstring("resourceVersion"))
+	g.Expect(string(normalized)).ShouldNot(ContainSubstring("failurePolicy"), "Fail is the default of the template")
+
+	_, err = c.NormalizedConfig("missing")
+	g.Expect(kubeErrors.IsNotFound(err)).Should(BeTrue())
+}
+
+func TestNormalizedConfigRoundTrip(t *testing.T) {
+	g := NewGomegaWithT(t)
+	c := createTestController(t)
+
+	// hook0 leaves the failurePolicy, sideEffects and namespaceSelector to
+	// their defaults, which hook1 overrides.
+	template := []byte(`apiVersion: admissionregistration.k8s.io/v1beta1
+kind: ValidatingWebhookConfiguration
+metadata:
+  labels:
+    app: istiod
+  name: ` + galleyWebhookName + `
+webhooks:
+- clientConfig:
+    service:
+      name: ` + istiod + `
+      namespace: ` + namespace + `
+      path: /hook0
+  name: hook0
+  rules:
+  - apiGroups:
+    - group0
+    apiVersions:
+    - '*'
+    operations:
+    - CREATE
+    resources:
+    - '*'
+- clientConfig:
+    service:
+      name: ` + istiod + `
+      namespace: ` + namespace + `
+      path: /hook1
+  failurePolicy: Ignore
+  name: hook1
+  namespaceSelector:
+    matchLabels:
+      validate: "true"
+  rules:
+  - apiGroups:
+    - group1
+    apiVersions:
+    - '*'
+    operations:
+    - CREATE
+    resources:
+    - '*'
+  sideEffects: None
+`)
+	c.injectedMu.Lock()
+	c.injectedConfig = template
+	c.injectedMu.Unlock()
+	c.endpointStore.Add(istiodEndpoint)
+	reconcileHelper(t, c)
+
+	// simulate the defaults of the kube-apiserver on the installed config.
+	live, err := c.ValidatingWebhookConfigurations().Get(galleyWebhookName, kubeApiMeta.GetOptions{})
+	g.Expect(err).Should(Succeed())
+	matchPolicy := kubeApiAdmission.Exact
+	timeout := int32(30)
+	for i := range live.Webhooks {
+		webhook := &live.Webhooks[i]
+		webhook.MatchPolicy = &matchPolicy
+		webhook.TimeoutSeconds = &timeout
+		webhook.ObjectSelector = &kubeApiMeta.LabelSelector{}
+		webhook.AdmissionReviewVersions = []string{"v1beta1"}
+	}
+	c.configStore.Add(live)
+
+	normalized, err := c.NormalizedConfig(galleyWebhookName)
+	g.Expect(err).Should(Succeed())
+	g.Expect(string(normalized)).Should(Equal(string(template)))
+}
+
+func TestNormalizedConfigOverrides(t *testing.T) {
+	g := NewGomegaWithT(t)
+	ignore := kubeApiAdmission.Ignore
+	timeout := int32(5)
+	c := createTestController(t, func(o *Options) {
+		o.ManagedLabels = map[string]string{"team": "mesh"}
+		o.FailurePolicyOverride = &ignore
+		o.ObjectSelector = &kubeApiMeta.LabelSelector{MatchLabels: map[string]string{"validate": "true"}}
+		o.WebhookTimeoutSeconds = &timeout
+		o.ServicePortName = "https-webhook"
+	})
+
+	live := webhookConfigWithCABundle0.DeepCopyObject().(*kubeApiAdmission.ValidatingWebhookConfiguration)
+	live.Labels = map[string]string{"team": "mesh", "app": "istiod"}
+	port := int32(15017)
+	failurePolicy := kubeApiAdmission.Fail
+	for i := range live.Webhooks {
+		webhook := &live.Webhooks[i]
+		webhook.FailurePolicy = &failurePolicy
+		webhook.ObjectSelector = c.o.ObjectSelector.DeepCopy()
+		webhook.TimeoutSeconds = &timeout
+		webhook.ClientConfig.Service.Port = &port
+	}
+	c.configStore.Add(live)
+
+	normalized, err := c.NormalizedConfig(galleyWebhookName)
+	g.Expect(err).Should(Succeed())
+	for _, stamped := range []string{"team", "failurePolicy", "objectSelector", "timeoutSeconds", "port"} {
+		g.Expect(string(normalized)).ShouldNot(ContainSubstring(stamped))
+	}
+	g.Expect(string(normalized)).Should(ContainSubstring("app: istiod"))
+}
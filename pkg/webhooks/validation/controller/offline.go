@@ -0,0 +1,62 @@
+// Copyright 2019 Istio Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package controller
+
+import (
+	"fmt"
+	"io/ioutil"
+	"time"
+
+	"github.com/hashicorp/go-multierror"
+)
+
+// ValidateWebhookTemplate runs the controller's build and validation
+// pipeline against a local validatingwebhookconfiguration template and CA
+// bundle without contacting a cluster. Options that affect how the config
+// is built and validated are honored. All problems found are returned as a
+// single aggregated error. This is intended for linting templates in CI.
+func ValidateWebhookTemplate(templatePath, caPath string, o Options) error {
+	var errs *multierror.Error
+	webhook, err := ioutil.ReadFile(templatePath)
+	if err != nil {
+		errs = multierror.Append(errs, fmt.Errorf("could not read validatingwebhookconfiguration file: %v", err))
+	}
+	caBundle, err := ioutil.ReadFile(caPath)
+	if err != nil {
+		errs = multierror.Append(errs, fmt.Errorf("could not read caBundle file: %v", err))
+	}
+	webhookCABundles, cerr := readWebhookCABundles(o.PerWebhookCAPaths, ioutil.ReadFile)
+	if cerr != nil {
+		errs = multierror.Append(errs, fmt.Errorf("%v: %v", cerr.Reason(), cerr))
+	}
+	if errs != nil {
+		return errs
+	}
+
+	now := time.Now()
+	if err := verifyCABundleValidity(caBundle, now, o.CertValiditySkew); err != nil {
+		errs = multierror.Append(errs, fmt.Errorf("%v: %v", err.Reason(), err))
+	}
+	for _, name := range sortedKeys(webhookCABundles) {
+		if err := verifyCABundleValidity(webhookCABundles[name], now, o.CertValiditySkew); err != nil {
+			errs = multierror.Append(errs, fmt.Errorf("%v: webhook %v: %v", err.Reason(), name, err))
+		}
+	}
+	_, configErrs := buildAndValidateConfig(o, caBundle, webhookCABundles, webhook, nil, false)
+	for _, err := range configErrs {
+		errs = multierror.Append(errs, fmt.Errorf("%v: %v", err.Reason(), err))
+	}
+	return errs.ErrorOrNil()
+}
@@ -0,0 +1,71 @@
+// Copyright 2019 Istio Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package controller
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	. "github.com/onsi/gomega"
+	kubeApiAdmission "k8s.io/api/admissionregistration/v1beta1"
+	"k8s.io/apimachinery/pkg/runtime"
+)
+
+func TestValidateWebhookTemplate(t *testing.T) {
+	g := NewGomegaWithT(t)
+
+	dir, err := ioutil.TempDir("", "validate-webhook-template")
+	g.Expect(err).Should(Succeed())
+	defer func() { _ = os.RemoveAll(dir) }()
+
+	write := func(name string, contents []byte) string {
+		path := filepath.Join(dir, name)
+		g.Expect(ioutil.WriteFile(path, contents, 0644)).Should(Succeed())
+		return path
+	}
+
+	duplicate := unpatchedIstiodWebhookConfig.DeepCopyObject().(*kubeApiAdmission.ValidatingWebhookConfiguration)
+	duplicate.Webhooks[1].Name = duplicate.Webhooks[0].Name
+
+	goodTemplate := write("good.yaml", []byte(istiodWebhookConfigEncoded))
+	duplicateTemplate := write("duplicate.yaml", []byte(runtime.EncodeOrDie(codec, duplicate)))
+	badTemplate := write("bad.yaml", []byte("bad configfile"))
+	goodCA := write("good-ca.pem", caBundle0)
+	badCA := write("bad-ca.pem", []byte("bad cert"))
+
+	g.Expect(ValidateWebhookTemplate(goodTemplate, goodCA, Options{})).Should(Succeed())
+
+	err = ValidateWebhookTemplate(duplicateTemplate, badCA, Options{})
+	g.Expect(err).ShouldNot(Succeed())
+	g.Expect(err.Error()).Should(ContainSubstring("could not verify caBundle"))
+	g.Expect(err.Error()).Should(ContainSubstring("duplicate webhook names"))
+
+	g.Expect(ValidateWebhookTemplate(duplicateTemplate, goodCA, Options{DedupWebhooks: true})).Should(Succeed())
+
+	expiredCA := write("expired-ca.pem", caBundle1)
+	err = ValidateWebhookTemplate(goodTemplate, expiredCA, Options{})
+	g.Expect(err).ShouldNot(Succeed())
+	g.Expect(err.Error()).Should(ContainSubstring("caBundle expired"))
+
+	err = ValidateWebhookTemplate(badTemplate, goodCA, Options{})
+	g.Expect(err).ShouldNot(Succeed())
+	g.Expect(err.Error()).Should(ContainSubstring("could not decode validatingwebhookconfiguration file"))
+
+	err = ValidateWebhookTemplate(filepath.Join(dir, "missing.yaml"), goodCA, Options{})
+	g.Expect(err).ShouldNot(Succeed())
+	g.Expect(err.Error()).Should(ContainSubstring("could not read validatingwebhookconfiguration file"))
+}
@@ -0,0 +1,226 @@
+// Copyright 2019 Istio Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package controller
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	"go.opencensus.io/stats"
+	"go.opencensus.io/stats/view"
+	"go.opencensus.io/tag"
+	"k8s.io/client-go/util/workqueue"
+)
+
+// queueName is the name of the reconcile queue in the workqueue metrics.
+const queueName = "validation_controller"
+
+// queueTag holds the name of the workqueue for the context.
+var queueTag tag.Key
+
+var (
+	metricQueueDepth = stats.Float64(
+		"galley/validation/queue_depth",
+		"current depth of the reconcile queue",
+		stats.UnitDimensionless)
+	metricQueueAdds = stats.Int64(
+		"galley/validation/queue_adds",
+		"reconcile requests added to the queue",
+		stats.UnitDimensionless)
+	metricQueueLatency = stats.Float64(
+		"galley/validation/queue_latency_seconds",
+		"seconds a reconcile request waits in the queue before it is processed",
+		"s")
+	metricQueueWorkDuration = stats.Float64(
+		"galley/validation/queue_work_duration_seconds",
+		"seconds processing a reconcile request takes",
+		"s")
+	metricQueueUnfinishedWork = stats.Float64(
+		"galley/validation/queue_unfinished_work_seconds",
+		"seconds of work in progress which hasn't been observed by the work duration",
+		"s")
+	metricQueueLongestRunningProcessor = stats.Float64(
+		"galley/validation/queue_longest_running_processor_seconds",
+		"seconds the longest running reconcile has been in progress",
+		"s")
+	metricQueueRetries = stats.Int64(
+		"galley/validation/queue_retries",
+		"reconcile requests retried with rate limiting",
+		stats.UnitDimensionless)
+)
+
+func init() {
+	var err error
+	if queueTag, err = tag.NewKey("queue"); err != nil {
+		panic(err)
+	}
+
+	queueKeys := []tag.Key{queueTag}
+	seconds := view.Distribution(.001, .01, .1, 1, 10, 60, 300)
+	err = view.Register(
+		newView(metricQueueDepth, queueKeys, view.LastValue()),
+		newView(metricQueueAdds, queueKeys, view.Count()),
+		newView(metricQueueLatency, queueKeys, seconds),
+		newView(metricQueueWorkDuration, queueKeys, seconds),
+		newView(metricQueueUnfinishedWork, queueKeys, view.LastValue()),
+		newView(metricQueueLongestRunningProcessor, queueKeys, view.LastValue()),
+		newView(metricQueueRetries, queueKeys, view.Count()),
+	)
+	if err != nil {
+		panic(err)
+	}
+}
+
+// unfinishedWorkUpdatePeriod is how often the depth and unfinished work of
+// the queue are recorded, as by the workqueue package.
+const unfinishedWorkUpdatePeriod = 500 * time.Millisecond
+
+// metricsQueue is a rate limiting workqueue which records the workqueue
+// metrics with the metrics of this package. workqueue.SetProvider is
+// process-wide and only the first provider takes effect, so the metrics are
+// recorded by the queue itself instead. An item added with a delay is
+// counted when it is added and its latency is measured from when it is due.
+type metricsQueue struct {
+	workqueue.RateLimitingInterface
+	rateLimiter workqueue.RateLimiter
+	ctx         context.Context
+
+	mu        sync.Mutex
+	addedAt   map[interface{}]time.Time
+	startedAt map[interface{}]time.Time
+}
+
+var _ workqueue.RateLimitingInterface = &metricsQueue{}
+
+// newMetricsQueue returns a rate limiting queue whose metrics are recorded
+// with the given queue name. The depth and unfinished work are only
+// recorded periodically by run.
+func newMetricsQueue(rateLimiter workqueue.RateLimiter, name string) *metricsQueue {
+	return &metricsQueue{
+		RateLimitingInterface: workqueue.NewRateLimitingQueue(rateLimiter),
+		rateLimiter:           rateLimiter,
+		ctx:                   queueContext(name),
+		addedAt:               make(map[interface{}]time.Time),
+		startedAt:             make(map[interface{}]time.Time),
+	}
+}
+
+func (q *metricsQueue) Add(item interface{}) {
+	q.added(item, time.Now())
+	q.RateLimitingInterface.Add(item)
+	q.record(metricQueueDepth.M(float64(q.Len())))
+}
+
+// AddAfter adds the item once the delay passed. As with the workqueue
+// package, an item already waiting is added at the earlier of both times.
+func (q *metricsQueue) AddAfter(item interface{}, duration time.Duration) {
+	if q.ShuttingDown() {
+		return
+	}
+	if duration <= 0 {
+		q.Add(item)
+		return
+	}
+	q.added(item, time.Now().Add(duration))
+	q.RateLimitingInterface.AddAfter(item, duration)
+}
+
+func (q *metricsQueue) AddRateLimited(item interface{}) {
+	q.record(metricQueueRetries.M(1))
+	q.AddAfter(item, q.rateLimiter.When(item))
+}
+
+func (q *metricsQueue) Get() (interface{}, bool) {
+	item, shutdown := q.RateLimitingInterface.Get()
+	if shutdown {
+		return item, shutdown
+	}
+	now := time.Now()
+	q.mu.Lock()
+	if addedAt, ok := q.addedAt[item]; ok {
+		q.record(metricQueueLatency.M(now.Sub(addedAt).Seconds()))
+		delete(q.addedAt, item)
+	}
+	q.startedAt[item] = now
+	q.mu.Unlock()
+	q.record(metricQueueDepth.M(float64(q.Len())))
+	return item, false
+}
+
+func (q *metricsQueue) Done(item interface{}) {
+	q.mu.Lock()
+	if startedAt, ok := q.startedAt[item]; ok {
+		q.record(metricQueueWorkDuration.M(time.Since(startedAt).Seconds()))
+		delete(q.startedAt, item)
+	}
+	q.mu.Unlock()
+	q.RateLimitingInterface.Done(item)
+}
+
+// added counts the add of the item, which is due at the given time.
+func (q *metricsQueue) added(item interface{}, due time.Time) {
+	q.mu.Lock()
+	if addedAt, ok := q.addedAt[item]; !ok || due.Before(addedAt) {
+		q.addedAt[item] = due
+	}
+	q.mu.Unlock()
+	q.record(metricQueueAdds.M(1))
+}
+
+// run periodically records the depth, which changes when delayed items are
+// due, and the work in progress, which isn't observed by the work duration
+// until it is done, until stop is closed.
+func (q *metricsQueue) run(stop <-chan struct{}) {
+	ticker := time.NewTicker(unfinishedWorkUpdatePeriod)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-stop:
+			return
+		case <-ticker.C:
+			q.record(metricQueueDepth.M(float64(q.Len())))
+			q.recordUnfinishedWork()
+		}
+	}
+}
+
+func (q *metricsQueue) recordUnfinishedWork() {
+	now := time.Now()
+	var total, longest float64
+	q.mu.Lock()
+	for _, startedAt := range q.startedAt {
+		running := now.Sub(startedAt).Seconds()
+		total += running
+		if running > longest {
+			longest = running
+		}
+	}
+	q.mu.Unlock()
+	q.record(metricQueueUnfinishedWork.M(total), metricQueueLongestRunningProcessor.M(longest))
+}
+
+func (q *metricsQueue) record(measurements ...stats.Measurement) {
+	stats.Record(q.ctx, measurements...)
+}
+
+func queueContext(name string) context.Context {
+	ctx, err := tag.New(context.Background(), tag.Insert(queueTag, name))
+	if err != nil {
+		scope.Errorf("Error creating monitoring context for queue %v: %v", name, err)
+		return context.Background()
+	}
+	return ctx
+}
@@ -0,0 +1,107 @@
+// Copyright 2019 Istio Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package controller
+
+import (
+	goruntime "runtime"
+	"testing"
+	"time"
+
+	. "github.com/onsi/gomega"
+	"go.opencensus.io/stats/view"
+	"k8s.io/client-go/util/workqueue"
+)
+
+// queueMetric returns the value of the metric of the named queue, or -1 if
+// it wasn't recorded.
+func queueMetric(t *testing.T, metric, name string) float64 {
+	t.Helper()
+	rows, err := view.RetrieveData(metric)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, row := range rows {
+		if len(row.Tags) != 1 || row.Tags[0].Value != name {
+			continue
+		}
+		switch data := row.Data.(type) {
+		case *view.LastValueData:
+			return data.Value
+		case *view.CountData:
+			return float64(data.Value)
+		}
+	}
+	return -1
+}
+
+func TestQueueMetrics(t *testing.T) {
+	g := NewGomegaWithT(t)
+
+	c := createTestController(t)
+	c.queue.Add(&reconcileRequest{description: "test"})
+	g.Expect(queueMetric(t, metricQueueDepth.Name(), queueName)).Should(BeNumerically(">=", 1))
+
+	// the counts accumulate across test runs in the process.
+	const name = "test_queue_metrics"
+	count := func(metric string) float64 {
+		if value := queueMetric(t, metric, name); value > 0 {
+			return value
+		}
+		return 0
+	}
+	adds, retries := count(metricQueueAdds.Name()), count(metricQueueRetries.Name())
+
+	queue := newMetricsQueue(workqueue.DefaultItemBasedRateLimiter(), name)
+	defer queue.ShutDown()
+	queue.Add("a")
+	queue.Add("b")
+	g.Expect(queueMetric(t, metricQueueDepth.Name(), name)).Should(Equal(2.0))
+	g.Expect(count(metricQueueAdds.Name()) - adds).Should(Equal(2.0))
+
+	// drained by a worker.
+	item, _ := queue.Get()
+	g.Expect(queueMetric(t, metricQueueDepth.Name(), name)).Should(Equal(1.0))
+	queue.AddRateLimited(item)
+	g.Expect(count(metricQueueRetries.Name()) - retries).Should(Equal(1.0))
+	g.Expect(count(metricQueueAdds.Name()) - adds).Should(Equal(3.0))
+	// the work in progress is recorded while the queue runs.
+	stop := make(chan struct{})
+	go queue.run(stop)
+	g.Eventually(func() float64 {
+		return queueMetric(t, metricQueueLongestRunningProcessor.Name(), name)
+	}).Should(BeNumerically(">", 0))
+	close(stop)
+	queue.Done(item)
+	// added once the rate limiting delay passed.
+	g.Eventually(queue.Len).Should(Equal(2))
+
+	// the queues of other packages don't record these metrics.
+	const other = "test_queue_metrics_other"
+	named := workqueue.NewNamedRateLimitingQueue(workqueue.DefaultItemBasedRateLimiter(), other)
+	defer named.ShutDown()
+	named.Add("a")
+	g.Expect(queueMetric(t, metricQueueAdds.Name(), other)).Should(Equal(-1.0))
+}
+
+func TestQueueMetricsShutDown(t *testing.T) {
+	g := NewGomegaWithT(t)
+	before := goruntime.NumGoroutine()
+
+	queue := newMetricsQueue(workqueue.DefaultItemBasedRateLimiter(), "test_queue_metrics_shutdown")
+	queue.AddAfter("a", time.Hour)
+	queue.ShutDown()
+	g.Eventually(goruntime.NumGoroutine, 10*time.Second, 10*time.Millisecond).Should(BeNumerically("<=", before),
+		"the goroutines of the queue should exit")
+}
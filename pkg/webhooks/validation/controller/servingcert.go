@@ -0,0 +1,84 @@
+// Copyright 2019 Istio Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package controller
+
+import (
+	"crypto/x509"
+	"encoding/pem"
+	"errors"
+	"fmt"
+)
+
+// checkServingCert warns when the webhook server's serving certificate no
+// longer chains to the CA bundle patched into the webhook config. This
+// catches rotations which update one file but not the other.
+func (c *Controller) checkServingCert(o Options) {
+	servingCert, err := c.readFile(o.ServingCertPath)
+	if err != nil {
+		scope.Warnf("Could not read serving cert %v: %v", o.ServingCertPath, err)
+		return
+	}
+	caBundle, cerr := c.readCABundles(o)
+	if cerr != nil {
+		scope.Warnf("Could not read caBundle: %v", cerr)
+		return
+	}
+	if err := verifyServingCert(servingCert, caBundle); err != nil {
+		scope.Warnf("Serving cert %v does not chain to the caBundle: %v", o.ServingCertPath, err)
+		c.metrics.ReportServingCertMismatch()
+	}
+}
+
+// verifyServingCert verifies the leaf certificate in servingCert chains to one
+// of the roots in caBundle. Any additional certificates in servingCert are
+// treated as intermediates.
+func verifyServingCert(servingCert, caBundle []byte) error {
+	roots := x509.NewCertPool()
+	if !roots.AppendCertsFromPEM(caBundle) {
+		return errors.New("caBundle contains no certificates")
+	}
+
+	var leaf *x509.Certificate
+	intermediates := x509.NewCertPool()
+	for rest := servingCert; ; {
+		var block *pem.Block
+		block, rest = pem.Decode(rest)
+		if block == nil {
+			break
+		}
+		if block.Type != "CERTIFICATE" {
+			continue
+		}
+		cert, err := x509.ParseCertificate(block.Bytes)
+		if err != nil {
+			return fmt.Errorf("serving cert contains invalid x509 certificate: %v", err)
+		}
+		if leaf == nil {
+			leaf = cert
+		} else {
+			intermediates.AddCert(cert)
+		}
+	}
+	if leaf == nil {
+		return errors.New("serving cert contains no certificates")
+	}
+
+	_, err := leaf.Verify(x509.VerifyOptions{
+		Roots:         roots,
+		Intermediates: intermediates,
+		KeyUsages:     []x509.ExtKeyUsage{x509.ExtKeyUsageAny},
+	})
+	return err
+}
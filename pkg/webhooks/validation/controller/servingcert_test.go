@@ -0,0 +1,60 @@
+// Copyright 2019 Istio Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package controller
+
+import (
+	"testing"
+
+	"github.com/fsnotify/fsnotify"
+	. "github.com/onsi/gomega"
+
+	"istio.io/istio/pkg/mcp/testing/testcerts"
+)
+
+func TestVerifyServingCert(t *testing.T) {
+	g := NewGomegaWithT(t)
+
+	g.Expect(verifyServingCert(testcerts.ServerCert, testcerts.CACert)).Should(Succeed())
+	g.Expect(verifyServingCert(testcerts.RotatedCert, testcerts.CACert)).ShouldNot(Succeed())
+	g.Expect(verifyServingCert(testcerts.ServerCert, []byte("bad cert"))).ShouldNot(Succeed())
+	g.Expect(verifyServingCert([]byte("bad cert"), testcerts.CACert)).ShouldNot(Succeed())
+}
+
+func TestServingCertRotation(t *testing.T) {
+	g := NewGomegaWithT(t)
+	const servingCertPath = "fakeServingCertPath"
+	c := createTestController(t, func(o *Options) {
+		o.ServingCertPath = servingCertPath
+	})
+	reporter := newFakeMetricsReporter()
+	c.metrics = reporter
+	c.injectedCABundle = testcerts.CACert
+	c.injectedFiles = map[string][]byte{servingCertPath: testcerts.ServerCert}
+
+	c.onFileChanged(servingCertPath, "serving cert file", fsnotify.Event{Name: servingCertPath})
+	g.Expect(reporter.certMismatch).Should(Equal(0))
+	g.Expect(c.queue.Len()).Should(Equal(0), "serving cert changes should not trigger a reconcile")
+
+	c.injectedFiles[servingCertPath] = testcerts.RotatedCert
+	c.onFileChanged(servingCertPath, "serving cert file", fsnotify.Event{Name: servingCertPath})
+	g.Expect(reporter.certMismatch).Should(Equal(1))
+
+	// rotating the CA to match the serving cert is also checked.
+	c.injectedFiles[servingCertPath] = testcerts.ServerCert
+	c.injectedCABundle = caBundle1
+	c.onFileChanged(caPath, "CA file", fsnotify.Event{Name: caPath})
+	g.Expect(reporter.certMismatch).Should(Equal(2))
+	g.Expect(c.queue.Len()).Should(Equal(1))
+}
@@ -0,0 +1,79 @@
+// Copyright 2019 Istio Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package controller
+
+import (
+	"context"
+
+	"go.opencensus.io/trace"
+)
+
+// SpanStarter starts a span as a child of the span in ctx, if any. It has
+// the signature of trace.StartSpan.
+type SpanStarter func(ctx context.Context, name string, o ...trace.StartOption) (context.Context, *trace.Span)
+
+// reconcileSpanName is the name of the span of each reconcile.
+const reconcileSpanName = "validationController.reconcile"
+
+// attributes of the reconcile span.
+const (
+	spanAttributeRequest = "request"
+	spanAttributeOutcome = "outcome"
+	spanAttributeWrite   = "write"
+)
+
+// reconcileSpan is the span of a reconcile in progress. It is nil when
+// tracing is disabled.
+type reconcileSpan struct {
+	span *trace.Span
+	// whether the reconcile created, updated or applied a config.
+	wrote bool
+}
+
+type reconcileSpanKey struct{}
+
+// startReconcileSpan starts the span of the reconcile if StartSpan is set.
+// The returned context carries the span so the writes can be recorded.
+func (c *Controller) startReconcileSpan(ctx context.Context, o Options, req *reconcileRequest) (context.Context, *reconcileSpan) {
+	if o.StartSpan == nil {
+		return ctx, nil
+	}
+	ctx, span := o.StartSpan(ctx, reconcileSpanName)
+	span.AddAttributes(trace.StringAttribute(spanAttributeRequest, req.description))
+	s := &reconcileSpan{span: span}
+	return context.WithValue(ctx, reconcileSpanKey{}, s), s
+}
+
+// end the span with the outcome of the reconcile. A reconcile which
+// returned an error or failed to build a config has an error status.
+func (s *reconcileSpan) end(outcome, failure string, err error) {
+	if s == nil {
+		return
+	}
+	if err != nil {
+		outcome = "error"
+	}
+	s.span.AddAttributes(
+		trace.StringAttribute(spanAttributeOutcome, outcome),
+		trace.BoolAttribute(spanAttributeWrite, s.wrote),
+	)
+	switch {
+	case err != nil:
+		s.span.SetStatus(trace.Status{Code: trace.StatusCodeUnknown, Message: err.Error()})
+	case failure != "":
+		s.span.SetStatus(trace.Status{Code: trace.StatusCodeInvalidArgument, Message: failure})
+	}
+	s.span.End()
+}
@@ -0,0 +1,104 @@
+// Copyright 2019 Istio Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package controller
+
+import (
+	"context"
+	"sync"
+	"testing"
+
+	. "github.com/onsi/gomega"
+	"go.opencensus.io/trace"
+	kubeApiAdmission "k8s.io/api/admissionregistration/v1beta1"
+	kubeErrors "k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/runtime"
+	k8stesting "k8s.io/client-go/testing"
+)
+
+// spanRecorder is an in-memory trace.Exporter.
+type spanRecorder struct {
+	mu    sync.Mutex
+	spans []*trace.SpanData
+}
+
+func (r *spanRecorder) ExportSpan(span *trace.SpanData) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.spans = append(r.spans, span)
+}
+
+func (r *spanRecorder) take() []*trace.SpanData {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	spans := r.spans
+	r.spans = nil
+	return spans
+}
+
+func TestReconcileSpans(t *testing.T) {
+	g := NewGomegaWithT(t)
+	recorder := &spanRecorder{}
+	trace.RegisterExporter(recorder)
+	defer trace.UnregisterExporter(recorder)
+
+	ctx, root := trace.StartSpan(context.Background(), "root", trace.WithSampler(trace.AlwaysSample()))
+	defer root.End()
+	c := createTestController(t, func(o *Options) {
+		o.StartSpan = func(_ context.Context, name string, opts ...trace.StartOption) (context.Context, *trace.Span) {
+			return trace.StartSpan(ctx, name, opts...)
+		}
+	})
+
+	reconcileHelper(t, c)
+	spans := recorder.take()
+	g.Expect(spans).Should(HaveLen(1))
+	g.Expect(spans[0].Name).Should(Equal(reconcileSpanName))
+	g.Expect(spans[0].ParentSpanID).Should(Equal(root.SpanContext().SpanID))
+	g.Expect(spans[0].Attributes).Should(Equal(map[string]interface{}{
+		spanAttributeRequest: "test",
+		spanAttributeOutcome: "endpoint not ready",
+		spanAttributeWrite:   false,
+	}))
+	g.Expect(spans[0].Status.Code).Should(BeEquivalentTo(trace.StatusCodeOK))
+
+	c.endpointStore.Add(istiodEndpoint)
+	reconcileHelper(t, c)
+	spans = recorder.take()
+	g.Expect(spans).Should(HaveLen(1))
+	g.Expect(spans[0].Attributes).Should(HaveKeyWithValue(spanAttributeOutcome, "installed"))
+	g.Expect(spans[0].Attributes).Should(HaveKeyWithValue(spanAttributeWrite, true))
+
+	c.configStore.Add(webhookConfigWithCABundle0)
+	c.injectedMu.Lock()
+	c.injectedCABundle = caBundle1
+	c.injectedMu.Unlock()
+	c.PrependReactor("update", "validatingwebhookconfigurations",
+		func(action k8stesting.Action) (bool, runtime.Object, error) {
+			return true, nil, kubeErrors.NewServerTimeout(
+				kubeApiAdmission.Resource("validatingwebhookconfigurations"), "update", 1)
+		})
+	reconcileHelper(t, c)
+	spans = recorder.take()
+	g.Expect(spans).Should(HaveLen(1))
+	g.Expect(spans[0].Attributes).Should(HaveKeyWithValue(spanAttributeOutcome, "error"))
+	g.Expect(spans[0].Attributes).Should(HaveKeyWithValue(spanAttributeWrite, false))
+	g.Expect(spans[0].Status.Code).Should(BeEquivalentTo(trace.StatusCodeUnknown))
+	g.Expect(spans[0].Status.Message).ShouldNot(BeEmpty())
+
+	// no spans without StartSpan.
+	c = createTestController(t)
+	reconcileHelper(t, c)
+	g.Expect(recorder.take()).Should(BeEmpty())
+}
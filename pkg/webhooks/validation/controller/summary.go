@@ -0,0 +1,140 @@
+// Copyright 2019 Istio Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package controller
+
+import (
+	"sync"
+	"time"
+
+	kubeErrors "k8s.io/apimachinery/pkg/api/errors"
+)
+
+// reconcileSummary accumulates the outcome of reconciles over the lifetime
+// of the controller so it can be logged when the controller stops.
+type reconcileSummary struct {
+	mu         sync.Mutex
+	reconciles int
+	successes  int
+	errors     map[string]int
+	lastState  string
+	// time and failure of the last reconcile. The failure is empty if it
+	// succeeded.
+	lastReconcile time.Time
+	lastFailure   string
+	// time the webhook configs were last observed in the state the
+	// controller wants, e.g. installed or unregistered, and the number of
+	// reconciles which failed in a row since.
+	lastSettled         time.Time
+	consecutiveFailures int
+}
+
+// readyFailureThreshold is the number of consecutive failed reconciles
+// after which the controller is no longer considered ready.
+const readyFailureThreshold = 5
+
+func newReconcileSummary() *reconcileSummary {
+	return &reconcileSummary{
+		errors:    make(map[string]int),
+		lastState: "not reconciled",
+	}
+}
+
+// record the outcome of a reconcile completed at now. A reconcile fails if
+// it returned an error or if failure names the reason it could not complete.
+func (s *reconcileSummary) record(now time.Time, failure string, err error) {
+	lastFailure := failure
+	if err != nil {
+		lastFailure = err.Error()
+		failure = string(kubeErrors.ReasonForError(err))
+		if failure == "" {
+			failure = "Unknown"
+		}
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.reconciles++
+	s.lastReconcile = now
+	s.lastFailure = lastFailure
+	if failure == "" {
+		s.successes++
+		s.consecutiveFailures = 0
+	} else {
+		s.errors[failure]++
+		s.consecutiveFailures++
+	}
+}
+
+// setSettled sets the state of a reconcile which left the webhook configs
+// the way the controller wants them at now, i.e. installed, unregistered,
+// deferred to galley or paused.
+func (s *reconcileSummary) setSettled(now time.Time, state string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.lastState = state
+	s.lastSettled = now
+}
+
+func (s *reconcileSummary) ready() bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.readyLocked()
+}
+
+func (s *reconcileSummary) readyLocked() bool {
+	return !s.lastSettled.IsZero() && s.consecutiveFailures < readyFailureThreshold
+}
+
+// Ready returns true once the webhook configs have been installed, or left
+// to galley, paused or unregistered as configured, unless reconciliation has
+// failed persistently since. It is intended to back a readiness probe.
+//
+// Ready stays false while the first install waits for the endpoint of
+// ServiceName to be ready. If that is the service of this process, e.g.
+// istiod, don't back its own readiness probe with Ready: the endpoint is
+// only ready once that probe passes, so neither ever becomes ready.
+func (c *Controller) Ready() bool {
+	return c.summary.ready()
+}
+
+func (s *reconcileSummary) state() string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.lastState
+}
+
+func (s *reconcileSummary) setState(state string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.lastState = state
+}
+
+// logSummary logs the accumulated reconcile summary along with whether the
+// managed webhook configs are currently installed.
+func (c *Controller) logSummary() {
+	lister := c.sharedInformers.Admissionregistration().V1beta1().ValidatingWebhookConfigurations().Lister()
+	installed := make(map[string]bool)
+	configs, _ := c.managedConfigs(c.options())
+	for _, config := range configs {
+		_, err := lister.Get(config.name)
+		installed[config.name] = err == nil
+	}
+
+	s := c.summary
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	scope.Infof("Reconcile summary: reconciles=%v successes=%v errors=%v lastState=%q installed=%v",
+		s.reconciles, s.successes, s.errors, s.lastState, installed)
+}
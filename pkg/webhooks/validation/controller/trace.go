@@ -0,0 +1,109 @@
+// Copyright 2019 Istio Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package controller
+
+import (
+	"encoding/json"
+	"fmt"
+	"time"
+)
+
+// ReconcileTrace records the decisions made by a single reconcile to
+// explain why the controller did, or didn't, change the webhook config.
+type ReconcileTrace struct {
+	// ID of the reconcile, increasing over the lifetime of the controller.
+	ID        uint64          `json:"id"`
+	Request   string          `json:"request"`
+	Start     time.Time       `json:"start"`
+	Decisions []TraceDecision `json:"decisions"`
+	Error     string          `json:"error,omitempty"`
+}
+
+// TraceDecision is the outcome of a single decision point of a reconcile.
+type TraceDecision struct {
+	Step    string `json:"step"`
+	Outcome string `json:"outcome"`
+}
+
+// TraceNextReconcile requests a trace of the next reconcile and enqueues
+// one. The trace is available from LastReconcileTrace once it completes.
+func (c *Controller) TraceNextReconcile() {
+	c.traceMu.Lock()
+	c.traceNext = true
+	c.traceMu.Unlock()
+
+	req := &reconcileRequest{description: "traced reconcile requested"}
+	c.queue.Add(req)
+}
+
+// LastReconcileTrace returns the trace of the most recent traced
+// reconcile, or nil if no reconcile has been traced.
+func (c *Controller) LastReconcileTrace() *ReconcileTrace {
+	c.traceMu.Lock()
+	defer c.traceMu.Unlock()
+	if c.lastTrace == nil {
+		return nil
+	}
+	trace := *c.lastTrace
+	trace.Decisions = append([]TraceDecision(nil), c.lastTrace.Decisions...)
+	return &trace
+}
+
+// beginTrace starts tracing the reconcile if TraceReconciles is set or a
+// trace was requested, and returns the trace to end with endTrace. Only one
+// reconcile is traced at a time. With multiple workers, the trace may
+// include decisions of reconciles running concurrently.
+func (c *Controller) beginTrace(o Options, req *reconcileRequest) *ReconcileTrace {
+	c.traceMu.Lock()
+	defer c.traceMu.Unlock()
+	c.reconcileID++
+	if (!o.TraceReconciles && !c.traceNext) || c.trace != nil {
+		return nil
+	}
+	c.traceNext = false
+	c.trace = &ReconcileTrace{
+		ID:      c.reconcileID,
+		Request: req.String(),
+		Start:   c.clock.Now(),
+	}
+	return c.trace
+}
+
+func (c *Controller) endTrace(trace *ReconcileTrace, err error) {
+	if trace == nil {
+		return
+	}
+	c.traceMu.Lock()
+	c.trace = nil
+	if err != nil {
+		trace.Error = err.Error()
+	}
+	c.lastTrace = trace
+	c.traceMu.Unlock()
+
+	if encoded, err := json.Marshal(trace); err == nil {
+		scope.Debugf("Reconcile trace: %s", encoded)
+	}
+}
+
+// traceDecision records the outcome of a decision point if the reconcile is traced.
+func (c *Controller) traceDecision(step, format string, args ...interface{}) {
+	c.traceMu.Lock()
+	defer c.traceMu.Unlock()
+	if c.trace == nil {
+		return
+	}
+	c.trace.Decisions = append(c.trace.Decisions, TraceDecision{Step: step, Outcome: fmt.Sprintf(format, args...)})
+}
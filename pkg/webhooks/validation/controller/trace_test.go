@@ -0,0 +1,58 @@
+// Copyright 2019 Istio Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package controller
+
+import (
+	"testing"
+
+	. "github.com/onsi/gomega"
+)
+
+func TestReconcileTrace(t *testing.T) {
+	g := NewGomegaWithT(t)
+	c := createTestController(t)
+
+	reconcileHelper(t, c)
+	g.Expect(c.LastReconcileTrace()).Should(BeNil(), "reconciles aren't traced by default")
+
+	c.endpointStore.Add(istiodEndpoint)
+	c.TraceNextReconcile()
+	g.Expect(c.queue.Len()).Should(Equal(1))
+	reconcileHelper(t, c)
+
+	trace := c.LastReconcileTrace()
+	g.Expect(trace).ShouldNot(BeNil())
+	g.Expect(trace.ID).Should(Equal(uint64(2)))
+	g.Expect(trace.Error).Should(BeEmpty())
+	g.Expect(trace.Decisions).Should(Equal([]TraceDecision{
+		{"endpoint ready", "true"},
+		{"galley running", "false"},
+		{"unregister", "false"},
+		{"build", galleyWebhookName + ": ok"},
+		{"diff", galleyWebhookName + ": not found"},
+		{"write", galleyWebhookName + ": created"},
+	}))
+
+	// only the requested reconcile is traced.
+	reconcileHelper(t, c)
+	g.Expect(c.LastReconcileTrace().ID).Should(Equal(uint64(2)))
+
+	c.o.TraceReconciles = true
+	c.configStore.Add(webhookConfigWithCABundle0)
+	reconcileHelper(t, c)
+	trace = c.LastReconcileTrace()
+	g.Expect(trace.ID).Should(Equal(uint64(4)))
+	g.Expect(trace.Decisions).Should(ContainElement(TraceDecision{"diff", galleyWebhookName + ": changed=false"}))
+}